@@ -3,28 +3,101 @@
 package main
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
+	"html"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
 
+	"github.com/abdo-355/http-from-tcp/internal/compress"
+	"github.com/abdo-355/http-from-tcp/internal/cookie"
+	"github.com/abdo-355/http-from-tcp/internal/cors"
+	"github.com/abdo-355/http-from-tcp/internal/errorpage"
 	"github.com/abdo-355/http-from-tcp/internal/headers"
+	"github.com/abdo-355/http-from-tcp/internal/httperrors"
+	"github.com/abdo-355/http-from-tcp/internal/jsonhttp"
+	"github.com/abdo-355/http-from-tcp/internal/negotiate"
+	"github.com/abdo-355/http-from-tcp/internal/proxy"
 	"github.com/abdo-355/http-from-tcp/internal/request"
 	"github.com/abdo-355/http-from-tcp/internal/response"
 	"github.com/abdo-355/http-from-tcp/internal/server"
+	"github.com/abdo-355/http-from-tcp/internal/sse"
+	"github.com/abdo-355/http-from-tcp/internal/tracing"
+	"github.com/abdo-355/http-from-tcp/internal/websocket"
 )
 
-const port = 8080
+const (
+	port       = 8080
+	serverName = "http-from-tcp"
+	// maxDecodedBodySize caps the size of decompressed request bodies
+	maxDecodedBodySize = 10 << 20
+
+	healthCheckInterval = 10 * time.Second
+)
+
+var (
+	httpbinProxy *proxy.ReverseProxy
+	// forwardProxy is only enabled when FORWARD_PROXY_ALLOW is set, so the server is
+	// not an open proxy by default
+	forwardProxy *proxy.ForwardProxy
+)
 
 func main() {
-	server, err := server.Serve(port, handler)
+	upstreams := []string{"https://httpbin.org"}
+	// HTTPBIN_UPSTREAMS takes a comma separated list of httpbin instances to balance across
+	if env := os.Getenv("HTTPBIN_UPSTREAMS"); env != "" {
+		upstreams = strings.Split(env, ",")
+	}
+
+	var err error
+	httpbinProxy, err = proxy.NewBalanced(upstreams, "/httpbin", proxy.PoolOptions{Strategy: proxy.RoundRobin})
+	if err != nil {
+		log.Fatalf("Error creating httpbin proxy: %v", err)
+	}
+	stopHealthChecks := httpbinProxy.Pool.StartHealthChecks(healthCheckInterval, "/status/200")
+	defer stopHealthChecks()
+
+	// FORWARD_PROXY_ALLOW takes a comma separated list of host:port destinations, like *.example.com:443
+	if env := os.Getenv("FORWARD_PROXY_ALLOW"); env != "" {
+		forwardProxy = proxy.NewForward(strings.Split(env, ","))
+		if user := os.Getenv("FORWARD_PROXY_USER"); user != "" {
+			forwardProxy.Credentials = map[string]string{user: os.Getenv("FORWARD_PROXY_PASSWORD")}
+		}
+	}
+
+	corsPolicy := cors.DefaultPolicy()
+	// CORS_ALLOWED_ORIGINS takes a comma separated list of origins, wildcards like https://*.example.com work
+	if env := os.Getenv("CORS_ALLOWED_ORIGINS"); env != "" {
+		corsPolicy.AllowedOrigins = strings.Split(env, ",")
+	}
+
+	opts := []server.Option{server.WithServerName(serverName)}
+	// TRACE_FILE turns on tracing, appending a JSON line per span to the file
+	if env := os.Getenv("TRACE_FILE"); env != "" {
+		exporter, err := tracing.NewFileExporter(env)
+		if err != nil {
+			log.Fatalf("Error opening trace file: %v", err)
+		}
+		defer exporter.Close()
+		opts = append(opts, server.WithTracing(exporter))
+	}
+
+	server, err := server.Serve(port, server.Chain(handler,
+		cors.Middleware(corsPolicy),
+		compress.Middleware(compress.DefaultOptions()),
+		exceptProxied(server.DecodeRequestBody(maxDecodedBodySize)),
+	), opts...)
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
@@ -42,128 +115,268 @@ func main() {
 	log.Println("Server gracefully stopped")
 }
 
+// proxied reports whether req is relayed by one of the proxies.
+func proxied(req *request.Request) bool {
+	return (forwardProxy != nil && proxy.IsForwardRequest(req)) ||
+		strings.HasPrefix(req.RequestLine.RequestTarget, "/httpbin/")
+}
+
+// exceptProxied applies mw to every request but the proxied ones, whose body has to
+// reach the upstream as the client encoded it.
+func exceptProxied(mw server.Middleware) server.Middleware {
+	return func(next server.Handler) server.Handler {
+		wrapped := mw(next)
+		return func(w *response.Writer, req *request.Request) {
+			if proxied(req) {
+				next(w, req)
+				return
+			}
+			wrapped(w, req)
+		}
+	}
+}
+
 func handler(w *response.Writer, req *request.Request) {
-	target := req.RequestLine.RequestTarget
-	if strings.HasPrefix(target, "/httpbin/") {
-		handleHttpbinProxy(w, req)
+	if forwardProxy != nil && proxy.IsForwardRequest(req) {
+		forwardProxy.Handle(w, req)
 		return
 	}
 
-	if target == "/video" && req.RequestLine.Method == "GET" {
-		handleVideo(w)
+	target := req.RequestLine.RequestTarget
+	if strings.HasPrefix(target, "/httpbin/") {
+		httpbinProxy.Handle(w, req)
 		return
 	}
 
-	var status int
-	var html string
 	switch target {
+	case "/video":
+		if allowMethods(w, req, "GET") {
+			server.ServeFile(w, req, "./assets/vim.mp4", "video/mp4")
+		}
+	case "/ws/echo":
+		echoWebSocket(w, req)
+	case "/events/clock":
+		if allowMethods(w, req, "GET") {
+			streamClock(w, req)
+		}
+	case "/items":
+		if !allowMethods(w, req, "GET", "POST") {
+			return
+		}
+		if req.RequestLine.Method == "POST" {
+			createItem(w, req)
+		} else {
+			serveItems(w, req)
+		}
+	case "/upload":
+		if allowMethods(w, req, "GET", "POST") {
+			serveUpload(w, req)
+		}
+	case "/visits":
+		if allowMethods(w, req, "GET") {
+			countVisits(w, req)
+		}
 	case "/yourproblem":
-		status = http.StatusBadRequest
-		html = `<html><head><title>400 Bad Request</title></head><body><h1>Bad Request</h1><p>Your request honestly kinda sucked.</p></body></html>`
+		errorpage.Error(w, req, http.StatusBadRequest, errors.New("your request honestly kinda sucked"))
 	case "/myproblem":
-		status = http.StatusInternalServerError
-		html = `<html><head><title>500 Internal Server Error</title></head><body><h1>Internal Server Error</h1><p>Okay, you know what? This one is on me.</p></body></html>`
+		errorpage.Error(w, req, http.StatusInternalServerError, errors.New("this one is on me"))
+	case "/":
+		body := []byte(`<html><head><title>200 OK</title></head><body><h1>Success!</h1><p>Your request was an absolute banger.</p></body></html>`)
+		h := headers.NewHeaders()
+		h.Set("content-type", "text/html")
+		h.Set("connection", "close")
+		w.WriteStatusLine("HTTP/1.1", http.StatusOK, http.StatusText(http.StatusOK))
+		w.WriteHeaders(h)
+		w.WriteBody(body)
 	default:
-		status = http.StatusOK
-		html = `<html><head><title>200 OK</title></head><body><h1>Success!</h1><p>Your request was an absolute banger.</p></body></html>`
+		errorpage.Error(w, req, http.StatusNotFound, fmt.Errorf("nothing is served at %s", target))
 	}
-
-	body := []byte(html)
-	h := headers.NewHeaders()
-	h.Set("content-type", "text/html")
-	h.Set("content-length", strconv.Itoa(len(body)))
-	h.Set("connection", "close")
-	w.WriteStatusLine("HTTP/1.1", status, http.StatusText(status))
-	w.WriteHeaders(h)
-	w.WriteBody(body)
 }
 
-func handleHttpbinProxy(w *response.Writer, req *request.Request) {
-	target := strings.TrimPrefix(req.RequestLine.RequestTarget, "/httpbin")
-	proxyRes, err := http.Get("https://httpbin.org" + target)
-	if err != nil {
-		sendInternalServerError(w, err)
-		return
+// allowMethods answers 405 with an Allow header unless req uses one of methods. HEAD is
+// allowed wherever GET is.
+func allowMethods(w *response.Writer, req *request.Request, methods ...string) bool {
+	if slices.Contains(methods, "GET") {
+		methods = append(methods, "HEAD")
 	}
-	defer proxyRes.Body.Close()
+	if slices.Contains(methods, req.RequestLine.Method) {
+		return true
+	}
+	w.SetHeader("allow", strings.Join(methods, ", "))
+	errorpage.Error(w, req, http.StatusMethodNotAllowed, fmt.Errorf("%s is not allowed here", req.RequestLine.Method))
+	return false
+}
 
-	w.WriteStatusLine("HTTP/1.1", http.StatusOK, "OK")
+type item struct {
+	Name  string `json:"name"`
+	Price int    `json:"price"`
+}
 
-	h := headers.NewHeaders()
-	h.Set("content-type", "text/html")
-	h.Set("transfer-encoding", "chunked")
-	h.Set("Trailer", "X-Content-SHA256, X-Content-Length")
-	w.WriteHeaders(h)
+func (it item) Validate() error {
+	var ve jsonhttp.ValidationError
+	if it.Name == "" {
+		ve.Add("name", "is required")
+	}
+	if it.Price <= 0 {
+		ve.Add("price", "must be positive")
+	}
+	return ve.Err()
+}
 
-	buff := make([]byte, 1024)
-	cl := 0
-	hash := sha256.New()
-	for {
-		n, err := proxyRes.Body.Read(buff)
-		if n > 0 {
-			if _, err := w.WriteChunkedBody(buff[:n], hash); err != nil {
-				sendInternalServerError(w, fmt.Errorf("error writing chunked body: %w", err))
-				return
-			}
-		}
+var (
+	itemsMu sync.Mutex
+	items   = []item{{Name: "keyboard", Price: 45}, {Name: "mouse", Price: 20}, {Name: "monitor", Price: 180}}
+)
 
-		cl += n
+// serveItems lists items as HTML, JSON or CSV, depending on what the client accepts.
+func serveItems(w *response.Writer, req *request.Request) {
+	itemsMu.Lock()
+	list := slices.Clone(items)
+	itemsMu.Unlock()
 
-		if err == io.EOF {
-			w.WriteChunkedBodyDone()
-			break
+	var buf bytes.Buffer
+	mediaType := negotiate.ContentType(w, req, "text/html", "application/json", "text/csv")
+	switch mediaType {
+	case "text/html":
+		buf.WriteString("<html><head><title>Items</title></head><body><ul>")
+		for _, it := range list {
+			fmt.Fprintf(&buf, "<li>%s: %d</li>", html.EscapeString(it.Name), it.Price)
 		}
-
-		if err != nil {
-			sendInternalServerError(w, fmt.Errorf("error reading proxy response: %w", err))
-			return
+		buf.WriteString("</ul></body></html>")
+	case "application/json":
+		json.NewEncoder(&buf).Encode(list)
+	case "text/csv":
+		cw := csv.NewWriter(&buf)
+		cw.Write([]string{"name", "price"})
+		for _, it := range list {
+			cw.Write([]string{it.Name, strconv.Itoa(it.Price)})
 		}
+		cw.Flush()
+	default:
+		errorpage.Error(w, req, http.StatusNotAcceptable, errors.New("items are available as text/html, application/json and text/csv"))
+		return
 	}
 
-	hashValue := hash.Sum(nil)
-	t := headers.NewHeaders()
-	t.SetTrailer("X-Content-Sha256", hex.EncodeToString(hashValue))
-	t.SetTrailer("X-Content-Length", strconv.Itoa(cl))
-	if err := w.WriteTrailers(t); err != nil {
-		sendInternalServerError(w, fmt.Errorf("error writing trailers: %w", err))
+	h := headers.NewHeaders()
+	h.Set("content-type", mediaType)
+	w.WriteStatusLine("HTTP/1.1", http.StatusOK, http.StatusText(http.StatusOK))
+	w.WriteHeaders(h)
+	w.WriteBody(buf.Bytes())
+}
+
+// createItem adds the item posted as JSON to the list.
+func createItem(w *response.Writer, req *request.Request) {
+	var it item
+	if err := jsonhttp.Decode(req, &it, jsonhttp.DefaultDecodeOptions()); err != nil {
+		jsonhttp.Error(w, req, err)
 		return
 	}
+	itemsMu.Lock()
+	items = append(items, it)
+	itemsMu.Unlock()
+	if err := jsonhttp.Write(w, http.StatusCreated, it); err != nil {
+		jsonhttp.Error(w, req, err)
+	}
 }
 
-func handleVideo(w *response.Writer) {
-	data, err := os.ReadFile("./assets/vim.mp4")
+// countVisits tells the client how many times it came, counting in a cookie.
+func countVisits(w *response.Writer, req *request.Request) {
+	visits := 0
+	if c, ok := cookie.Get(req, "visits"); ok {
+		visits, _ = strconv.Atoi(c.Value)
+	}
+	visits++
+	err := cookie.Set(w, cookie.Cookie{
+		Name:     "visits",
+		Value:    strconv.Itoa(visits),
+		Path:     "/visits",
+		MaxAge:   int((30 * 24 * time.Hour).Seconds()),
+		HttpOnly: true,
+		SameSite: cookie.SameSiteLax,
+	})
 	if err != nil {
-		sendErrorResponse(w, http.StatusNotFound, "video not found", err)
+		errorpage.Error(w, req, http.StatusInternalServerError, err)
 		return
 	}
 
+	body := fmt.Sprintf("visit number %d\n", visits)
 	h := headers.NewHeaders()
-	h.Set("content-type", "video/mp4")
-	h.Set("content-length", strconv.Itoa(len(data)))
-	h.Set("connection", "close")
-	w.WriteStatusLine("HTTP/1.1", http.StatusOK, "OK")
+	h.Set("content-type", "text/plain")
+	w.WriteStatusLine("HTTP/1.1", http.StatusOK, http.StatusText(http.StatusOK))
 	w.WriteHeaders(h)
-	w.WriteBody(data)
+	w.WriteBody([]byte(body))
 }
 
-func sendInternalServerError(w *response.Writer, err error) {
-	log.Printf("Internal Server Error: %v", err)
-	sendErrorResponse(w, http.StatusInternalServerError, "Internal Server Error", err)
-}
+const uploadPage = `<html><head><title>Upload</title></head><body><form method="post" enctype="multipart/form-data"><input name="note"><input type="file" name="file" multiple><button>Upload</button></form></body></html>`
 
-func sendErrorResponse(w *response.Writer, statusCode int, message string, err error) {
-	statusText := http.StatusText(statusCode)
-	if statusText == "" {
-		statusText = "Unknown"
-	}
+// serveUpload shows an upload form on GET and lists the files posted to it.
+func serveUpload(w *response.Writer, req *request.Request) {
+	body := uploadPage
+	if req.RequestLine.Method == "POST" {
+		form, err := req.ParseForm(request.DefaultFormOptions())
+		if err != nil {
+			status := http.StatusBadRequest
+			var se httperrors.StatusError
+			if errors.As(err, &se) {
+				status = se.Code
+			}
+			errorpage.Error(w, req, status, err)
+			return
+		}
+		defer form.RemoveAll()
 
-	errorBody := fmt.Sprintf("%d %s: %s - %s", statusCode, statusText, message, err.Error())
+		var buf bytes.Buffer
+		fmt.Fprintf(&buf, "<html><head><title>Uploaded</title></head><body><p>%s</p><ul>", html.EscapeString(form.Get("note")))
+		for _, f := range form.File["file"] {
+			fmt.Fprintf(&buf, "<li>%s: %d bytes</li>", html.EscapeString(f.Filename), f.Size)
+		}
+		buf.WriteString("</ul></body></html>")
+		body = buf.String()
+	}
 
-	w.WriteStatusLine("HTTP/1.1", statusCode, statusText)
 	h := headers.NewHeaders()
-	h.Set("content-type", "text/plain")
-	h.Set("content-length", strconv.Itoa(len(errorBody)))
-	h.Set("connection", "close")
+	h.Set("content-type", "text/html")
+	w.WriteStatusLine("HTTP/1.1", http.StatusOK, http.StatusText(http.StatusOK))
 	w.WriteHeaders(h)
-	w.WriteBody([]byte(errorBody))
+	w.WriteBody([]byte(body))
+}
+
+// echoWebSocket sends every message received on the WebSocket back to the client.
+func echoWebSocket(w *response.Writer, req *request.Request) {
+	conn, err := websocket.Upgrade(w, req)
+	if err != nil {
+		return
+	}
+	defer conn.Close(websocket.CloseNormal, "")
+	for {
+		t, msg, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if err := conn.WriteMessage(t, msg); err != nil {
+			return
+		}
+	}
+}
+
+// streamClock sends the current time as a server-sent event every second.
+func streamClock(w *response.Writer, req *request.Request) {
+	stream, err := sse.NewStream(w, req, sse.Options{})
+	if err != nil {
+		return
+	}
+	defer stream.Close()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stream.Done():
+			return
+		case now := <-ticker.C:
+			if err := stream.Send(sse.Event{Event: "tick", Data: now.UTC().Format(time.RFC3339)}); err != nil {
+				return
+			}
+		}
+	}
 }
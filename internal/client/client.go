@@ -0,0 +1,348 @@
+// Package client implements an HTTP/1.1 client on top of the project's own request writer
+// and response parser.
+package client
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abdo-355/http-from-tcp/internal/headers"
+	"github.com/abdo-355/http-from-tcp/internal/request"
+	"github.com/abdo-355/http-from-tcp/internal/response"
+)
+
+const defaultTimeout = 30 * time.Second
+
+// Request is an outgoing HTTP request.
+type Request struct {
+	Method  string
+	URL     *url.URL
+	Headers headers.Headers
+	Body    []byte
+	// Addr is the host:port to connect to instead of the URL's host, which is still sent
+	// in the Host header. The forward proxy uses it to dial the address it checked.
+	Addr string
+}
+
+// NewRequest builds a Request for an absolute http or https URL.
+func NewRequest(method, rawURL string, body []byte) (*Request, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported url scheme: %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("url has no host: %s", rawURL)
+	}
+
+	return &Request{
+		Method:  method,
+		URL:     u,
+		Headers: headers.NewHeaders(),
+		Body:    body,
+	}, nil
+}
+
+// Client sends requests over plain TCP (or TLS for https) connections.
+type Client struct {
+	// Timeout bounds the whole exchange: dialing, writing the request and reading the response.
+	Timeout time.Duration
+	// TLSConfig is used for https URLs. A nil config uses the defaults.
+	TLSConfig *tls.Config
+	// Pool keeps connections alive between requests. A nil pool closes every
+	// connection after its response.
+	Pool *Pool
+	// MaxBodySize bounds the response bodies Do reads. Zero uses response.DefaultMaxBodySize.
+	MaxBodySize int64
+}
+
+// New creates a Client with the default timeout and a connection pool.
+func New() *Client {
+	return &Client{Timeout: defaultTimeout, Pool: NewPool()}
+}
+
+// Get sends a GET request to rawURL.
+func (c *Client) Get(rawURL string) (*response.Response, error) {
+	req, err := NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// Do sends req and reads the full response. Redirects are not followed.
+func (c *Client) Do(req *Request) (*response.Response, error) {
+	return c.DoContext(context.Background(), req)
+}
+
+// DoContext is like Do but gives up as soon as ctx is done, returning ctx.Err().
+func (c *Client) DoContext(ctx context.Context, req *Request) (*response.Response, error) {
+	res, body, err := c.send(ctx, req, true)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	maxBody := c.MaxBodySize
+	if maxBody <= 0 {
+		maxBody = response.DefaultMaxBodySize
+	}
+	if err := response.ReadBody(res, body, maxBody); err != nil {
+		return nil, contextError(ctx, fmt.Errorf("error reading response: %w", err))
+	}
+	return res, nil
+}
+
+// DoStream sends req and returns as soon as the response head is read, with the body left
+// to read from the returned reader, which the caller must close. Timeout only bounds the
+// exchange up to the head, so long downloads and event streams are limited by ctx alone.
+// Once the body is read to the end the connection goes back to the pool.
+func (c *Client) DoStream(ctx context.Context, req *Request) (*response.Response, io.ReadCloser, error) {
+	return c.send(ctx, req, false)
+}
+
+func (c *Client) send(ctx context.Context, req *Request, whole bool) (*response.Response, *body, error) {
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	key := req.URL.Scheme + "://" + hostPort(req.URL)
+	if req.Addr != "" {
+		key += "@" + req.Addr
+	}
+	if c.Pool != nil {
+		if pc := c.Pool.get(key); pc != nil {
+			res, b, err := c.roundTrip(ctx, pc, req, deadline, whole)
+			// the server may have closed the connection just as it was picked up, which is
+			// safe to retry on a fresh one as long as the request is idempotent
+			if err == nil || !idempotent(req.Method) || ctx.Err() != nil {
+				return res, b, err
+			}
+		}
+	}
+
+	conn, err := c.dial(ctx, req, deadline)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		return nil, nil, err
+	}
+	return c.roundTrip(ctx, &persistConn{Conn: conn, br: bufio.NewReader(conn), key: key}, req, deadline, whole)
+}
+
+// roundTrip writes req on pc and reads the response head. The returned body hands pc back
+// to the pool, or closes it, once it is done. When whole is false the deadline is lifted
+// after the head, since the body may take arbitrarily long.
+func (c *Client) roundTrip(ctx context.Context, pc *persistConn, req *Request, deadline time.Time, whole bool) (*response.Response, *body, error) {
+	if err := pc.SetDeadline(deadline); err != nil {
+		pc.Close()
+		return nil, nil, err
+	}
+	// closing the connection unblocks the write or read in progress
+	stop := context.AfterFunc(ctx, func() { pc.Close() })
+
+	h := req.Headers.Clone()
+	h.Set("host", req.URL.Host)
+	if c.Pool == nil {
+		h.Set("connection", "close")
+	}
+	if h.Get("user-agent") == "" {
+		h.Set("user-agent", "http-from-tcp")
+	}
+	h.Del("transfer-encoding")
+	if len(req.Body) > 0 || methodExpectsBody(req.Method) {
+		h.Set("content-length", strconv.Itoa(len(req.Body)))
+	}
+
+	if err := writeRequest(pc, req, h); err != nil {
+		stop()
+		pc.Close()
+		return nil, nil, contextError(ctx, fmt.Errorf("error writing request: %w", err))
+	}
+
+	res, r, err := response.HeadFromReader(pc.br, req.Method)
+	if err != nil {
+		if !stop() {
+			return nil, nil, ctx.Err()
+		}
+		pc.Close()
+		return nil, nil, contextError(ctx, fmt.Errorf("error reading response: %w", err))
+	}
+	if !whole {
+		pc.SetDeadline(time.Time{})
+	}
+
+	return res, &body{
+		r:      r,
+		ctx:    ctx,
+		pc:     pc,
+		stop:   stop,
+		pool:   c.Pool,
+		reused: reusable(req, h, res),
+	}, nil
+}
+
+// body reads a response body off its connection. The connection is put back in the pool
+// when the body was read to the end and closed otherwise.
+type body struct {
+	r      io.Reader
+	ctx    context.Context
+	pc     *persistConn
+	stop   func() bool
+	pool   *Pool
+	reused bool
+	done   bool
+	err    error
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	if b.done {
+		return 0, b.err
+	}
+	n, err := b.r.Read(p)
+	switch {
+	case err == io.EOF:
+		b.finish(true, io.EOF)
+	case err != nil:
+		err = contextError(b.ctx, err)
+		b.finish(false, err)
+	}
+	return n, err
+}
+
+// Close releases the connection. Closing before the end of the body closes the
+// connection, since the rest of the body is still on it.
+func (b *body) Close() error {
+	b.finish(false, errors.New("read on closed body"))
+	return nil
+}
+
+func (b *body) finish(complete bool, err error) {
+	if b.done {
+		return
+	}
+	b.done = true
+	b.err = err
+	// stop fails when ctx was cancelled, which has already closed the connection
+	if b.stop() && complete && b.reused && b.pool != nil {
+		b.pool.put(b.pc)
+		return
+	}
+	b.pc.Close()
+}
+
+// contextError prefers ctx's error over err, which is usually just the closed connection
+// or the connection deadline taken from ctx firing a moment before ctx itself.
+func contextError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if d, ok := ctx.Deadline(); ok && !time.Now().Before(d) {
+		return context.DeadlineExceeded
+	}
+	return err
+}
+
+func writeRequest(conn net.Conn, req *Request, h headers.Headers) error {
+	w := request.NewWriter(conn)
+	if err := w.WriteRequestLine(req.Method, req.URL.RequestURI()); err != nil {
+		return err
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return err
+	}
+	return w.WriteBody(req.Body)
+}
+
+// reusable reports whether the connection can carry another request after res.
+func reusable(req *Request, h headers.Headers, res *response.Response) bool {
+	if hasToken(h.Get("connection"), "close") || hasToken(res.Headers.Get("connection"), "close") {
+		return false
+	}
+	if res.HTTPVersion == "1.0" && !hasToken(res.Headers.Get("connection"), "keep-alive") {
+		return false
+	}
+	if res.StatusCode == 101 {
+		return false
+	}
+
+	// a body without Content-Length or chunked framing ends when the connection closes
+	hasBody := req.Method != "HEAD" && res.StatusCode >= 200 && res.StatusCode != 204 && res.StatusCode != 304
+	te := strings.ToLower(res.Headers.Get("transfer-encoding"))
+	if hasBody && !strings.HasSuffix(te, "chunked") && res.Headers.Get("content-length") == "" {
+		return false
+	}
+	return true
+}
+
+func hasToken(value, token string) bool {
+	for _, part := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(part), token) {
+			return true
+		}
+	}
+	return false
+}
+
+func hostPort(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	if u.Scheme == "https" {
+		return net.JoinHostPort(u.Hostname(), "443")
+	}
+	return net.JoinHostPort(u.Hostname(), "80")
+}
+
+func (c *Client) dial(ctx context.Context, req *Request, deadline time.Time) (net.Conn, error) {
+	addr := hostPort(req.URL)
+	if req.Addr != "" {
+		addr = req.Addr
+	}
+	dialer := &net.Dialer{Deadline: deadline}
+	if req.URL.Scheme == "https" {
+		cfg := c.TLSConfig
+		if cfg == nil {
+			cfg = &tls.Config{}
+		}
+		if req.Addr != "" && cfg.ServerName == "" {
+			// the certificate is checked against the URL's host, not the address dialed
+			cfg = cfg.Clone()
+			cfg.ServerName = req.URL.Hostname()
+		}
+		return (&tls.Dialer{NetDialer: dialer, Config: cfg}).DialContext(ctx, "tcp", addr)
+	}
+	return dialer.DialContext(ctx, "tcp", addr)
+}
+
+func idempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
+
+func methodExpectsBody(method string) bool {
+	return method == "POST" || method == "PUT" || method == "PATCH"
+}
@@ -0,0 +1,187 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/abdo-355/http-from-tcp/internal/headers"
+	"github.com/abdo-355/http-from-tcp/internal/request"
+	"github.com/abdo-355/http-from-tcp/internal/response"
+	"github.com/abdo-355/http-from-tcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rawServer answers every connection with the canned response after reading the request head.
+func rawServer(t *testing.T, raw string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, err := request.RequestFromReader(conn)
+				if err != nil {
+					return
+				}
+				conn.Write([]byte(raw))
+			}()
+		}
+	}()
+
+	return "http://" + listener.Addr().String()
+}
+
+func TestClient_AgainstOwnServer(t *testing.T) {
+	srv, err := server.Serve(0, func(w *response.Writer, req *request.Request) {
+		body := []byte(req.RequestLine.Method + " " + req.RequestLine.RequestTarget + " " + string(req.Body))
+		h := headers.NewHeaders()
+		h.Set("content-type", "text/plain")
+		h.Set("content-length", strconv.Itoa(len(body)))
+		h.Set("x-user-agent", req.Headers.Get("user-agent"))
+		w.WriteStatusLine("HTTP/1.1", http.StatusOK, "OK")
+		w.WriteHeaders(h)
+		w.WriteBody(body)
+	})
+	require.NoError(t, err)
+	defer srv.Close()
+
+	c := New()
+	req, err := NewRequest("POST", "http://"+srv.Listener.Addr().String()+"/echo?x=1", []byte("ping"))
+	require.NoError(t, err)
+
+	res, err := c.Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "POST /echo?x=1 ping", string(res.Body))
+	assert.Equal(t, "http-from-tcp", res.Headers.Get("x-user-agent"))
+}
+
+func TestClient_Addr(t *testing.T) {
+	srv, err := server.Serve(0, func(w *response.Writer, req *request.Request) {
+		body := []byte(req.Headers.Get("host"))
+		w.WriteStatusLine("HTTP/1.1", http.StatusOK, "OK")
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	})
+	require.NoError(t, err)
+	defer srv.Close()
+
+	req, err := NewRequest("GET", "http://example.invalid/", nil)
+	require.NoError(t, err)
+	req.Addr = srv.Listener.Addr().String()
+
+	res, err := New().Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, "example.invalid", string(res.Body))
+}
+
+func TestClient_Get(t *testing.T) {
+	url := rawServer(t, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n2\r\nhi\r\n0\r\n\r\n")
+
+	res, err := New().Get(url + "/")
+	require.NoError(t, err)
+	assert.Equal(t, "hi", string(res.Body))
+}
+
+func TestClient_MaxBodySize(t *testing.T) {
+	url := rawServer(t, "HTTP/1.1 200 OK\r\nContent-Length: 1000000000\r\n\r\nshort")
+
+	c := &Client{Timeout: time.Second, MaxBodySize: 1024}
+	_, err := c.Get(url + "/")
+	assert.ErrorIs(t, err, response.ErrBodyTooLarge)
+}
+
+func TestClient_DoStream(t *testing.T) {
+	url, conns := keepAliveServer(t, func(int) (string, bool) {
+		return "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\nX-Sum: abc\r\n\r\n", false
+	})
+
+	c := New()
+	req, err := NewRequest("GET", url+"/", nil)
+	require.NoError(t, err)
+
+	// a body closed before its end takes the connection with it
+	_, body, err := c.DoStream(context.Background(), req)
+	require.NoError(t, err)
+	require.NoError(t, body.Close())
+	assert.Equal(t, 0, c.Pool.Stats().Idle)
+
+	res, body, err := c.DoStream(context.Background(), req)
+	require.NoError(t, err)
+	b, err := io.ReadAll(body)
+	require.NoError(t, err)
+	require.NoError(t, body.Close())
+	assert.Equal(t, "hello", string(b))
+	assert.Equal(t, "abc", res.Trailers.Get("x-sum"))
+	assert.Equal(t, 1, c.Pool.Stats().Idle)
+	assert.Equal(t, int32(2), conns.Load())
+}
+
+func TestClient_Timeout(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	// accept the connection but never answer, so the client has to give up
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			defer conn.Close()
+			time.Sleep(2 * time.Second)
+		}
+	}()
+
+	c := &Client{Timeout: 100 * time.Millisecond}
+	start := time.Now()
+	_, err = c.Get("http://" + listener.Addr().String())
+	var netErr net.Error
+	require.ErrorAs(t, err, &netErr)
+	assert.True(t, netErr.Timeout())
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestClient_DoContext(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			defer conn.Close()
+			time.Sleep(2 * time.Second)
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	req, err := NewRequest("GET", "http://"+listener.Addr().String()+"/", nil)
+	require.NoError(t, err)
+	start := time.Now()
+	_, err = New().DoContext(ctx, req)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Less(t, time.Since(start), time.Second)
+
+	_, err = New().DoContext(ctx, req)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestNewRequest_Invalid(t *testing.T) {
+	_, err := NewRequest("GET", "ftp://example.com/", nil)
+	require.Error(t, err)
+	_, err = NewRequest("GET", "/relative", nil)
+	require.Error(t, err)
+}
@@ -0,0 +1,176 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultMaxIdlePerHost = 4
+	defaultIdleTimeout    = 90 * time.Second
+)
+
+// Pool keeps idle keep-alive connections so requests to the same host:port can reuse them.
+type Pool struct {
+	// MaxIdlePerHost is the number of idle connections kept for each host:port.
+	MaxIdlePerHost int
+	// IdleTimeout is how long a connection may stay idle before it is closed.
+	IdleTimeout time.Duration
+
+	mu   sync.Mutex
+	idle map[string][]*persistConn
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+// PoolStats is a snapshot of the pool counters.
+type PoolStats struct {
+	// Hits counts requests that reused an idle connection.
+	Hits int64
+	// Misses counts requests that had to dial a new connection.
+	Misses int64
+	// Idle is the number of idle connections currently held.
+	Idle int
+}
+
+type persistConn struct {
+	net.Conn
+	br  *bufio.Reader
+	key string
+
+	// idleRead gets the result of the read that watches the connection while it is idle
+	idleRead  chan error
+	idleTimer *time.Timer
+}
+
+// NewPool creates a Pool with the default limits.
+func NewPool() *Pool {
+	return &Pool{
+		MaxIdlePerHost: defaultMaxIdlePerHost,
+		IdleTimeout:    defaultIdleTimeout,
+		idle:           map[string][]*persistConn{},
+	}
+}
+
+// get returns a usable idle connection for key, or nil when a new one has to be dialed.
+func (p *Pool) get(key string) *persistConn {
+	for {
+		p.mu.Lock()
+		conns := p.idle[key]
+		if len(conns) == 0 {
+			p.mu.Unlock()
+			p.misses.Add(1)
+			return nil
+		}
+		// the most recently used connection is the least likely to have been closed by the server
+		pc := conns[len(conns)-1]
+		p.idle[key] = conns[:len(conns)-1]
+		p.mu.Unlock()
+
+		if !pc.claim() {
+			pc.Close()
+			continue
+		}
+		p.hits.Add(1)
+		return pc
+	}
+}
+
+// put returns pc to the pool, closing it when the host already has enough idle connections.
+// While idle, pc is closed as soon as the server closes it or IdleTimeout passes.
+func (p *Pool) put(pc *persistConn) {
+	if err := pc.SetDeadline(time.Time{}); err != nil {
+		pc.Close()
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.idle == nil {
+		p.idle = map[string][]*persistConn{}
+	}
+	if len(p.idle[pc.key]) >= p.MaxIdlePerHost {
+		pc.Close()
+		return
+	}
+	p.idle[pc.key] = append(p.idle[pc.key], pc)
+
+	pc.idleRead = make(chan error, 1)
+	go p.watch(pc)
+	if p.IdleTimeout > 0 {
+		pc.idleTimer = time.AfterFunc(p.IdleTimeout, func() { p.evict(pc) })
+	}
+}
+
+// watch reads from an idle connection until get claims it. An idle connection must
+// have nothing to read, so a read that returns on its own means the server closed the
+// connection or sent something unexpected, and the connection is dropped right away.
+func (p *Pool) watch(pc *persistConn) {
+	_, err := pc.br.Peek(1)
+	p.evict(pc)
+	pc.idleRead <- err
+}
+
+// evict closes pc if it is still idle in the pool.
+func (p *Pool) evict(pc *persistConn) {
+	p.mu.Lock()
+	conns := p.idle[pc.key]
+	i := slices.Index(conns, pc)
+	if i >= 0 {
+		p.idle[pc.key] = slices.Delete(conns, i, i+1)
+	}
+	p.mu.Unlock()
+	if i >= 0 {
+		pc.Close()
+	}
+}
+
+// Stats returns the current pool counters.
+func (p *Pool) Stats() PoolStats {
+	p.mu.Lock()
+	idle := 0
+	for _, conns := range p.idle {
+		idle += len(conns)
+	}
+	p.mu.Unlock()
+
+	return PoolStats{Hits: p.hits.Load(), Misses: p.misses.Load(), Idle: idle}
+}
+
+// CloseIdle closes all idle connections.
+func (p *Pool) CloseIdle() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for key, conns := range p.idle {
+		for _, pc := range conns {
+			if pc.idleTimer != nil {
+				pc.idleTimer.Stop()
+			}
+			pc.Close()
+		}
+		delete(p.idle, key)
+	}
+}
+
+// claim takes an idle connection out of the pool's hands by interrupting the read of
+// watch. It reports whether the connection is still usable, that is whether the read was
+// still waiting rather than ended by the server.
+func (pc *persistConn) claim() bool {
+	if pc.idleTimer != nil {
+		pc.idleTimer.Stop()
+	}
+	if err := pc.SetReadDeadline(time.Now()); err != nil {
+		return false
+	}
+	if err := <-pc.idleRead; !errors.Is(err, os.ErrDeadlineExceeded) {
+		return false
+	}
+	return pc.SetReadDeadline(time.Time{}) == nil
+}
@@ -0,0 +1,174 @@
+package client
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/abdo-355/http-from-tcp/internal/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// keepAliveServer answers requests on each connection until the client closes it.
+// respond returns the raw response and whether the server should close afterwards.
+func keepAliveServer(t *testing.T, respond func(n int) (string, bool)) (string, *atomic.Int32) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	var conns atomic.Int32
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conns.Add(1)
+			go func() {
+				defer conn.Close()
+				for n := 0; ; n++ {
+					if _, err := request.RequestFromReader(conn); err != nil {
+						return
+					}
+					raw, closeAfter := respond(n)
+					conn.Write([]byte(raw))
+					if closeAfter {
+						return
+					}
+				}
+			}()
+		}
+	}()
+
+	return "http://" + listener.Addr().String(), &conns
+}
+
+func TestPool_ReusesConnections(t *testing.T) {
+	url, conns := keepAliveServer(t, func(int) (string, bool) {
+		return "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok", false
+	})
+
+	c := New()
+	for range 3 {
+		res, err := c.Get(url)
+		require.NoError(t, err)
+		assert.Equal(t, "ok", string(res.Body))
+	}
+
+	assert.Equal(t, int32(1), conns.Load())
+	assert.Equal(t, PoolStats{Hits: 2, Misses: 1, Idle: 1}, c.Pool.Stats())
+}
+
+func TestPool_ConnectionCloseResponse(t *testing.T) {
+	url, conns := keepAliveServer(t, func(int) (string, bool) {
+		return "HTTP/1.1 200 OK\r\nConnection: close\r\nContent-Length: 2\r\n\r\nok", true
+	})
+
+	c := New()
+	for range 2 {
+		_, err := c.Get(url)
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, int32(2), conns.Load())
+	assert.Equal(t, 0, c.Pool.Stats().Idle)
+}
+
+func TestPool_ServerClosedIdleConnection(t *testing.T) {
+	// the server silently drops the connection after every response
+	url, conns := keepAliveServer(t, func(int) (string, bool) {
+		return "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok", true
+	})
+
+	c := New()
+	_, err := c.Get(url)
+	require.NoError(t, err)
+	// the pool drops the connection once the server's FIN arrives
+	require.Eventually(t, func() bool { return c.Pool.Stats().Idle == 0 }, time.Second, time.Millisecond)
+	_, err = c.Get(url)
+	require.NoError(t, err)
+
+	assert.Equal(t, int32(2), conns.Load())
+	assert.Equal(t, PoolStats{Hits: 0, Misses: 2, Idle: 1}, c.Pool.Stats())
+}
+
+func TestPool_IdleTimeout(t *testing.T) {
+	p := NewPool()
+	p.IdleTimeout = time.Millisecond
+	server, client := net.Pipe()
+	defer server.Close()
+	p.put(&persistConn{Conn: client, br: bufio.NewReader(client), key: "http://a:80"})
+
+	// the read only ends once the pool closes the idle connection
+	_, err := server.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Nil(t, p.get("http://a:80"))
+}
+
+func TestPool_IdleConnectionWithData(t *testing.T) {
+	p := NewPool()
+	server, client := net.Pipe()
+	defer server.Close()
+	p.put(&persistConn{Conn: client, br: bufio.NewReader(client), key: "http://a:80"})
+
+	// a server writing to an idle connection is out of sync with it
+	_, err := server.Write([]byte("x"))
+	require.NoError(t, err)
+	_, err = server.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Nil(t, p.get("http://a:80"))
+}
+
+func TestPool_ClaimIdleConnection(t *testing.T) {
+	p := NewPool()
+	server, client := net.Pipe()
+	defer server.Close()
+	p.put(&persistConn{Conn: client, br: bufio.NewReader(client), key: "http://a:80"})
+
+	pc := p.get("http://a:80")
+	require.NotNil(t, pc)
+	defer pc.Close()
+	go server.Write([]byte("HTTP/1.1"))
+	line, err := pc.br.Peek(8)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1", string(line))
+}
+
+func TestPool_CloseDelimitedBodyIsNotReused(t *testing.T) {
+	url, conns := keepAliveServer(t, func(int) (string, bool) {
+		return "HTTP/1.1 200 OK\r\n\r\nuntil close", true
+	})
+
+	c := New()
+	for range 2 {
+		res, err := c.Get(url)
+		require.NoError(t, err)
+		assert.Equal(t, "until close", string(res.Body))
+	}
+	assert.Equal(t, int32(2), conns.Load())
+}
+
+func TestPool_MaxIdlePerHost(t *testing.T) {
+	p := NewPool()
+	p.MaxIdlePerHost = 1
+
+	var closed []net.Conn
+	for range 2 {
+		server, client := net.Pipe()
+		closed = append(closed, server)
+		p.put(&persistConn{Conn: client, br: bufio.NewReader(client), key: "http://a:80"})
+	}
+	defer func() {
+		for _, c := range closed {
+			c.Close()
+		}
+	}()
+
+	assert.Equal(t, 1, p.Stats().Idle)
+	p.CloseIdle()
+	assert.Equal(t, 0, p.Stats().Idle)
+}
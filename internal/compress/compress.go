@@ -0,0 +1,279 @@
+// Package compress provides response compression negotiated through Accept-Encoding.
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/abdo-355/http-from-tcp/internal/headers"
+	"github.com/abdo-355/http-from-tcp/internal/request"
+	"github.com/abdo-355/http-from-tcp/internal/response"
+	"github.com/abdo-355/http-from-tcp/internal/server"
+)
+
+const (
+	Gzip    = "gzip"
+	Deflate = "deflate"
+
+	chunkSize = 32 * 1024
+)
+
+// Options configures the compression middleware.
+type Options struct {
+	// MinSize is the smallest body (in bytes) worth compressing.
+	MinSize int
+	// Level is the compression level passed to the codec.
+	Level int
+	// ContentTypes lists the media type prefixes eligible for compression.
+	// A prefix starting with "+" matches structured syntax suffixes like "+json".
+	ContentTypes []string
+	// Encodings lists the supported encodings in order of server preference.
+	Encodings []string
+	// MaxSize is the largest body (in bytes) buffered to be compressed. Larger responses
+	// are compressed as they are written and sent in chunks. Zero buffers bodies of any size.
+	MaxSize int
+}
+
+// DefaultOptions compresses textual responses between 1KB and 4MB with gzip or deflate.
+func DefaultOptions() Options {
+	return Options{
+		MinSize: 1024,
+		MaxSize: 4 << 20,
+		Level:   gzip.DefaultCompression,
+		ContentTypes: []string{
+			"text/",
+			"application/json",
+			"application/javascript",
+			"application/xml",
+			"image/svg+xml",
+			"+json",
+			"+xml",
+		},
+		Encodings: []string{Gzip, Deflate},
+	}
+}
+
+// Middleware compresses eligible responses with the best encoding the client accepts.
+func Middleware(opts Options) server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			encoding := Negotiate(req.Headers.Get("accept-encoding"), opts.Encodings)
+			rec := w.RecorderLimit(opts.MaxSize)
+			// responses that are flushed, or outgrow MaxSize, are compressed as they stream
+			rec.SetEncoder(func(status int, h headers.Headers, dst io.Writer) response.Encoder {
+				if !eligible(status, h, opts) {
+					return nil
+				}
+				h.Add("vary", "Accept-Encoding")
+				if encoding == "" {
+					return nil
+				}
+				zw, err := newEncoder(encoding, opts.Level, dst)
+				if err != nil {
+					req.Logger().Error("error compressing response", "err", err, "encoding", encoding)
+					return nil
+				}
+				markEncoded(h, encoding)
+				return zw
+			})
+			next(rec, req)
+			if rec.Hijacked() || rec.Streaming() {
+				return
+			}
+
+			if !compressible(rec, opts) {
+				rec.CopyTo(w)
+				return
+			}
+
+			h := rec.Header()
+			h = h.Clone()
+			h.Add("vary", "Accept-Encoding")
+			body := rec.Body()
+
+			if encoding != "" {
+				compressed, err := encode(encoding, opts.Level, body)
+				if err != nil {
+					req.Logger().Error("error compressing response", "err", err, "encoding", encoding)
+					rec.CopyTo(w)
+					return
+				}
+				body = compressed
+				markEncoded(h, encoding)
+			}
+
+			if err := rewrite(w, rec, h, body); err != nil {
+				req.Logger().Error("error writing compressed response", "err", err)
+			}
+		}
+	}
+}
+
+func compressible(rec *response.Writer, opts Options) bool {
+	if rec.State < response.WriteBody || !eligible(rec.StatusCode(), rec.Header(), opts) {
+		return false
+	}
+	return len(rec.Body()) >= opts.MinSize
+}
+
+// eligible reports whether a response with status and headers h may be compressed,
+// whatever the size of its body.
+func eligible(status int, h headers.Headers, opts Options) bool {
+	if status < 200 || status == http.StatusNoContent || status == http.StatusNotModified {
+		return false
+	}
+	if h.Get("content-encoding") != "" || strings.Contains(h.Get("cache-control"), "no-transform") {
+		return false
+	}
+	return eligibleType(h.Get("content-type"), opts.ContentTypes)
+}
+
+// markEncoded sets the content coding on h. The encoded bytes differ from the ones a
+// strong tag was computed for, but they are still the same representation as far as
+// caches are concerned, so the tag becomes weak.
+func markEncoded(h headers.Headers, encoding string) {
+	h.Set("content-encoding", encoding)
+	if etag := h.Get("etag"); etag != "" && !response.IsWeakETag(etag) {
+		h.Set("etag", "W/"+etag)
+	}
+}
+
+func eligibleType(contentType string, types []string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	if mediaType == "" {
+		return false
+	}
+	for _, t := range types {
+		if strings.HasPrefix(t, "+") && strings.HasSuffix(mediaType, t) {
+			return true
+		}
+		if strings.HasPrefix(mediaType, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// rewrite writes the response recorded in rec to w with new headers and body,
+// keeping the framing (fixed length or chunked) the handler chose.
+func rewrite(w *response.Writer, rec *response.Writer, h headers.Headers, body []byte) error {
+	h.Del("content-length")
+	if !rec.IsChunked() {
+		h.Set("content-length", strconv.Itoa(len(body)))
+	}
+
+	w.WriteStatusLine("HTTP/1.1", rec.StatusCode(), rec.StatusText())
+	w.WriteHeaders(h)
+
+	if !rec.IsChunked() {
+		w.WriteBody(body)
+		return nil
+	}
+
+	cw := response.NewChunkedWriter(w)
+	for chunk := range slices.Chunk(body, chunkSize) {
+		if _, err := cw.Write(chunk); err != nil {
+			return err
+		}
+	}
+	for k, v := range rec.Trailers().M {
+		cw.SetTrailer(k, v)
+	}
+	return cw.Close()
+}
+
+func encode(encoding string, level int, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw, err := newEncoder(encoding, level, &buf)
+	if err != nil {
+		return nil, err
+	}
+	_, err = zw.Write(data)
+	if err := closeAfter(zw, err); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// newEncoder returns a writer compressing to dst with encoding.
+func newEncoder(encoding string, level int, dst io.Writer) (response.Encoder, error) {
+	switch encoding {
+	case Gzip:
+		zw, err := gzip.NewWriterLevel(dst, level)
+		if err != nil {
+			return nil, err
+		}
+		return zw, nil
+	case Deflate:
+		zw, err := zlib.NewWriterLevel(dst, level)
+		if err != nil {
+			return nil, err
+		}
+		return zw, nil
+	default:
+		return nil, fmt.Errorf("unsupported content-encoding: %s", encoding)
+	}
+}
+
+func closeAfter(c interface{ Close() error }, err error) error {
+	if closeErr := c.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Negotiate picks the offered encoding with the highest q-value in the Accept-Encoding header.
+// Ties are broken by the order of offered. It returns "" when the response should be sent
+// without a content coding.
+func Negotiate(acceptEncoding string, offered []string) string {
+	if strings.TrimSpace(acceptEncoding) == "" {
+		return ""
+	}
+
+	weights := map[string]float64{}
+	for _, member := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(member, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		weights[name] = parseQ(params)
+	}
+
+	best, bestQ := "", 0.0
+	for _, enc := range offered {
+		q, ok := weights[enc]
+		if !ok {
+			q, ok = weights["*"]
+		}
+		if ok && q > bestQ {
+			best, bestQ = enc, q
+		}
+	}
+
+	return best
+}
+
+// parseQ returns the q parameter value of a header member, defaulting to 1.
+func parseQ(params string) float64 {
+	for _, p := range strings.Split(params, ";") {
+		k, v, ok := strings.Cut(strings.TrimSpace(p), "=")
+		if !ok || strings.ToLower(strings.TrimSpace(k)) != "q" {
+			continue
+		}
+		q, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil || q < 0 {
+			return 0
+		}
+		return min(q, 1)
+	}
+	return 1
+}
@@ -0,0 +1,299 @@
+package compress
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/abdo-355/http-from-tcp/internal/headers"
+	"github.com/abdo-355/http-from-tcp/internal/proxy"
+	"github.com/abdo-355/http-from-tcp/internal/request"
+	"github.com/abdo-355/http-from-tcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiate(t *testing.T) {
+	offered := []string{Gzip, Deflate}
+
+	testCases := []struct {
+		name     string
+		header   string
+		expected string
+	}{
+		{name: "Empty header", header: "", expected: ""},
+		{name: "Single encoding", header: "deflate", expected: Deflate},
+		{name: "Server preference on tie", header: "deflate, gzip", expected: Gzip},
+		{name: "Higher q wins", header: "gzip;q=0.5, deflate;q=0.8", expected: Deflate},
+		{name: "Wildcard", header: "br, *;q=0.1", expected: Gzip},
+		{name: "Explicit refusal beats wildcard", header: "gzip;q=0, *", expected: Deflate},
+		{name: "Nothing acceptable", header: "br, zstd", expected: ""},
+		{name: "All refused", header: "*;q=0", expected: ""},
+		{name: "Case insensitive", header: "GZIP", expected: Gzip},
+		{name: "Malformed q", header: "gzip;q=abc, deflate", expected: Deflate},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, Negotiate(tc.header, offered))
+		})
+	}
+}
+
+func newRequest(acceptEncoding string) *request.Request {
+	h := headers.NewHeaders()
+	if acceptEncoding != "" {
+		h.Set("accept-encoding", acceptEncoding)
+	}
+	return &request.Request{
+		RequestLine: request.RequestLine{Method: "GET", RequestTarget: "/", HTTPVersion: "1.1"},
+		Headers:     h,
+	}
+}
+
+func fixedHandler(contentType string, body []byte) func(w *response.Writer, req *request.Request) {
+	return func(w *response.Writer, req *request.Request) {
+		h := headers.NewHeaders()
+		h.Set("content-type", contentType)
+		h.Set("content-length", strconv.Itoa(len(body)))
+		w.WriteStatusLine("HTTP/1.1", http.StatusOK, "OK")
+		w.WriteHeaders(h)
+		w.WriteBody(body)
+	}
+}
+
+func TestMiddleware_FixedLength(t *testing.T) {
+	body := []byte(strings.Repeat("hello world ", 200))
+	handler := Middleware(DefaultOptions())(fixedHandler("text/html", body))
+
+	w := response.New()
+	handler(w, newRequest("gzip"))
+
+	h := w.Header()
+	assert.Equal(t, "gzip", h.Get("content-encoding"))
+	assert.Equal(t, "Accept-Encoding", h.Get("vary"))
+	assert.Equal(t, strconv.Itoa(len(w.Body())), h.Get("content-length"))
+	assert.Less(t, len(w.Body()), len(body))
+
+	zr, err := gzip.NewReader(bytes.NewReader(w.Body()))
+	require.NoError(t, err)
+	decoded, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, body, decoded)
+}
+
+func TestMiddleware_KeepsStatusAndWeakensETag(t *testing.T) {
+	testCases := []struct {
+		name     string
+		accept   string
+		etag     string
+		wantETag string
+	}{
+		{name: "Compressed", accept: "gzip", etag: `"abc"`, wantETag: `W/"abc"`},
+		{name: "Already weak", accept: "gzip", etag: `W/"abc"`, wantETag: `W/"abc"`},
+		{name: "Not compressed", accept: "", etag: `"abc"`, wantETag: `"abc"`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			body := []byte(strings.Repeat("hello world ", 200))
+			handler := Middleware(DefaultOptions())(func(w *response.Writer, req *request.Request) {
+				h := headers.NewHeaders()
+				h.Set("content-type", "text/plain")
+				h.Set("etag", tc.etag)
+				w.WriteStatusLine("HTTP/1.1", http.StatusOK, "Fine")
+				w.WriteHeaders(h)
+				w.WriteBody(body)
+			})
+
+			w := response.New()
+			handler(w, newRequest(tc.accept))
+
+			assert.Equal(t, "Fine", w.StatusText())
+			h := w.Header()
+			assert.Equal(t, tc.wantETag, h.Get("etag"))
+		})
+	}
+}
+
+func TestMiddleware_Chunked(t *testing.T) {
+	body := []byte(strings.Repeat("{\"a\":1}", 300))
+	handler := Middleware(DefaultOptions())(func(w *response.Writer, req *request.Request) {
+		h := headers.NewHeaders()
+		h.Set("content-type", "application/json")
+		h.Set("transfer-encoding", "chunked")
+		h.Set("trailer", "X-Checksum")
+		w.WriteStatusLine("HTTP/1.1", http.StatusOK, "OK")
+		w.WriteHeaders(h)
+		w.WriteChunkedBody(body[:1000])
+		w.WriteChunkedBody(body[1000:])
+		w.WriteChunkedBodyDone()
+		w.WriteTrailers(headers.Headers{M: map[string]string{"X-Checksum": "abc"}})
+	})
+
+	w := response.New()
+	handler(w, newRequest("deflate"))
+
+	h := w.Header()
+	assert.Equal(t, "deflate", h.Get("content-encoding"))
+	assert.Equal(t, "", h.Get("content-length"))
+	assert.True(t, w.IsChunked())
+	assert.Equal(t, "abc", w.Trailers().M["X-Checksum"])
+	assert.True(t, strings.HasSuffix(string(w.Bytes()), "0\r\nX-Checksum: abc\r\n\r\n"))
+
+	zr, err := zlib.NewReader(bytes.NewReader(w.Body()))
+	require.NoError(t, err)
+	decoded, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, body, decoded)
+}
+
+func TestMiddleware_Skipped(t *testing.T) {
+	large := []byte(strings.Repeat("a", 2048))
+
+	testCases := []struct {
+		name         string
+		handler      func(w *response.Writer, req *request.Request)
+		accept       string
+		expectedVary string
+	}{
+		{name: "Client does not accept encodings", handler: fixedHandler("text/plain", large), accept: "", expectedVary: "Accept-Encoding"},
+		{name: "Below size threshold", handler: fixedHandler("text/plain", []byte("tiny")), accept: "gzip"},
+		{name: "Ineligible content type", handler: fixedHandler("video/mp4", large), accept: "gzip"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			expected := response.New()
+			tc.handler(expected, newRequest(tc.accept))
+
+			w := response.New()
+			Middleware(DefaultOptions())(tc.handler)(w, newRequest(tc.accept))
+
+			h := w.Header()
+			assert.Equal(t, "", h.Get("content-encoding"))
+			assert.Equal(t, tc.expectedVary, h.Get("vary"))
+			assert.Equal(t, expected.Body(), w.Body())
+		})
+	}
+}
+
+func TestMiddleware_StreamingHandler(t *testing.T) {
+	var out bytes.Buffer
+	w := response.NewWriter(&out)
+	handler := Middleware(DefaultOptions())(func(w *response.Writer, req *request.Request) {
+		h := headers.NewHeaders()
+		h.Set("content-type", "text/event-stream")
+		w.WriteStatusLine("HTTP/1.1", http.StatusOK, "OK")
+		w.WriteHeaders(h)
+		w.WriteBody([]byte(strings.Repeat("data: tick\n\n", 200)))
+		require.NoError(t, w.Flush())
+	})
+
+	handler(w, newRequest("gzip"))
+	assert.Contains(t, out.String(), "content-encoding: gzip", "the flushed part is sent compressed")
+	require.NoError(t, w.Finish())
+
+	res, err := response.FromReader(&out)
+	require.NoError(t, err)
+	assert.Equal(t, "gzip", res.Headers.Get("content-encoding"))
+	assert.Equal(t, "chunked", res.Headers.Get("transfer-encoding"))
+	assert.Equal(t, strings.Repeat("data: tick\n\n", 200), gunzip(t, res.Body))
+}
+
+func TestMiddleware_LargeResponseStreamsCompressed(t *testing.T) {
+	part := []byte(strings.Repeat("a", 1000))
+	opts := DefaultOptions()
+	opts.MaxSize = 1500
+
+	var out bytes.Buffer
+	w := response.NewWriter(&out)
+	handler := Middleware(opts)(func(w *response.Writer, req *request.Request) {
+		h := headers.NewHeaders()
+		h.Set("content-type", "text/plain")
+		h.Set("content-length", strconv.Itoa(3*len(part)))
+		w.WriteStatusLine("HTTP/1.1", http.StatusOK, "OK")
+		w.WriteHeaders(h)
+		w.WriteBody(part)
+		assert.Zero(t, out.Len())
+		w.WriteBody(part)
+		assert.NotZero(t, out.Len(), "the body is sent once it outgrows MaxSize")
+		w.WriteBody(part)
+	})
+
+	handler(w, newRequest("gzip"))
+	require.NoError(t, w.Finish())
+
+	res, err := response.FromReader(&out)
+	require.NoError(t, err)
+	assert.Equal(t, "gzip", res.Headers.Get("content-encoding"))
+	assert.Equal(t, "", res.Headers.Get("content-length"))
+	assert.Equal(t, strings.Repeat("a", 3000), gunzip(t, res.Body))
+}
+
+func TestMiddleware_Proxy(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: first\n\n")
+		w.(http.Flusher).Flush()
+		<-release
+		io.WriteString(w, "data: second\n\n")
+	}))
+	defer upstream.Close()
+
+	p, err := proxy.New(upstream.URL, "")
+	require.NoError(t, err)
+	handler := Middleware(DefaultOptions())(p.Handle)
+
+	pr, pw := io.Pipe()
+	go func() {
+		w := response.NewWriter(pw)
+		handler(w, newRequest("gzip"))
+		w.Finish()
+		pw.Close()
+	}()
+
+	br := bufio.NewReader(pr)
+	var head strings.Builder
+	for {
+		line, err := br.ReadString('\n')
+		require.NoError(t, err)
+		if line == "\r\n" {
+			break
+		}
+		head.WriteString(line)
+	}
+	assert.Contains(t, head.String(), "content-encoding: gzip")
+
+	// the first event decompresses while the upstream is still holding the response open
+	zr, err := gzip.NewReader(httputil.NewChunkedReader(br))
+	require.NoError(t, err)
+	body := bufio.NewReader(zr)
+	first, err := body.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "data: first\n", first)
+	close(release)
+
+	rest, err := io.ReadAll(body)
+	require.NoError(t, err)
+	assert.Equal(t, "\ndata: second\n\n", string(rest))
+	io.Copy(io.Discard, pr)
+}
+
+func gunzip(t *testing.T, b []byte) string {
+	t.Helper()
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	require.NoError(t, err)
+	decoded, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	return string(decoded)
+}
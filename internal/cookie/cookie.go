@@ -0,0 +1,221 @@
+// Package cookie reads the cookies of requests and builds Set-Cookie headers, following
+// RFC 6265.
+package cookie
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abdo-355/http-from-tcp/internal/headers"
+	"github.com/abdo-355/http-from-tcp/internal/request"
+	"github.com/abdo-355/http-from-tcp/internal/response"
+)
+
+// SameSite controls whether a cookie is sent with cross-site requests.
+type SameSite int
+
+const (
+	// SameSiteDefault leaves the attribute out, so the browser's default applies.
+	SameSiteDefault SameSite = iota
+	SameSiteLax
+	SameSiteStrict
+	// SameSiteNone requires Secure.
+	SameSiteNone
+)
+
+func (s SameSite) String() string {
+	switch s {
+	case SameSiteLax:
+		return "Lax"
+	case SameSiteStrict:
+		return "Strict"
+	case SameSiteNone:
+		return "None"
+	default:
+		return ""
+	}
+}
+
+// Cookie is a cookie sent by a client, which only has a Name and a Value, or one to set
+// with a Set-Cookie header.
+type Cookie struct {
+	Name  string
+	Value string
+
+	Domain string
+	Path   string
+	// Expires is left out when zero.
+	Expires time.Time
+	// MaxAge is left out when 0. A negative MaxAge deletes the cookie right away.
+	MaxAge   int
+	Secure   bool
+	HttpOnly bool
+	SameSite SameSite
+	// Partitioned keys the cookie to the top-level site (CHIPS). It requires Secure.
+	Partitioned bool
+}
+
+// Parse reads the name/value pairs of a Cookie header in order. Malformed pairs are
+// skipped, and double quotes around a value are removed.
+func Parse(header string) []Cookie {
+	var cookies []Cookie
+	for pair := range strings.SplitSeq(header, ";") {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || !validName(name) {
+			continue
+		}
+		if len(value) > 1 && value[0] == '"' && value[len(value)-1] == '"' {
+			value = value[1 : len(value)-1]
+		}
+		if !validValue(value) {
+			continue
+		}
+		cookies = append(cookies, Cookie{Name: name, Value: value})
+	}
+	return cookies
+}
+
+// FromRequest returns the cookies req was sent with.
+func FromRequest(req *request.Request) []Cookie {
+	return Parse(req.Headers.Get("cookie"))
+}
+
+// Get returns the first cookie named name that req was sent with.
+func Get(req *request.Request, name string) (Cookie, bool) {
+	for _, c := range FromRequest(req) {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return Cookie{}, false
+}
+
+// Set adds a Set-Cookie header for c to the response, which may already hold others.
+func Set(w *response.Writer, c Cookie) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	w.AddHeader("set-cookie", c.String())
+	return nil
+}
+
+// Add adds a Set-Cookie header for c to h, for handlers building their own headers.
+func Add(h *headers.Headers, c Cookie) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	h.Add("set-cookie", c.String())
+	return nil
+}
+
+// Validate reports whether c can be sent in a Set-Cookie header and will be accepted
+// by browsers: its name must be a token, its value cookie-octets, and the Secure
+// attribute must be set where SameSite=None, Partitioned or a __Secure- or __Host-
+// name prefix require it.
+func (c *Cookie) Validate() error {
+	var errs []error
+	if !validName(c.Name) {
+		errs = append(errs, fmt.Errorf("invalid cookie name %q", c.Name))
+	}
+	if !validValue(c.Value) {
+		errs = append(errs, fmt.Errorf("invalid value for cookie %q", c.Name))
+	}
+	if c.Domain != "" && !validDomain(strings.TrimPrefix(c.Domain, ".")) {
+		errs = append(errs, fmt.Errorf("invalid domain %q", c.Domain))
+	}
+	if strings.ContainsFunc(c.Path, func(r rune) bool { return r < 0x20 || r == 0x7f || r == ';' }) {
+		errs = append(errs, fmt.Errorf("invalid path %q", c.Path))
+	}
+	if !c.Expires.IsZero() && c.Expires.Year() < 1601 {
+		errs = append(errs, fmt.Errorf("expires before 1601: %v", c.Expires))
+	}
+	if !c.Secure {
+		switch {
+		case c.SameSite == SameSiteNone:
+			errs = append(errs, errors.New("SameSite=None requires Secure"))
+		case c.Partitioned:
+			errs = append(errs, errors.New("Partitioned requires Secure"))
+		case strings.HasPrefix(c.Name, "__Secure-"), strings.HasPrefix(c.Name, "__Host-"):
+			errs = append(errs, fmt.Errorf("cookie %q requires Secure", c.Name))
+		}
+	}
+	if strings.HasPrefix(c.Name, "__Host-") && (c.Domain != "" || c.Path != "/") {
+		errs = append(errs, fmt.Errorf("cookie %q requires Path=/ and no Domain", c.Name))
+	}
+	return errors.Join(errs...)
+}
+
+// String formats c as a Set-Cookie value. It does not validate c.
+func (c *Cookie) String() string {
+	var b strings.Builder
+	b.WriteString(c.Name + "=" + c.Value)
+	if c.Domain != "" {
+		b.WriteString("; Domain=" + strings.TrimPrefix(c.Domain, "."))
+	}
+	if c.Path != "" {
+		b.WriteString("; Path=" + c.Path)
+	}
+	if !c.Expires.IsZero() {
+		b.WriteString("; Expires=" + c.Expires.UTC().Format("Mon, 02 Jan 2006 15:04:05 GMT"))
+	}
+	switch {
+	case c.MaxAge > 0:
+		b.WriteString("; Max-Age=" + strconv.Itoa(c.MaxAge))
+	case c.MaxAge < 0:
+		b.WriteString("; Max-Age=0")
+	}
+	if c.Secure {
+		b.WriteString("; Secure")
+	}
+	if c.HttpOnly {
+		b.WriteString("; HttpOnly")
+	}
+	if c.SameSite != SameSiteDefault {
+		b.WriteString("; SameSite=" + c.SameSite.String())
+	}
+	if c.Partitioned {
+		b.WriteString("; Partitioned")
+	}
+	return b.String()
+}
+
+func validName(name string) bool {
+	return name != "" && !headers.InvalidHeaderFieldName(name)
+}
+
+// validValue checks for cookie-octets: printable US-ASCII except whitespace, DQUOTE,
+// comma, semicolon and backslash, optionally wrapped in double quotes.
+func validValue(value string) bool {
+	if len(value) > 1 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = value[1 : len(value)-1]
+	}
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c <= ' ' || c >= 0x7f || c == '"' || c == ',' || c == ';' || c == '\\' {
+			return false
+		}
+	}
+	return true
+}
+
+// validDomain checks for a host name made of letters, digits and hyphens, or an IP address.
+func validDomain(domain string) bool {
+	if domain == "" || len(domain) > 253 {
+		return false
+	}
+	for label := range strings.SplitSeq(domain, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for i := 0; i < len(label); i++ {
+			c := label[i]
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,145 @@
+package cookie
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abdo-355/http-from-tcp/internal/headers"
+	"github.com/abdo-355/http-from-tcp/internal/request"
+	"github.com/abdo-355/http-from-tcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		name     string
+		header   string
+		expected []Cookie
+	}{
+		{name: "Empty", header: "", expected: nil},
+		{name: "Single", header: "session=abc123", expected: []Cookie{{Name: "session", Value: "abc123"}}},
+		{
+			name:     "Several",
+			header:   "session=abc123; theme=dark;lang=en",
+			expected: []Cookie{{Name: "session", Value: "abc123"}, {Name: "theme", Value: "dark"}, {Name: "lang", Value: "en"}},
+		},
+		{name: "Quoted", header: `id="x9"`, expected: []Cookie{{Name: "id", Value: "x9"}}},
+		{name: "Empty value", header: "flag=", expected: []Cookie{{Name: "flag", Value: ""}}},
+		{name: "Value with equals", header: "token=a=b==", expected: []Cookie{{Name: "token", Value: "a=b=="}}},
+		{name: "Duplicate names kept", header: "a=1; a=2", expected: []Cookie{{Name: "a", Value: "1"}, {Name: "a", Value: "2"}}},
+		{
+			name:     "Malformed pairs skipped",
+			header:   "noequals; bad name=1; ok=1; bad=a b; =empty; also=fine",
+			expected: []Cookie{{Name: "ok", Value: "1"}, {Name: "also", Value: "fine"}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, Parse(tc.header))
+		})
+	}
+}
+
+func TestGet(t *testing.T) {
+	r, err := request.RequestFromReader(strings.NewReader(
+		"GET / HTTP/1.1\r\nHost: localhost\r\nCookie: a=1; b=2\r\nCookie: c=3\r\n\r\n"))
+	require.NoError(t, err)
+
+	assert.Len(t, FromRequest(r), 3)
+	c, ok := Get(r, "c")
+	require.True(t, ok)
+	assert.Equal(t, "3", c.Value)
+	_, ok = Get(r, "missing")
+	assert.False(t, ok)
+}
+
+func TestString(t *testing.T) {
+	expires := time.Date(2030, time.January, 2, 15, 4, 5, 0, time.FixedZone("CET", 3600))
+
+	testCases := []struct {
+		name     string
+		cookie   Cookie
+		expected string
+	}{
+		{name: "Bare", cookie: Cookie{Name: "a", Value: "1"}, expected: "a=1"},
+		{
+			name: "All attributes",
+			cookie: Cookie{
+				Name: "session", Value: "abc", Domain: ".example.com", Path: "/app",
+				Expires: expires, MaxAge: 3600, Secure: true, HttpOnly: true,
+				SameSite: SameSiteNone, Partitioned: true,
+			},
+			expected: "session=abc; Domain=example.com; Path=/app; Expires=Wed, 02 Jan 2030 14:04:05 GMT; Max-Age=3600; Secure; HttpOnly; SameSite=None; Partitioned",
+		},
+		{name: "Delete", cookie: Cookie{Name: "a", Value: "", MaxAge: -1}, expected: "a=; Max-Age=0"},
+		{name: "Lax", cookie: Cookie{Name: "a", Value: "1", SameSite: SameSiteLax}, expected: "a=1; SameSite=Lax"},
+		{name: "Strict", cookie: Cookie{Name: "a", Value: "1", SameSite: SameSiteStrict}, expected: "a=1; SameSite=Strict"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.NoError(t, tc.cookie.Validate())
+			assert.Equal(t, tc.expected, tc.cookie.String())
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	testCases := []struct {
+		name   string
+		cookie Cookie
+	}{
+		{name: "Empty name", cookie: Cookie{Value: "1"}},
+		{name: "Name with space", cookie: Cookie{Name: "my cookie", Value: "1"}},
+		{name: "Name with separator", cookie: Cookie{Name: "a;b", Value: "1"}},
+		{name: "Value with semicolon", cookie: Cookie{Name: "a", Value: "1;2"}},
+		{name: "Value with space", cookie: Cookie{Name: "a", Value: "hello world"}},
+		{name: "Value with comma", cookie: Cookie{Name: "a", Value: "1,2"}},
+		{name: "Non ASCII value", cookie: Cookie{Name: "a", Value: "café"}},
+		{name: "Invalid domain", cookie: Cookie{Name: "a", Value: "1", Domain: "exa_mple.com"}},
+		{name: "Path with semicolon", cookie: Cookie{Name: "a", Value: "1", Path: "/a;b"}},
+		{name: "Ancient expiry", cookie: Cookie{Name: "a", Value: "1", Expires: time.Date(1500, 1, 1, 0, 0, 0, 0, time.UTC)}},
+		{name: "SameSite None without Secure", cookie: Cookie{Name: "a", Value: "1", SameSite: SameSiteNone}},
+		{name: "Partitioned without Secure", cookie: Cookie{Name: "a", Value: "1", Partitioned: true}},
+		{name: "Secure prefix", cookie: Cookie{Name: "__Secure-id", Value: "1"}},
+		{name: "Host prefix with domain", cookie: Cookie{Name: "__Host-id", Value: "1", Secure: true, Path: "/", Domain: "example.com"}},
+		{name: "Host prefix without root path", cookie: Cookie{Name: "__Host-id", Value: "1", Secure: true, Path: "/app"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Error(t, tc.cookie.Validate())
+		})
+	}
+
+	valid := Cookie{Name: "__Host-id", Value: `"quoted"`, Secure: true, Path: "/"}
+	assert.NoError(t, valid.Validate())
+}
+
+func TestSet_MultipleHeaders(t *testing.T) {
+	var out bytes.Buffer
+	w := response.NewWriter(&out)
+	require.NoError(t, Set(w, Cookie{Name: "session", Value: "abc", Path: "/", HttpOnly: true}))
+	require.NoError(t, Set(w, Cookie{Name: "theme", Value: "dark", Expires: time.Date(2030, 1, 2, 0, 0, 0, 0, time.UTC)}))
+	assert.Error(t, Set(w, Cookie{Name: "bad name", Value: "1"}))
+
+	h := headers.NewHeaders()
+	require.NoError(t, Add(&h, Cookie{Name: "lang", Value: "en"}))
+	w.WriteStatusLine("HTTP/1.1", http.StatusOK, "OK")
+	w.WriteHeaders(h)
+	require.NoError(t, w.Finish())
+
+	assert.Contains(t, out.String(), "set-cookie: lang=en\r\n")
+	assert.Contains(t, out.String(), "set-cookie: session=abc; Path=/; HttpOnly\r\n")
+	assert.Contains(t, out.String(), "set-cookie: theme=dark; Expires=Wed, 02 Jan 2030 00:00:00 GMT\r\n")
+	assert.NotContains(t, out.String(), "bad name")
+
+	res, err := response.FromReader(strings.NewReader(out.String()))
+	require.NoError(t, err)
+	assert.Len(t, res.Headers.Values("set-cookie"), 3)
+}
@@ -0,0 +1,163 @@
+// Package cors implements cross-origin resource sharing: it answers preflight requests
+// and adds the Access-Control-* headers to actual responses.
+package cors
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abdo-355/http-from-tcp/internal/headers"
+	"github.com/abdo-355/http-from-tcp/internal/request"
+	"github.com/abdo-355/http-from-tcp/internal/response"
+	"github.com/abdo-355/http-from-tcp/internal/server"
+)
+
+// Policy describes which cross-origin requests are allowed.
+type Policy struct {
+	// AllowedOrigins lists the allowed origins. An entry may contain one "*" wildcard,
+	// like "https://*.example.com", and "*" alone allows every origin.
+	AllowedOrigins []string
+	// AllowedMethods lists the methods allowed in preflight requests.
+	AllowedMethods []string
+	// AllowedHeaders lists the request headers allowed in preflight requests.
+	// "*" allows any header.
+	AllowedHeaders []string
+	// ExposedHeaders lists the response headers scripts are allowed to read.
+	ExposedHeaders []string
+	// AllowCredentials lets the browser send cookies and authorization headers.
+	AllowCredentials bool
+	// MaxAge is how long the browser may cache a preflight response. Zero leaves it
+	// up to the browser.
+	MaxAge time.Duration
+}
+
+// DefaultPolicy allows simple requests from any origin without credentials.
+func DefaultPolicy() Policy {
+	return Policy{
+		AllowedOrigins: []string{"*"},
+		AllowedMethods: []string{"GET", "HEAD", "POST"},
+		AllowedHeaders: []string{"Content-Type"},
+	}
+}
+
+// Middleware answers preflight requests according to p and adds the CORS headers to
+// the responses of allowed cross-origin requests.
+func Middleware(p Policy) server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			origin := req.Headers.Get("origin")
+			if origin == "" {
+				next(w, req)
+				return
+			}
+
+			if isPreflight(req) {
+				preflight(w, req, p, origin)
+				return
+			}
+
+			w.AddHeader("vary", "Origin")
+			if p.originAllowed(origin) {
+				w.SetHeader("access-control-allow-origin", p.allowOrigin(origin))
+				if p.AllowCredentials {
+					w.SetHeader("access-control-allow-credentials", "true")
+				}
+				if len(p.ExposedHeaders) > 0 {
+					w.SetHeader("access-control-expose-headers", strings.Join(p.ExposedHeaders, ", "))
+				}
+			}
+			next(w, req)
+		}
+	}
+}
+
+func isPreflight(req *request.Request) bool {
+	return req.RequestLine.Method == "OPTIONS" && req.Headers.Get("access-control-request-method") != ""
+}
+
+// preflight answers with 204. A disallowed request gets no Access-Control-* headers,
+// which makes the browser refuse the actual request.
+func preflight(w *response.Writer, req *request.Request, p Policy, origin string) {
+	h := headers.NewHeaders()
+	h.Set("vary", "Origin, Access-Control-Request-Method, Access-Control-Request-Headers")
+
+	method := req.Headers.Get("access-control-request-method")
+	requested := splitList(req.Headers.Get("access-control-request-headers"))
+	if p.originAllowed(origin) && p.methodAllowed(method) && p.headersAllowed(requested) {
+		h.Set("access-control-allow-origin", p.allowOrigin(origin))
+		h.Set("access-control-allow-methods", strings.Join(p.AllowedMethods, ", "))
+		if len(requested) > 0 {
+			h.Set("access-control-allow-headers", strings.Join(requested, ", "))
+		}
+		if p.AllowCredentials {
+			h.Set("access-control-allow-credentials", "true")
+		}
+		if p.MaxAge > 0 {
+			h.Set("access-control-max-age", strconv.Itoa(int(p.MaxAge.Seconds())))
+		}
+	}
+
+	w.WriteStatusLine("HTTP/1.1", http.StatusNoContent, http.StatusText(http.StatusNoContent))
+	w.WriteHeaders(h)
+}
+
+// allowOrigin is the Access-Control-Allow-Origin value for an allowed origin. Browsers
+// reject "*" on credentialed requests, so the origin is echoed back in that case.
+func (p Policy) allowOrigin(origin string) string {
+	if !p.AllowCredentials && slices.Contains(p.AllowedOrigins, "*") {
+		return "*"
+	}
+	return origin
+}
+
+func (p Policy) originAllowed(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, pattern := range p.AllowedOrigins {
+		if matchOrigin(strings.ToLower(pattern), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchOrigin(pattern, origin string) bool {
+	prefix, suffix, wildcard := strings.Cut(pattern, "*")
+	if !wildcard {
+		return pattern == origin
+	}
+	return len(origin) > len(prefix)+len(suffix) &&
+		strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix)
+}
+
+func (p Policy) methodAllowed(method string) bool {
+	return slices.ContainsFunc(p.AllowedMethods, func(m string) bool {
+		return strings.EqualFold(m, method)
+	})
+}
+
+func (p Policy) headersAllowed(requested []string) bool {
+	if slices.Contains(p.AllowedHeaders, "*") {
+		return true
+	}
+	for _, name := range requested {
+		if !slices.ContainsFunc(p.AllowedHeaders, func(a string) bool {
+			return strings.EqualFold(a, name)
+		}) {
+			return false
+		}
+	}
+	return true
+}
+
+func splitList(value string) []string {
+	var items []string
+	for item := range strings.SplitSeq(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, strings.ToLower(item))
+		}
+	}
+	return items
+}
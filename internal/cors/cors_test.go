@@ -0,0 +1,161 @@
+package cors
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/abdo-355/http-from-tcp/internal/headers"
+	"github.com/abdo-355/http-from-tcp/internal/request"
+	"github.com/abdo-355/http-from-tcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRequest(method string, hdrs map[string]string) *request.Request {
+	h := headers.NewHeaders()
+	for k, v := range hdrs {
+		h.Set(k, v)
+	}
+	return &request.Request{
+		RequestLine: request.RequestLine{Method: method, RequestTarget: "/api", HTTPVersion: "1.1"},
+		Headers:     h,
+	}
+}
+
+func okHandler(called *bool) func(w *response.Writer, req *request.Request) {
+	return func(w *response.Writer, req *request.Request) {
+		*called = true
+		h := headers.NewHeaders()
+		h.Set("vary", "Accept-Encoding")
+		w.WriteStatusLine("HTTP/1.1", http.StatusOK, "OK")
+		w.WriteHeaders(h)
+		w.WriteBody([]byte("ok"))
+	}
+}
+
+func TestMatchOrigin(t *testing.T) {
+	testCases := []struct {
+		name     string
+		pattern  string
+		origin   string
+		expected bool
+	}{
+		{name: "Exact", pattern: "https://example.com", origin: "https://example.com", expected: true},
+		{name: "Different scheme", pattern: "https://example.com", origin: "http://example.com", expected: false},
+		{name: "Any origin", pattern: "*", origin: "http://localhost:3000", expected: true},
+		{name: "Subdomain wildcard", pattern: "https://*.example.com", origin: "https://app.example.com", expected: true},
+		{name: "Wildcard needs a subdomain", pattern: "https://*.example.com", origin: "https://.example.com", expected: false},
+		{name: "Wildcard suffix mismatch", pattern: "https://*.example.com", origin: "https://example.com.evil.io", expected: false},
+		{name: "Port wildcard", pattern: "http://localhost:*", origin: "http://localhost:5173", expected: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, matchOrigin(tc.pattern, tc.origin))
+		})
+	}
+}
+
+func TestMiddleware_Preflight(t *testing.T) {
+	policy := Policy{
+		AllowedOrigins:   []string{"https://*.example.com"},
+		AllowedMethods:   []string{"GET", "PUT"},
+		AllowedHeaders:   []string{"Content-Type", "Authorization"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	}
+
+	testCases := []struct {
+		name    string
+		headers map[string]string
+		allowed bool
+	}{
+		{
+			name: "Allowed",
+			headers: map[string]string{
+				"origin":                         "https://app.example.com",
+				"access-control-request-method":  "PUT",
+				"access-control-request-headers": "Authorization, content-type",
+			},
+			allowed: true,
+		},
+		{
+			name:    "Origin not allowed",
+			headers: map[string]string{"origin": "https://evil.io", "access-control-request-method": "GET"},
+		},
+		{
+			name:    "Method not allowed",
+			headers: map[string]string{"origin": "https://app.example.com", "access-control-request-method": "DELETE"},
+		},
+		{
+			name: "Header not allowed",
+			headers: map[string]string{
+				"origin":                         "https://app.example.com",
+				"access-control-request-method":  "GET",
+				"access-control-request-headers": "X-Secret",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			called := false
+			w := response.New()
+			Middleware(policy)(okHandler(&called))(w, newRequest("OPTIONS", tc.headers))
+
+			assert.False(t, called)
+			assert.Equal(t, http.StatusNoContent, w.StatusCode())
+			h := w.Header()
+			assert.Contains(t, h.Get("vary"), "Origin")
+			if !tc.allowed {
+				assert.Equal(t, "", h.Get("access-control-allow-origin"))
+				return
+			}
+			assert.Equal(t, "https://app.example.com", h.Get("access-control-allow-origin"))
+			assert.Equal(t, "GET, PUT", h.Get("access-control-allow-methods"))
+			assert.Equal(t, "authorization, content-type", h.Get("access-control-allow-headers"))
+			assert.Equal(t, "true", h.Get("access-control-allow-credentials"))
+			assert.Equal(t, "600", h.Get("access-control-max-age"))
+		})
+	}
+}
+
+func TestMiddleware_ActualRequest(t *testing.T) {
+	t.Run("Wildcard origin without credentials", func(t *testing.T) {
+		called := false
+		policy := DefaultPolicy()
+		policy.ExposedHeaders = []string{"X-Request-Id"}
+		w := response.New()
+		Middleware(policy)(okHandler(&called))(w, newRequest("GET", map[string]string{"origin": "http://localhost:3000"}))
+
+		require.True(t, called)
+		h := w.Header()
+		assert.Equal(t, "*", h.Get("access-control-allow-origin"))
+		assert.Equal(t, "X-Request-Id", h.Get("access-control-expose-headers"))
+		assert.Equal(t, "", h.Get("access-control-allow-credentials"))
+		assert.Equal(t, "Accept-Encoding, Origin", h.Get("vary"))
+	})
+
+	t.Run("Origin not allowed", func(t *testing.T) {
+		called := false
+		policy := Policy{AllowedOrigins: []string{"https://example.com"}}
+		w := response.New()
+		Middleware(policy)(okHandler(&called))(w, newRequest("GET", map[string]string{"origin": "https://evil.io"}))
+
+		require.True(t, called)
+		h := w.Header()
+		assert.Equal(t, "", h.Get("access-control-allow-origin"))
+		assert.Equal(t, "Accept-Encoding, Origin", h.Get("vary"))
+	})
+
+	t.Run("Same-origin request is untouched", func(t *testing.T) {
+		called := false
+		w := response.New()
+		Middleware(DefaultPolicy())(okHandler(&called))(w, newRequest("GET", nil))
+
+		require.True(t, called)
+		h := w.Header()
+		assert.Equal(t, "Accept-Encoding", h.Get("vary"))
+	})
+}
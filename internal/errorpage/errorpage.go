@@ -0,0 +1,160 @@
+// Package errorpage renders error responses as plain text, HTML or RFC 9457 problem
+// details, picking the format the client prefers according to its Accept header.
+package errorpage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+
+	"github.com/abdo-355/http-from-tcp/internal/headers"
+	"github.com/abdo-355/http-from-tcp/internal/negotiate"
+	"github.com/abdo-355/http-from-tcp/internal/request"
+	"github.com/abdo-355/http-from-tcp/internal/response"
+)
+
+// Problem describes an error, with the members of an RFC 9457 problem details object.
+type Problem struct {
+	// Type is a URI identifying the kind of problem. Empty means "about:blank": nothing
+	// more specific than the status code.
+	Type     string `json:"type,omitempty"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	// RequestID is an extension member tying the error to the server's logs.
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// NewProblem describes err, answered with status, for req.
+func NewProblem(req *request.Request, status int, err error) Problem {
+	p := Problem{
+		Title:     http.StatusText(status),
+		Status:    status,
+		Instance:  req.RequestLine.RequestTarget,
+		RequestID: req.ID,
+	}
+	if err != nil {
+		p.Detail = err.Error()
+	}
+	return p
+}
+
+// Renderer turns a Problem into a response body.
+type Renderer interface {
+	// MediaTypes lists the media types the renderer can stand for in content negotiation.
+	// The first one is sent as Content-Type.
+	MediaTypes() []string
+	Render(p Problem) ([]byte, error)
+}
+
+// Text renders "404 Not Found: detail" as text/plain.
+type Text struct{}
+
+func (Text) MediaTypes() []string { return []string{"text/plain"} }
+
+func (Text) Render(p Problem) ([]byte, error) {
+	s := fmt.Sprintf("%d %s", p.Status, p.Title)
+	if p.Detail != "" {
+		s += ": " + p.Detail
+	}
+	return []byte(s), nil
+}
+
+// JSON renders application/problem+json. Clients asking for application/json get it too.
+type JSON struct{}
+
+func (JSON) MediaTypes() []string { return []string{"application/problem+json", "application/json"} }
+
+func (JSON) Render(p Problem) ([]byte, error) {
+	return json.Marshal(p)
+}
+
+// DefaultTemplate is the page HTML renders when it has no Template.
+var DefaultTemplate = template.Must(template.New("error").Parse(
+	`<html><head><title>{{.Status}} {{.Title}}</title></head><body><h1>{{.Title}}</h1>{{if .Detail}}<p>{{.Detail}}</p>{{end}}</body></html>`))
+
+// HTML renders text/html pages by executing Template with the Problem.
+type HTML struct {
+	Template *template.Template
+}
+
+func (HTML) MediaTypes() []string { return []string{"text/html"} }
+
+func (h HTML) Render(p Problem) ([]byte, error) {
+	tmpl := h.Template
+	if tmpl == nil {
+		tmpl = DefaultTemplate
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, p); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Pages writes error responses with the renderer that best matches the Accept header.
+type Pages struct {
+	// Renderers are the formats offered. The first one is used when the client does not
+	// say what it accepts, or accepts none of them.
+	Renderers []Renderer
+}
+
+// New creates Pages offering the given renderers.
+func New(renderers ...Renderer) *Pages {
+	return &Pages{Renderers: renderers}
+}
+
+// Default offers plain text first, then HTML and problem details.
+var Default = New(Text{}, HTML{}, JSON{})
+
+// Write writes the error response for req with Default.
+func Write(w *response.Writer, req *request.Request, status int, err error) {
+	Default.Write(w, req, status, err)
+}
+
+// Write writes the error response for req. It is a Handler.
+func (p *Pages) Write(w *response.Writer, req *request.Request, status int, err error) {
+	problem := NewProblem(req, status, err)
+
+	var r Renderer = Text{}
+	if len(p.Renderers) > 0 {
+		r = p.negotiate(req.Headers.Get("accept"))
+	}
+	body, renderErr := r.Render(problem)
+	if renderErr != nil {
+		req.Logger().Error("error rendering error page", "err", renderErr)
+		r = Text{}
+		body, _ = r.Render(problem)
+	}
+
+	h := headers.NewHeaders()
+	h.Set("content-type", r.MediaTypes()[0])
+	if len(p.Renderers) > 1 {
+		h.Set("vary", "Accept")
+	}
+	w.WriteStatusLine("HTTP/1.1", status, http.StatusText(status))
+	w.WriteHeaders(h)
+	w.WriteBody(body)
+}
+
+// negotiate returns the renderer the client accepts with the highest quality, preferring
+// earlier renderers on ties.
+func (p *Pages) negotiate(accept string) Renderer {
+	var offers []string
+	renderers := map[string]Renderer{}
+	for _, r := range p.Renderers {
+		for _, mediaType := range r.MediaTypes() {
+			if _, ok := renderers[mediaType]; !ok {
+				offers = append(offers, mediaType)
+				renderers[mediaType] = r
+			}
+		}
+	}
+	if r, ok := renderers[negotiate.BestContentType(accept, offers)]; ok {
+		return r
+	}
+	return p.Renderers[0]
+}
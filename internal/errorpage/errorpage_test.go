@@ -0,0 +1,121 @@
+package errorpage
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"testing"
+
+	"github.com/abdo-355/http-from-tcp/internal/headers"
+	"github.com/abdo-355/http-from-tcp/internal/request"
+	"github.com/abdo-355/http-from-tcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRequest(accept string) *request.Request {
+	req := &request.Request{
+		RequestLine: request.RequestLine{Method: "GET", RequestTarget: "/items/7", HTTPVersion: "1.1"},
+		Headers:     headers.NewHeaders(),
+		ID:          "req-1",
+	}
+	if accept != "" {
+		req.Headers.Set("accept", accept)
+	}
+	return req
+}
+
+func TestWrite_Negotiation(t *testing.T) {
+	testCases := []struct {
+		name        string
+		accept      string
+		contentType string
+	}{
+		{name: "No Accept", accept: "", contentType: "text/plain"},
+		{name: "Anything", accept: "*/*", contentType: "text/plain"},
+		{name: "Browser", accept: "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", contentType: "text/html"},
+		{name: "Problem details", accept: "application/problem+json", contentType: "application/problem+json"},
+		{name: "Plain JSON", accept: "application/json", contentType: "application/problem+json"},
+		{name: "Quality", accept: "text/html;q=0.5, application/json", contentType: "application/problem+json"},
+		{name: "Type wildcard", accept: "text/*;q=0.9, text/plain;q=0.1", contentType: "text/html"},
+		{name: "Excluded", accept: "text/plain;q=0, */*", contentType: "text/html"},
+		{name: "Nothing acceptable", accept: "image/png", contentType: "text/plain"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := response.New()
+			Write(w, newRequest(tc.accept), http.StatusNotFound, errors.New("no such item"))
+
+			assert.Equal(t, http.StatusNotFound, w.StatusCode())
+			h := w.Header()
+			assert.Equal(t, tc.contentType, h.Get("content-type"))
+			assert.Equal(t, "Accept", h.Get("vary"))
+		})
+	}
+}
+
+func TestRenderers(t *testing.T) {
+	problem := NewProblem(newRequest(""), http.StatusBadRequest, errors.New("<b>bad</b> input"))
+
+	body, err := Text{}.Render(problem)
+	require.NoError(t, err)
+	assert.Equal(t, "400 Bad Request: <b>bad</b> input", string(body))
+
+	body, err = JSON{}.Render(problem)
+	require.NoError(t, err)
+	var got map[string]any
+	require.NoError(t, json.Unmarshal(body, &got))
+	assert.Equal(t, map[string]any{
+		"title":      "Bad Request",
+		"status":     float64(400),
+		"detail":     "<b>bad</b> input",
+		"instance":   "/items/7",
+		"request_id": "req-1",
+	}, got)
+
+	body, err = HTML{}.Render(problem)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), "<title>400 Bad Request</title>")
+	assert.Contains(t, string(body), "<p>&lt;b&gt;bad&lt;/b&gt; input</p>")
+
+	custom := template.Must(template.New("custom").Parse(`<p>{{.Status}}: {{.RequestID}}</p>`))
+	body, err = HTML{Template: custom}.Render(problem)
+	require.NoError(t, err)
+	assert.Equal(t, "<p>400: req-1</p>", string(body))
+
+	body, err = Text{}.Render(NewProblem(newRequest(""), http.StatusPreconditionFailed, nil))
+	require.NoError(t, err)
+	assert.Equal(t, "412 Precondition Failed", string(body))
+}
+
+func TestWrite_RenderErrorFallsBackToText(t *testing.T) {
+	broken := template.Must(template.New("broken").Parse(`{{.Missing}}`))
+	pages := New(HTML{Template: broken})
+
+	w := response.New()
+	pages.Write(w, newRequest("text/html"), http.StatusTeapot, errors.New("short and stout"))
+
+	h := w.Header()
+	assert.Equal(t, "text/plain", h.Get("content-type"))
+	assert.Equal(t, "", h.Get("vary"))
+	assert.Equal(t, "418 I'm a teapot: short and stout", string(w.Body()))
+}
+
+func TestError(t *testing.T) {
+	req := newRequest("")
+	w := response.New()
+	Error(w, req, http.StatusNotFound, errors.New("no route"))
+	res, err := response.FromReader(bytes.NewReader(w.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, "404 Not Found: no route", string(res.Body))
+
+	var got int
+	req.SetContext(NewContext(req.Context(), func(w *response.Writer, req *request.Request, status int, err error) {
+		got = status
+	}))
+	Error(response.New(), req, http.StatusTeapot, nil)
+	assert.Equal(t, http.StatusTeapot, got)
+}
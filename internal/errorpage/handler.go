@@ -0,0 +1,29 @@
+package errorpage
+
+import (
+	"context"
+
+	"github.com/abdo-355/http-from-tcp/internal/request"
+	"github.com/abdo-355/http-from-tcp/internal/response"
+)
+
+// Handler writes the response for an error, with status as its status code. When the
+// request could not be parsed, req only carries its ID.
+type Handler func(w *response.Writer, req *request.Request, status int, err error)
+
+type handlerKey struct{}
+
+// NewContext returns a copy of ctx in which Error answers with h.
+func NewContext(ctx context.Context, h Handler) context.Context {
+	return context.WithValue(ctx, handlerKey{}, h)
+}
+
+// Error answers req with an error response written by the Handler of its context, or by
+// Write without one, so that errors from handlers and middlewares look like the server's own.
+func Error(w *response.Writer, req *request.Request, status int, err error) {
+	h, _ := req.Context().Value(handlerKey{}).(Handler)
+	if h == nil {
+		h = Write
+	}
+	h(w, req, status, err)
+}
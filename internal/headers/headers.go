@@ -4,6 +4,7 @@ package headers
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"strings"
 )
 
@@ -29,6 +30,11 @@ func (h Headers) Parse(data []byte) (n int, done bool, err error) {
 		return 2, true, nil
 	}
 
+	// a lone CR or LF inside a line could smuggle in a header line of its own
+	if bytes.ContainsAny(data[:idx], "\r\n") {
+		return 0, false, fmt.Errorf("header line contains a bare CR or LF")
+	}
+
 	cleanedStr := string(bytes.TrimSpace(data[:idx]))
 	parts := strings.SplitN(cleanedStr, ":", 2)
 
@@ -48,7 +54,7 @@ func (h Headers) Parse(data []byte) (n int, done bool, err error) {
 	if h.M[key] == "" {
 		h.M[key] = strings.TrimSpace(parts[1])
 	} else {
-		h.M[key] = h.M[key] + ", " + strings.TrimSpace(parts[1])
+		h.M[key] = h.M[key] + separator(key) + strings.TrimSpace(parts[1])
 	}
 
 	return len(data[:idx]) + 2, false, nil
@@ -90,10 +96,95 @@ func (h *Headers) Len() int {
 	return len(h.M)
 }
 
+// Set replaces the value of key. CR and LF in value become spaces, so a value can
+// never start a header line of its own.
 func (h *Headers) Set(key, value string) {
-	h.M[strings.ToLower(key)] = value
+	h.M[strings.ToLower(key)] = cleanValue(value)
+}
+
+// Add appends value to the existing value of key, the same way repeated header lines
+// are combined when parsing: comma separated for most headers. Like Set, it turns CR
+// and LF into spaces.
+func (h *Headers) Add(key, value string) {
+	key = strings.ToLower(key)
+	value = cleanValue(value)
+	if h.M[key] == "" {
+		h.M[key] = value
+	} else {
+		h.M[key] = h.M[key] + separator(key) + value
+	}
+}
+
+// separator joins repeated values of key. Set-Cookie values cannot be comma joined, as
+// cookie attributes contain commas (RFC 9110 section 5.3), so they are kept one per line
+// and written back as separate header lines. Since no stored value can contain a LF of
+// its own (Parse refuses them, Set and Add replace them), it only ever separates values. Cookie lines are joined the way RFC 9113
+// section 8.2.3 does it.
+func separator(key string) string {
+	switch key {
+	case "set-cookie":
+		return "\n"
+	case "cookie":
+		return "; "
+	default:
+		return ", "
+	}
+}
+
+// Values returns the values of key as separate lines: one per Set-Cookie line, and the
+// combined value of any other header.
+func (h *Headers) Values(key string) []string {
+	v := h.Get(key)
+	if v == "" {
+		return nil
+	}
+	return lines(strings.ToLower(key), v)
+}
+
+// Write writes the headers in wire format, one "key: value" line per header and per
+// Set-Cookie value, without the empty line that ends them. CR and LF left in a value
+// assigned to M directly are written as spaces.
+func (h *Headers) Write(w io.Writer) error {
+	for k, v := range h.M {
+		for _, line := range lines(strings.ToLower(k), v) {
+			if _, err := fmt.Fprintf(w, "%s: %s\r\n", k, cleanValue(line)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// lines splits the value of key into the lines it is sent as.
+func lines(key, value string) []string {
+	if key == "set-cookie" {
+		return strings.Split(value, "\n")
+	}
+	return []string{value}
+}
+
+// cleanValue replaces CR and LF in a header value with spaces.
+func cleanValue(v string) string {
+	if !strings.ContainsAny(v, "\r\n") {
+		return v
+	}
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(v)
+}
+
+// Del removes key from the headers.
+func (h *Headers) Del(key string) {
+	delete(h.M, strings.ToLower(key))
+}
+
+// Clone returns a copy of the headers that can be modified independently.
+func (h *Headers) Clone() Headers {
+	c := NewHeaders()
+	for k, v := range h.M {
+		c.M[k] = v
+	}
+	return c
 }
 
 func (h *Headers) SetTrailer(key, value string) {
-	h.M[key] = value
+	h.M[key] = cleanValue(value)
 }
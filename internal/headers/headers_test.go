@@ -1,6 +1,7 @@
 package headers
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -57,6 +58,20 @@ func TestHeadersParse(t *testing.T) {
 			expectedHeaders: Headers{M: map[string]string{"content-type": "application/json"}},
 			expectError:     false,
 		},
+		{
+			name:            "Invalid bare LF in header value",
+			initialHeaders:  NewHeaders(),
+			data:            []byte("Host: localhost\nX-Injected: 1\r\n\r\n"),
+			expectedHeaders: Headers{M: map[string]string{}},
+			expectError:     true,
+		},
+		{
+			name:            "Invalid bare CR in header value",
+			initialHeaders:  NewHeaders(),
+			data:            []byte("Host: local\rhost\r\n\r\n"),
+			expectedHeaders: Headers{M: map[string]string{}},
+			expectError:     true,
+		},
 		{
 			name:            "Invalid character in header key",
 			initialHeaders:  NewHeaders(),
@@ -238,3 +253,95 @@ func TestHeaders_SetTrailer(t *testing.T) {
 	h.SetTrailer("Checksum", "abc123")
 	assert.Equal(t, "abc123", h.M["Checksum"]) // Trailers are case-sensitive
 }
+
+func TestHeaders_Add(t *testing.T) {
+	h := NewHeaders()
+
+	h.Add("Vary", "Origin")
+	assert.Equal(t, "Origin", h.Get("vary"))
+
+	h.Add("vary", "Accept-Encoding")
+	assert.Equal(t, "Origin, Accept-Encoding", h.Get("vary"))
+}
+
+func TestHeaders_DelAndClone(t *testing.T) {
+	h := Headers{M: map[string]string{"host": "example.com", "accept": "*/*"}}
+
+	c := h.Clone()
+	c.Del("Host")
+	assert.Equal(t, "", c.Get("host"))
+	assert.Equal(t, "example.com", h.Get("host"))
+	assert.Equal(t, 1, c.Len())
+}
+
+func TestHeaders_SetCookie(t *testing.T) {
+	h := NewHeaders()
+	data := "Set-Cookie: a=1; Expires=Wed, 02 Jan 2030 00:00:00 GMT\r\nSet-Cookie: b=2\r\nCookie: x=1\r\nCookie: y=2\r\n\r\n"
+	for {
+		n, done, err := h.Parse([]byte(data))
+		require.NoError(t, err)
+		data = data[n:]
+		if done {
+			break
+		}
+	}
+	h.Add("set-cookie", "c=3")
+
+	assert.Equal(t, []string{"a=1; Expires=Wed, 02 Jan 2030 00:00:00 GMT", "b=2", "c=3"}, h.Values("set-cookie"))
+	assert.Equal(t, "x=1; y=2", h.Get("cookie"))
+	assert.Equal(t, []string{"x=1; y=2"}, h.Values("cookie"))
+	assert.Nil(t, h.Values("missing"))
+
+	var buf strings.Builder
+	require.NoError(t, h.Write(&buf))
+	assert.Equal(t, 4, strings.Count(buf.String(), "\r\n"))
+	assert.Contains(t, buf.String(), "set-cookie: b=2\r\n")
+	assert.Contains(t, buf.String(), "set-cookie: c=3\r\n")
+	assert.Contains(t, buf.String(), "cookie: x=1; y=2\r\n")
+}
+
+func TestHeaders_RefusesLineBreaks(t *testing.T) {
+	testCases := []struct {
+		name string
+		set  func(h *Headers)
+		want string
+	}{
+		{
+			name: "Set",
+			set:  func(h *Headers) { h.Set("x-note", "one\r\nx-injected: yes") },
+			want: "x-note: one  x-injected: yes\r\n",
+		},
+		{
+			name: "Add",
+			set: func(h *Headers) {
+				h.Add("x-note", "one")
+				h.Add("x-note", "two\nx-injected: yes")
+			},
+			want: "x-note: one, two x-injected: yes\r\n",
+		},
+		{
+			name: "Add Set-Cookie",
+			set: func(h *Headers) {
+				h.Add("set-cookie", "a=1")
+				h.Add("set-cookie", "b=2\nx-injected: yes")
+			},
+			want: "set-cookie: a=1\r\nset-cookie: b=2 x-injected: yes\r\n",
+		},
+		{
+			name: "Assigned to M",
+			set:  func(h *Headers) { h.M["x-note"] = "one\r\nx-injected: yes" },
+			want: "x-note: one  x-injected: yes\r\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := NewHeaders()
+			tc.set(&h)
+
+			var buf strings.Builder
+			require.NoError(t, h.Write(&buf))
+			assert.Equal(t, tc.want, buf.String())
+		})
+	}
+}
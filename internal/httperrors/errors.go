@@ -18,3 +18,7 @@ func New(code int, err error) StatusError {
 func Newf(code int, format string, a ...any) StatusError {
 	return StatusError{Code: code, Err: fmt.Errorf(format, a...)}
 }
+
+func (se StatusError) Unwrap() error {
+	return se.Err
+}
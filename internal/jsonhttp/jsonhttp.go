@@ -0,0 +1,210 @@
+// Package jsonhttp decodes JSON request bodies strictly and writes JSON responses,
+// including RFC 9457 problem details for errors.
+package jsonhttp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/abdo-355/http-from-tcp/internal/errorpage"
+	"github.com/abdo-355/http-from-tcp/internal/headers"
+	"github.com/abdo-355/http-from-tcp/internal/httperrors"
+	"github.com/abdo-355/http-from-tcp/internal/request"
+	"github.com/abdo-355/http-from-tcp/internal/response"
+)
+
+// DecodeOptions controls what Decode accepts.
+type DecodeOptions struct {
+	// MaxSize caps the body. Zero uses the one of DefaultDecodeOptions.
+	MaxSize int64
+	// DisallowUnknownFields rejects objects with fields the target struct does not have.
+	DisallowUnknownFields bool
+}
+
+// DefaultDecodeOptions accepts bodies up to 1MB and rejects unknown fields.
+func DefaultDecodeOptions() DecodeOptions {
+	return DecodeOptions{MaxSize: 1 << 20, DisallowUnknownFields: true}
+}
+
+// FieldError describes what is wrong with one field of a request body.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists the invalid fields of a request body.
+type ValidationError struct {
+	Errors []FieldError
+}
+
+// Add records that field is invalid.
+func (e *ValidationError) Add(field, message string) {
+	e.Errors = append(e.Errors, FieldError{Field: field, Message: message})
+}
+
+// Err returns e, or nil when no field was added, so Validate methods can end with it.
+func (e *ValidationError) Err() error {
+	if e == nil || len(e.Errors) == 0 {
+		return nil
+	}
+	return e
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, fe := range e.Errors {
+		msgs[i] = fe.Field + ": " + fe.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Validator is implemented by request types that check their fields once decoded.
+type Validator interface {
+	Validate() error
+}
+
+// Decode reads req's body into v. The body must be a single JSON value sent as
+// application/json or a +json type. Decode answers with httperrors.StatusError values:
+// 415 for other content types, 413 for bodies over opts.MaxSize, 400 for malformed JSON,
+// unknown fields and mismatched types, and 422 when v's Validate method fails. Field
+// problems are reported as a *ValidationError wrapped in the StatusError.
+func Decode(req *request.Request, v any, opts DecodeOptions) error {
+	mediaType, _, err := mime.ParseMediaType(req.Headers.Get("content-type"))
+	if err != nil || (mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json")) {
+		return httperrors.Newf(http.StatusUnsupportedMediaType, "content-type must be application/json")
+	}
+
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = DefaultDecodeOptions().MaxSize
+	}
+
+	body, err := req.BodyReader()
+	if err != nil {
+		return httperrors.Newf(http.StatusBadRequest, "error reading body: %w", err)
+	}
+	// one byte past the limit tells a body over it apart from one that fits exactly
+	limited := &io.LimitedReader{R: body, N: opts.MaxSize + 1}
+	tooLarge := func() bool { return limited.N == 0 }
+
+	dec := json.NewDecoder(limited)
+	if opts.DisallowUnknownFields {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(v); err != nil {
+		if tooLarge() {
+			return httperrors.Newf(http.StatusRequestEntityTooLarge, "body exceeds %d bytes", opts.MaxSize)
+		}
+		return decodeError(err)
+	}
+	_, err = dec.Token()
+	if tooLarge() {
+		return httperrors.Newf(http.StatusRequestEntityTooLarge, "body exceeds %d bytes", opts.MaxSize)
+	}
+	if err != io.EOF {
+		return httperrors.Newf(http.StatusBadRequest, "body must contain a single JSON value")
+	}
+
+	if val, ok := v.(Validator); ok {
+		if err := val.Validate(); err != nil {
+			return httperrors.New(http.StatusUnprocessableEntity, err)
+		}
+	}
+	return nil
+}
+
+func decodeError(err error) error {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.Is(err, io.EOF):
+		return httperrors.Newf(http.StatusBadRequest, "body is empty")
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return httperrors.Newf(http.StatusBadRequest, "malformed JSON: unexpected end of body")
+	case errors.As(err, &syntaxErr):
+		return httperrors.Newf(http.StatusBadRequest, "malformed JSON at offset %d: %w", syntaxErr.Offset, err)
+	case errors.As(err, &typeErr):
+		field := typeErr.Field
+		if field == "" {
+			field = "body"
+		}
+		ve := &ValidationError{}
+		ve.Add(field, "must be "+typeName(typeErr.Type.Kind()))
+		return httperrors.New(http.StatusBadRequest, ve)
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// encoding/json has no error type for unknown fields
+		ve := &ValidationError{}
+		ve.Add(strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`), "unknown field")
+		return httperrors.New(http.StatusBadRequest, ve)
+	default:
+		return httperrors.Newf(http.StatusBadRequest, "invalid JSON: %w", err)
+	}
+}
+
+// typeName describes a Go kind the way a JSON client thinks of it.
+func typeName(kind reflect.Kind) string {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.String:
+		return "a string"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	default:
+		return "an object"
+	}
+}
+
+// Write answers with status and v encoded as JSON. Nothing is written when v cannot be
+// encoded.
+func Write(w *response.Writer, status int, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("encoding JSON response: %w", err)
+	}
+	writeBody(w, status, "application/json", body)
+	return nil
+}
+
+// problem adds the invalid fields to the problem details of validation errors.
+type problem struct {
+	errorpage.Problem
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+// Error answers with err as application/problem+json. The status comes from an
+// httperrors.StatusError in err's chain, and a *ValidationError adds an "errors" member
+// listing the invalid fields. Other errors are logged and answered with a bare 500, so
+// internal details do not leak to clients.
+func Error(w *response.Writer, req *request.Request, err error) {
+	var se httperrors.StatusError
+	if !errors.As(err, &se) {
+		req.Logger().Error("error handling JSON request", "err", err)
+		se = httperrors.New(http.StatusInternalServerError, nil)
+	}
+
+	p := problem{Problem: errorpage.NewProblem(req, se.Code, se.Err)}
+	var ve *ValidationError
+	if errors.As(err, &ve) {
+		p.Errors = ve.Errors
+	}
+	body, _ := json.Marshal(p)
+	writeBody(w, se.Code, "application/problem+json", body)
+}
+
+func writeBody(w *response.Writer, status int, contentType string, body []byte) {
+	h := headers.NewHeaders()
+	h.Set("content-type", contentType)
+	w.WriteStatusLine("HTTP/1.1", status, http.StatusText(status))
+	w.WriteHeaders(h)
+	w.WriteBody(body)
+}
@@ -0,0 +1,223 @@
+package jsonhttp
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/abdo-355/http-from-tcp/internal/headers"
+	"github.com/abdo-355/http-from-tcp/internal/httperrors"
+	"github.com/abdo-355/http-from-tcp/internal/request"
+	"github.com/abdo-355/http-from-tcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type item struct {
+	Name  string   `json:"name"`
+	Price int      `json:"price"`
+	Tags  []string `json:"tags"`
+}
+
+func (i item) Validate() error {
+	var ve ValidationError
+	if i.Name == "" {
+		ve.Add("name", "is required")
+	}
+	if i.Price < 0 {
+		ve.Add("price", "must not be negative")
+	}
+	return ve.Err()
+}
+
+func newRequest(contentType, body string) *request.Request {
+	req := &request.Request{
+		RequestLine: request.RequestLine{Method: "POST", RequestTarget: "/items", HTTPVersion: "1.1"},
+		Headers:     headers.NewHeaders(),
+		Body:        []byte(body),
+		ID:          "req-1",
+	}
+	if contentType != "" {
+		req.Headers.Set("content-type", contentType)
+	}
+	req.Headers.Set("content-length", strconv.Itoa(len(body)))
+	return req
+}
+
+func TestDecode(t *testing.T) {
+	var it item
+	err := Decode(newRequest("application/json; charset=utf-8", `{"name":"mouse","price":20,"tags":["usb"]}`), &it, DefaultDecodeOptions())
+	require.NoError(t, err)
+	assert.Equal(t, item{Name: "mouse", Price: 20, Tags: []string{"usb"}}, it)
+
+	var v map[string]any
+	require.NoError(t, Decode(newRequest("application/merge-patch+json", `{"a":1} `), &v, DefaultDecodeOptions()))
+	assert.Equal(t, map[string]any{"a": 1.0}, v)
+
+	opts := DefaultDecodeOptions()
+	opts.DisallowUnknownFields = false
+	require.NoError(t, Decode(newRequest("application/json", `{"name":"mouse","color":"red"}`), &it, opts))
+
+	// a zero MaxSize takes the default instead of rejecting every body
+	require.NoError(t, Decode(newRequest("application/json", `{"name":"mouse"}`), &it, DecodeOptions{}))
+}
+
+func TestDecode_StreamedBody(t *testing.T) {
+	body := `{"name":"mouse","price":20}`
+	raw := "POST /items HTTP/1.1\r\nContent-Type: application/json\r\nContent-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body
+	req, err := request.HeadFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+
+	var it item
+	require.NoError(t, Decode(req, &it, DefaultDecodeOptions()))
+	assert.Equal(t, item{Name: "mouse", Price: 20}, it)
+}
+
+func TestDecode_Errors(t *testing.T) {
+	testCases := []struct {
+		name           string
+		contentType    string
+		body           string
+		maxSize        int64
+		expectedStatus int
+		expectedFields []FieldError
+	}{
+		{name: "Missing content type", body: `{}`, expectedStatus: http.StatusUnsupportedMediaType},
+		{name: "Form", contentType: "application/x-www-form-urlencoded", body: `a=1`, expectedStatus: http.StatusUnsupportedMediaType},
+		{name: "Too large", contentType: "application/json", body: `{"name":"keyboard"}`, maxSize: 10, expectedStatus: http.StatusRequestEntityTooLarge},
+		{name: "Too large after the value", contentType: "application/json", body: `{"name":"a"}` + strings.Repeat(" ", 100), maxSize: 20, expectedStatus: http.StatusRequestEntityTooLarge},
+		{name: "Empty", contentType: "application/json", body: ``, expectedStatus: http.StatusBadRequest},
+		{name: "Syntax", contentType: "application/json", body: `{"name":}`, expectedStatus: http.StatusBadRequest},
+		{name: "Truncated", contentType: "application/json", body: `{"name":"mouse"`, expectedStatus: http.StatusBadRequest},
+		{name: "Two values", contentType: "application/json", body: `{"name":"a"}{"name":"b"}`, expectedStatus: http.StatusBadRequest},
+		{name: "Trailing garbage", contentType: "application/json", body: `{"name":"a"} x`, expectedStatus: http.StatusBadRequest},
+		{
+			name:           "Unknown field",
+			contentType:    "application/json",
+			body:           `{"name":"mouse","color":"red"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedFields: []FieldError{{Field: "color", Message: "unknown field"}},
+		},
+		{
+			name:           "Wrong type",
+			contentType:    "application/json",
+			body:           `{"name":"mouse","price":"cheap"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedFields: []FieldError{{Field: "price", Message: "must be a number"}},
+		},
+		{
+			name:           "Validation",
+			contentType:    "application/json",
+			body:           `{"price":-1}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedFields: []FieldError{{Field: "name", Message: "is required"}, {Field: "price", Message: "must not be negative"}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := DefaultDecodeOptions()
+			if tc.maxSize > 0 {
+				opts.MaxSize = tc.maxSize
+			}
+			var it item
+			err := Decode(newRequest(tc.contentType, tc.body), &it, opts)
+			require.Error(t, err)
+
+			var se httperrors.StatusError
+			require.True(t, errors.As(err, &se))
+			assert.Equal(t, tc.expectedStatus, se.Code)
+
+			var ve *ValidationError
+			if tc.expectedFields == nil {
+				assert.False(t, errors.As(err, &ve))
+				return
+			}
+			require.True(t, errors.As(err, &ve))
+			assert.Equal(t, tc.expectedFields, ve.Errors)
+		})
+	}
+}
+
+func TestWrite(t *testing.T) {
+	w := response.New()
+	require.NoError(t, Write(w, http.StatusCreated, item{Name: "mouse", Price: 20}))
+	// Content-Length comes from the writer's framing
+	require.NoError(t, w.Finish())
+
+	assert.Equal(t, http.StatusCreated, w.StatusCode())
+	h := w.Header()
+	assert.Equal(t, "application/json", h.Get("content-type"))
+	body := `{"name":"mouse","price":20,"tags":null}`
+	assert.Equal(t, strconv.Itoa(len(body)), h.Get("content-length"))
+	assert.Equal(t, body, string(w.Body()))
+
+	w = response.New()
+	assert.Error(t, Write(w, http.StatusOK, math.NaN()))
+	assert.Equal(t, 0, w.StatusCode())
+}
+
+func TestError(t *testing.T) {
+	testCases := []struct {
+		name           string
+		err            error
+		expectedStatus int
+		expected       map[string]any
+	}{
+		{
+			name:           "Status error",
+			err:            httperrors.Newf(http.StatusConflict, "item already exists"),
+			expectedStatus: http.StatusConflict,
+			expected: map[string]any{
+				"title": "Conflict", "status": 409.0, "detail": "item already exists",
+				"instance": "/items", "request_id": "req-1",
+			},
+		},
+		{
+			name: "Validation error",
+			err: func() error {
+				var it item
+				return Decode(newRequest("application/json", `{"price":-1}`), &it, DefaultDecodeOptions())
+			}(),
+			expectedStatus: http.StatusUnprocessableEntity,
+			expected: map[string]any{
+				"title": "Unprocessable Entity", "status": 422.0,
+				"detail":   "name: is required; price: must not be negative",
+				"instance": "/items", "request_id": "req-1",
+				"errors": []any{
+					map[string]any{"field": "name", "message": "is required"},
+					map[string]any{"field": "price", "message": "must not be negative"},
+				},
+			},
+		},
+		{
+			name:           "Other error",
+			err:            errors.New("database is on fire"),
+			expectedStatus: http.StatusInternalServerError,
+			expected: map[string]any{
+				"title": "Internal Server Error", "status": 500.0,
+				"instance": "/items", "request_id": "req-1",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := response.New()
+			Error(w, newRequest("application/json", ""), tc.err)
+			require.NoError(t, w.Finish())
+
+			assert.Equal(t, tc.expectedStatus, w.StatusCode())
+			h := w.Header()
+			assert.Equal(t, "application/problem+json", h.Get("content-type"))
+			assert.Equal(t, strconv.Itoa(len(w.Body())), h.Get("content-length"))
+			var got map[string]any
+			require.NoError(t, json.Unmarshal(w.Body(), &got))
+			assert.Equal(t, tc.expected, got)
+		})
+	}
+}
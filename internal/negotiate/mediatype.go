@@ -0,0 +1,92 @@
+package negotiate
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// MediaType is a media type or, in an Accept header, a media range like "text/*".
+type MediaType struct {
+	Type    string
+	Subtype string
+	// Params holds the lowercased parameter names and their values, e.g. charset.
+	Params map[string]string
+}
+
+// MediaRange is an element of an Accept header.
+type MediaRange struct {
+	MediaType
+	Q float64
+}
+
+// ParseMediaType parses a media type like "text/html; charset=utf-8". Type, subtype and
+// parameter names are lowercased, and quoted parameter values may hold "," and ";".
+func ParseMediaType(s string) (MediaType, error) {
+	fields := splitQuoted(s, ';')
+	e := element{value: strings.ToLower(strings.TrimSpace(fields[0]))}
+	for _, field := range fields[1:] {
+		e.params = append(e.params, parseParam(field))
+	}
+	return toMediaType(e)
+}
+
+// ParseAccept parses the media ranges of an Accept header in their original order.
+// Malformed ranges are skipped.
+func ParseAccept(accept string) []MediaRange {
+	var ranges []MediaRange
+	for _, e := range parseList(accept) {
+		mt, err := toMediaType(e)
+		if err != nil || (mt.Type == "*" && mt.Subtype != "*") {
+			continue
+		}
+		ranges = append(ranges, MediaRange{MediaType: mt, Q: e.q})
+	}
+	return ranges
+}
+
+func toMediaType(e element) (MediaType, error) {
+	typ, subtype, ok := strings.Cut(e.value, "/")
+	if !ok || typ == "" || subtype == "" {
+		return MediaType{}, fmt.Errorf("invalid media type %q", e.value)
+	}
+	mt := MediaType{Type: strings.TrimSpace(typ), Subtype: strings.TrimSpace(subtype)}
+	if len(e.params) > 0 {
+		mt.Params = make(map[string]string, len(e.params))
+		for _, p := range e.params {
+			mt.Params[p.name] = p.value
+		}
+	}
+	return mt, nil
+}
+
+// String formats mt back into "type/subtype;name=value", with parameters sorted by name.
+func (mt MediaType) String() string {
+	s := mt.Type + "/" + mt.Subtype
+	for _, name := range slices.Sorted(maps.Keys(mt.Params)) {
+		s += ";" + name + "=" + mt.Params[name]
+	}
+	return s
+}
+
+// match reports whether r covers mt, and how specifically: 0 for "*/*", 1 for "type/*",
+// 2 for "type/subtype" and more for each parameter the range requires.
+func (r MediaRange) match(mt MediaType) (int, bool) {
+	switch {
+	case r.Type == "*":
+		return 0, true
+	case r.Type != mt.Type:
+		return 0, false
+	case r.Subtype == "*":
+		return 1, true
+	case r.Subtype != mt.Subtype:
+		return 0, false
+	}
+	for name, value := range r.Params {
+		if !strings.EqualFold(mt.Params[name], value) {
+			return 0, false
+		}
+	}
+	return 2 + len(r.Params), true
+}
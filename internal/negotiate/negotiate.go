@@ -0,0 +1,221 @@
+// Package negotiate implements proactive content negotiation (RFC 9110 section 12): it
+// picks the representation a client prefers from the ones a server offers, based on the
+// Accept, Accept-Language and Accept-Charset headers.
+package negotiate
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/abdo-355/http-from-tcp/internal/request"
+	"github.com/abdo-355/http-from-tcp/internal/response"
+)
+
+// ContentType picks the media type to answer req with and adds Accept to the response's
+// Vary header. It returns "" when the client accepts none of the offers, which handlers
+// usually answer with 406 Not Acceptable.
+func ContentType(w *response.Writer, req *request.Request, offers ...string) string {
+	w.AddHeader("vary", "Accept")
+	return BestContentType(req.Headers.Get("accept"), offers)
+}
+
+// Language picks the language tag to answer req with and adds Accept-Language to Vary.
+func Language(w *response.Writer, req *request.Request, offers ...string) string {
+	w.AddHeader("vary", "Accept-Language")
+	return BestLanguage(req.Headers.Get("accept-language"), offers)
+}
+
+// Charset picks the charset to answer req with and adds Accept-Charset to Vary.
+func Charset(w *response.Writer, req *request.Request, offers ...string) string {
+	w.AddHeader("vary", "Accept-Charset")
+	return BestCharset(req.Headers.Get("accept-charset"), offers)
+}
+
+// BestContentType returns the offer the Accept header rates highest, the earliest one
+// on ties. Each offer gets the q-value of the most specific media range matching it: a
+// range with parameters beats "type/subtype", which beats "type/*", which beats "*/*".
+// Without an Accept header, or with one holding no valid range, the first offer wins.
+func BestContentType(accept string, offers []string) string {
+	if strings.TrimSpace(accept) == "" {
+		return first(offers)
+	}
+	ranges := ParseAccept(accept)
+	if len(ranges) == 0 {
+		// a header holding only malformed ranges is treated like a missing one
+		return first(offers)
+	}
+	return best(offers, func(offer string) float64 {
+		mt, err := ParseMediaType(offer)
+		if err != nil {
+			return 0
+		}
+		q, specificity := 0.0, -1
+		for _, r := range ranges {
+			if s, ok := r.match(mt); ok && s > specificity {
+				q, specificity = r.Q, s
+			}
+		}
+		return q
+	})
+}
+
+// BestLanguage returns the language tag the Accept-Language header rates highest. A range
+// matches a tag equal to it or starting with it followed by "-" ("en" matches "en-GB"),
+// and the longest matching range sets the q-value (basic filtering, RFC 4647 section 3.3.1).
+func BestLanguage(acceptLanguage string, offers []string) string {
+	if strings.TrimSpace(acceptLanguage) == "" {
+		return first(offers)
+	}
+	ranges := parseList(acceptLanguage)
+	return best(offers, func(offer string) float64 {
+		tag := strings.ToLower(offer)
+		q, longest := 0.0, -1
+		for _, r := range ranges {
+			length := len(r.value)
+			switch {
+			case r.value == "*":
+				length = 0
+			case r.value == tag, strings.HasPrefix(tag, r.value+"-"):
+			default:
+				continue
+			}
+			if length > longest {
+				q, longest = r.q, length
+			}
+		}
+		return q
+	})
+}
+
+// BestCharset returns the charset the Accept-Charset header rates highest. "*" stands
+// for every charset not listed explicitly.
+func BestCharset(acceptCharset string, offers []string) string {
+	if strings.TrimSpace(acceptCharset) == "" {
+		return first(offers)
+	}
+	ranges := parseList(acceptCharset)
+	return best(offers, func(offer string) float64 {
+		charset := strings.ToLower(offer)
+		q := 0.0
+		for _, r := range ranges {
+			if r.value == charset {
+				return r.q
+			}
+			if r.value == "*" {
+				q = r.q
+			}
+		}
+		return q
+	})
+}
+
+// best returns the first offer with the highest quality, or "" when all of them are
+// rated 0, that is not acceptable.
+func best(offers []string, quality func(offer string) float64) string {
+	var chosen string
+	bestQ := 0.0
+	for _, offer := range offers {
+		if q := quality(offer); q > bestQ {
+			chosen, bestQ = offer, q
+		}
+	}
+	return chosen
+}
+
+func first(offers []string) string {
+	if len(offers) == 0 {
+		return ""
+	}
+	return offers[0]
+}
+
+// element is an entry of a comma separated header list with its q-value.
+type element struct {
+	value  string
+	params []param
+	q      float64
+}
+
+type param struct {
+	name, value string
+}
+
+// parseList splits a header like "en-GB, en;q=0.8" into lowercased elements. Parameters
+// before the q-value are kept, the ones after it are extensions and dropped. Elements
+// with an invalid q-value are skipped.
+func parseList(value string) []element {
+	var elements []element
+	for _, part := range splitQuoted(value, ',') {
+		fields := splitQuoted(part, ';')
+		e := element{value: strings.ToLower(strings.TrimSpace(fields[0])), q: 1}
+		if e.value == "" {
+			continue
+		}
+		valid := true
+		for _, field := range fields[1:] {
+			p := parseParam(field)
+			if p.name == "q" {
+				q, ok := parseQ(p.value)
+				e.q, valid = q, ok
+				break
+			}
+			e.params = append(e.params, p)
+		}
+		if valid {
+			elements = append(elements, e)
+		}
+	}
+	return elements
+}
+
+// parseParam parses a "name=value" parameter, lowercasing the name and unquoting the value.
+func parseParam(field string) param {
+	name, v, _ := strings.Cut(strings.TrimSpace(field), "=")
+	return param{name: strings.ToLower(strings.TrimSpace(name)), value: unquote(strings.TrimSpace(v))}
+}
+
+// splitQuoted splits s at every sep outside a quoted string (RFC 9110 section 5.6.4).
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	start, quoted := 0, false
+	for i := 0; i < len(s); i++ {
+		switch {
+		case quoted && s[i] == '\\':
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case !quoted && s[i] == sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// unquote removes the quotes and backslash escapes of a quoted string. Other values are
+// returned as is.
+func unquote(s string) string {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return s
+	}
+	var b strings.Builder
+	for i := 1; i < len(s)-1; i++ {
+		if s[i] == '\\' && i+1 < len(s)-1 {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// parseQ parses a qvalue: 0 or 1 with up to three decimals (RFC 9110 section 12.4.2).
+func parseQ(s string) (float64, bool) {
+	if s == "" || len(s) > 5 || (s[0] != '0' && s[0] != '1') {
+		return 0, false
+	}
+	q, err := strconv.ParseFloat(s, 64)
+	if err != nil || q > 1 {
+		return 0, false
+	}
+	return q, true
+}
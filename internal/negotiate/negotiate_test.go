@@ -0,0 +1,142 @@
+package negotiate
+
+import (
+	"testing"
+
+	"github.com/abdo-355/http-from-tcp/internal/headers"
+	"github.com/abdo-355/http-from-tcp/internal/request"
+	"github.com/abdo-355/http-from-tcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBestContentType(t *testing.T) {
+	offers := []string{"text/html", "application/json", "text/csv"}
+
+	testCases := []struct {
+		name   string
+		accept string
+		offers []string
+		want   string
+	}{
+		{name: "No header", accept: "", want: "text/html"},
+		{name: "Anything", accept: "*/*", want: "text/html"},
+		{name: "Exact", accept: "application/json", want: "application/json"},
+		{name: "Case insensitive", accept: "Text/CSV", want: "text/csv"},
+		{name: "Quality", accept: "text/html;q=0.5, application/json;q=0.9", want: "application/json"},
+		{name: "Specific range wins over wildcard", accept: "text/*;q=0.9, text/html;q=0.1", want: "text/csv"},
+		{name: "Excluded by q=0", accept: "text/html;q=0, */*;q=0.5", want: "application/json"},
+		{name: "Browser", accept: "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", want: "text/html"},
+		{name: "Ties keep server order", accept: "text/csv, application/json", want: "application/json"},
+		{name: "None acceptable", accept: "image/png", want: ""},
+		{name: "Only malformed ranges", accept: "invalid, */html", want: "text/html"},
+		{name: "Quoted comma", accept: `text/csv;q=0.1;ext="a, text/html"`, want: "text/csv"},
+		{name: "Invalid q skipped", accept: "application/json;q=2, text/csv", want: "text/csv"},
+		{name: "Accept extension after q", accept: "text/csv;q=0.5;ext=1, text/html;q=0.4", want: "text/csv"},
+		{
+			name:   "Parameters",
+			accept: "text/html;level=1, text/html;q=0.2, text/plain;q=0.5",
+			offers: []string{"text/html", "text/plain", "text/html;level=1"},
+			want:   "text/html;level=1",
+		},
+		{
+			name:   "Range parameters must match",
+			accept: "text/html;level=2",
+			offers: []string{"text/html;level=1", "text/html"},
+			want:   "",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			o := tc.offers
+			if o == nil {
+				o = offers
+			}
+			assert.Equal(t, tc.want, BestContentType(tc.accept, o))
+		})
+	}
+}
+
+func TestBestLanguage(t *testing.T) {
+	offers := []string{"en-US", "en-GB", "fr", "de-CH"}
+
+	testCases := []struct {
+		name   string
+		accept string
+		want   string
+	}{
+		{name: "No header", accept: "", want: "en-US"},
+		{name: "Exact", accept: "en-GB", want: "en-GB"},
+		{name: "Prefix", accept: "de", want: "de-CH"},
+		{name: "Prefix only at subtag boundary", accept: "e", want: ""},
+		{name: "Quality", accept: "da, en;q=0.7, fr;q=0.8", want: "fr"},
+		{name: "Longest range wins", accept: "en;q=0.5, en-gb;q=0.9", want: "en-GB"},
+		{name: "Wildcard", accept: "fr;q=0.1, *;q=0.5", want: "en-US"},
+		{name: "Excluded", accept: "*, en-us;q=0", want: "en-GB"},
+		{name: "None acceptable", accept: "ja", want: ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, BestLanguage(tc.accept, offers))
+		})
+	}
+}
+
+func TestBestCharset(t *testing.T) {
+	offers := []string{"utf-8", "iso-8859-1"}
+
+	testCases := []struct {
+		name   string
+		accept string
+		want   string
+	}{
+		{name: "No header", accept: "", want: "utf-8"},
+		{name: "Exact", accept: "ISO-8859-1", want: "iso-8859-1"},
+		{name: "Wildcard", accept: "iso-8859-5, *;q=0.5", want: "utf-8"},
+		{name: "Wildcard does not override an explicit q", accept: "utf-8;q=0, *", want: "iso-8859-1"},
+		{name: "None acceptable", accept: "iso-8859-5", want: ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, BestCharset(tc.accept, offers))
+		})
+	}
+}
+
+func TestParseAccept(t *testing.T) {
+	ranges := ParseAccept(`text/html; Level="1"; q=0.7, */*;q=0.1, */html, invalid, application/json`)
+	require.Len(t, ranges, 3)
+	assert.Equal(t, MediaType{Type: "text", Subtype: "html", Params: map[string]string{"level": "1"}}, ranges[0].MediaType)
+	assert.Equal(t, 0.7, ranges[0].Q)
+	assert.Equal(t, "*/*", ranges[1].String())
+	assert.Equal(t, 1.0, ranges[2].Q)
+
+	mt, err := ParseMediaType("Text/HTML; charset=UTF-8; level=1")
+	require.NoError(t, err)
+	assert.Equal(t, "text/html;charset=UTF-8;level=1", mt.String())
+	_, err = ParseMediaType("text")
+	assert.Error(t, err)
+
+	mt, err = ParseMediaType(`multipart/form-data; boundary="a,b;c\"d"`)
+	require.NoError(t, err)
+	assert.Equal(t, `a,b;c"d`, mt.Params["boundary"])
+}
+
+func TestContentType_SetsVary(t *testing.T) {
+	req := &request.Request{Headers: headers.NewHeaders()}
+	req.Headers.Set("accept", "application/json")
+	req.Headers.Set("accept-language", "fr")
+
+	w := response.New()
+	assert.Equal(t, "application/json", ContentType(w, req, "text/html", "application/json"))
+	assert.Equal(t, "fr", Language(w, req, "en", "fr"))
+	assert.Equal(t, "utf-8", Charset(w, req, "utf-8"))
+
+	w.WriteStatusLine("HTTP/1.1", 200, "OK")
+	w.WriteHeaders(headers.NewHeaders())
+	h := w.Header()
+	assert.Equal(t, "Accept, Accept-Language, Accept-Charset", h.Get("vary"))
+}
@@ -0,0 +1,256 @@
+package proxy
+
+import (
+	"cmp"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/abdo-355/http-from-tcp/internal/client"
+	"github.com/abdo-355/http-from-tcp/internal/request"
+)
+
+// Strategy selects how a Pool distributes requests between its upstreams.
+type Strategy int
+
+const (
+	RoundRobin Strategy = iota
+	LeastConnections
+	ConsistentHash
+)
+
+// virtualNodes is the number of points each upstream gets on the consistent hash ring.
+const virtualNodes = 100
+
+// Upstream is a single backend in a Pool.
+type Upstream struct {
+	URL *url.URL
+
+	healthy      atomic.Bool
+	active       atomic.Int64
+	failures     atomic.Int32
+	ejectedUntil atomic.Int64
+}
+
+// Available reports whether the upstream passed its last health check and is not ejected.
+func (u *Upstream) Available() bool {
+	return u.healthy.Load() && time.Now().UnixNano() >= u.ejectedUntil.Load()
+}
+
+// ActiveConnections returns the number of requests currently in flight to the upstream.
+func (u *Upstream) ActiveConnections() int64 {
+	return u.active.Load()
+}
+
+// PoolOptions configures upstream selection and ejection.
+type PoolOptions struct {
+	Strategy Strategy
+	// HashHeader is the request header used as the ConsistentHash key. The client IP
+	// is used when it is empty or the header is missing.
+	HashHeader string
+	// MaxFails is the number of consecutive failures after which an upstream is ejected.
+	// The last available upstream is never ejected, since a failing upstream still
+	// serves some requests where an empty pool serves none.
+	MaxFails int
+	// EjectDuration is how long an ejected upstream is kept out of rotation.
+	EjectDuration time.Duration
+}
+
+// Pool is a set of upstreams requests are balanced across.
+type Pool struct {
+	upstreams []*Upstream
+	opts      PoolOptions
+	next      atomic.Uint64
+	ring      []ringNode
+	// ejectMu makes checking for other available upstreams and ejecting one atomic
+	ejectMu sync.Mutex
+}
+
+type ringNode struct {
+	hash     uint32
+	upstream *Upstream
+}
+
+// NewPool creates a pool from upstream base URLs. All upstreams start out healthy.
+func NewPool(urls []string, opts PoolOptions) (*Pool, error) {
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("pool needs at least one upstream")
+	}
+	if opts.MaxFails <= 0 {
+		opts.MaxFails = 3
+	}
+	if opts.EjectDuration <= 0 {
+		opts.EjectDuration = 30 * time.Second
+	}
+
+	p := &Pool{opts: opts}
+	for _, raw := range urls {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid upstream url: %w", err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return nil, fmt.Errorf("unsupported upstream scheme: %q", u.Scheme)
+		}
+		up := &Upstream{URL: u}
+		up.healthy.Store(true)
+		p.upstreams = append(p.upstreams, up)
+
+		// keyed by the whole URL, so upstreams on the same host but different paths or
+		// schemes get their own points
+		for i := range virtualNodes {
+			p.ring = append(p.ring, ringNode{hash: hashKey(u.String() + "#" + strconv.Itoa(i)), upstream: up})
+		}
+	}
+	slices.SortFunc(p.ring, func(a, b ringNode) int {
+		return cmp.Compare(a.hash, b.hash)
+	})
+
+	return p, nil
+}
+
+// Upstreams returns the upstreams in the pool.
+func (p *Pool) Upstreams() []*Upstream {
+	return p.upstreams
+}
+
+// Pick selects an available upstream for req, skipping the ones in tried.
+// It returns nil when no upstream can take the request.
+func (p *Pool) Pick(req *request.Request, tried []*Upstream) *Upstream {
+	usable := func(u *Upstream) bool {
+		return u.Available() && !slices.Contains(tried, u)
+	}
+
+	switch p.opts.Strategy {
+	case LeastConnections:
+		var best *Upstream
+		for _, u := range p.upstreams {
+			if usable(u) && (best == nil || u.active.Load() < best.active.Load()) {
+				best = u
+			}
+		}
+		return best
+
+	case ConsistentHash:
+		h := hashKey(p.hashKeyFor(req))
+		start, _ := slices.BinarySearchFunc(p.ring, h, func(n ringNode, h uint32) int {
+			return cmp.Compare(n.hash, h)
+		})
+		for i := range p.ring {
+			n := p.ring[(start+i)%len(p.ring)]
+			if usable(n.upstream) {
+				return n.upstream
+			}
+		}
+		return nil
+
+	default:
+		for range p.upstreams {
+			u := p.upstreams[(p.next.Add(1)-1)%uint64(len(p.upstreams))]
+			if usable(u) {
+				return u
+			}
+		}
+		return nil
+	}
+}
+
+func (p *Pool) hashKeyFor(req *request.Request) string {
+	if p.opts.HashHeader != "" {
+		if v := req.Headers.Get(p.opts.HashHeader); v != "" {
+			return v
+		}
+	}
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		return host
+	}
+	return req.RemoteAddr
+}
+
+// ReportSuccess resets the consecutive failure count of u.
+func (p *Pool) ReportSuccess(u *Upstream) {
+	u.failures.Store(0)
+}
+
+// ReportFailure records that req failed on u and ejects u after MaxFails consecutive
+// failures, unless no other upstream is available. An ejection is logged with req's logger.
+func (p *Pool) ReportFailure(req *request.Request, u *Upstream) {
+	if int(u.failures.Add(1)) < p.opts.MaxFails {
+		return
+	}
+
+	p.ejectMu.Lock()
+	defer p.ejectMu.Unlock()
+	others := slices.ContainsFunc(p.upstreams, func(o *Upstream) bool {
+		return o != u && o.Available()
+	})
+	if !others {
+		return
+	}
+	u.failures.Store(0)
+	u.ejectedUntil.Store(time.Now().Add(p.opts.EjectDuration).UnixNano())
+	req.Logger().Warn("ejecting upstream after consecutive failures", "upstream", u.URL.Host)
+}
+
+// StartHealthChecks probes path on every upstream each interval and updates its health.
+// The returned function stops the checks.
+func (p *Pool) StartHealthChecks(interval time.Duration, path string) (stop func()) {
+	c := &client.Client{Timeout: interval}
+	done := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				for _, u := range p.upstreams {
+					p.check(c, u, path)
+				}
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+func (p *Pool) check(c *client.Client, u *Upstream, path string) {
+	target := *u.URL
+	target.Path = strings.TrimSuffix(target.Path, "/") + path
+	res, err := c.Get(target.String())
+	healthy := err == nil && res.StatusCode < 500
+
+	if was := u.healthy.Swap(healthy); was != healthy {
+		// health checks belong to no request, so they log with the default logger
+		slog.Info("upstream health changed", "upstream", u.URL.Host, "healthy", healthy)
+	}
+	if healthy {
+		// a passing active check readmits a passively ejected upstream
+		u.ejectedUntil.Store(0)
+	}
+}
+
+func hashKey(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+
+	// FNV alone barely spreads short keys like "a#1" and "a#2", so finish with
+	// the murmur3 avalanche step to scatter them over the ring
+	x := h.Sum32()
+	x ^= x >> 16
+	x *= 0x85ebca6b
+	x ^= x >> 13
+	x *= 0xc2b2ae35
+	x ^= x >> 16
+	return x
+}
@@ -0,0 +1,215 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/abdo-355/http-from-tcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPool_RoundRobin(t *testing.T) {
+	p, err := NewPool([]string{"http://a", "http://b", "http://c"}, PoolOptions{})
+	require.NoError(t, err)
+
+	req := newRequest("GET", "/", nil, "")
+	var hosts []string
+	for range 6 {
+		hosts = append(hosts, p.Pick(req, nil).URL.Host)
+	}
+	assert.Equal(t, []string{"a", "b", "c", "a", "b", "c"}, hosts)
+
+	// tried upstreams are skipped
+	assert.Equal(t, "c", p.Pick(req, p.Upstreams()[:2]).URL.Host)
+	assert.Nil(t, p.Pick(req, p.Upstreams()))
+}
+
+func TestPool_LeastConnections(t *testing.T) {
+	p, err := NewPool([]string{"http://a", "http://b", "http://c"}, PoolOptions{Strategy: LeastConnections})
+	require.NoError(t, err)
+
+	ups := p.Upstreams()
+	ups[0].active.Store(4)
+	ups[1].active.Store(1)
+	ups[2].active.Store(2)
+
+	req := newRequest("GET", "/", nil, "")
+	assert.Equal(t, "b", p.Pick(req, nil).URL.Host)
+	assert.Equal(t, "c", p.Pick(req, []*Upstream{ups[1]}).URL.Host)
+}
+
+func TestPool_ConsistentHash(t *testing.T) {
+	p, err := NewPool([]string{"http://a", "http://b", "http://c"}, PoolOptions{Strategy: ConsistentHash, HashHeader: "x-user"})
+	require.NoError(t, err)
+
+	req := newRequest("GET", "/", map[string]string{"x-user": "alice"}, "")
+	first := p.Pick(req, nil)
+	for range 10 {
+		assert.Same(t, first, p.Pick(req, nil))
+	}
+
+	// ejecting the chosen upstream moves the key to another one, and back once readmitted
+	first.healthy.Store(false)
+	other := p.Pick(req, nil)
+	require.NotNil(t, other)
+	assert.NotSame(t, first, other)
+	first.healthy.Store(true)
+	assert.Same(t, first, p.Pick(req, nil))
+
+	// without the header the client IP is the key
+	byIP := newRequest("GET", "/", nil, "")
+	assert.Same(t, p.Pick(byIP, nil), p.Pick(byIP, nil))
+
+	seen := map[*Upstream]bool{}
+	for _, user := range []string{"u1", "u2", "u3", "u4", "u5", "u6", "u7", "u8", "u9", "u10"} {
+		seen[p.Pick(newRequest("GET", "/", map[string]string{"x-user": user}, ""), nil)] = true
+	}
+	assert.Greater(t, len(seen), 1)
+}
+
+func TestPool_PassiveEjection(t *testing.T) {
+	p, err := NewPool([]string{"http://a", "http://b"}, PoolOptions{MaxFails: 2, EjectDuration: time.Hour})
+	require.NoError(t, err)
+
+	var logs bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+
+	req := newRequest("GET", "/", nil, "")
+	req.ID = "abc"
+	a, b := p.Upstreams()[0], p.Upstreams()[1]
+	p.ReportFailure(req, a)
+	p.ReportSuccess(a)
+	p.ReportFailure(req, a)
+	assert.True(t, a.Available())
+
+	p.ReportFailure(req, a)
+	assert.False(t, a.Available())
+	assert.Contains(t, logs.String(), "request_id=abc")
+
+	// the last available upstream stays in rotation however often it fails
+	for range 5 {
+		p.ReportFailure(req, b)
+	}
+	assert.True(t, b.Available())
+	assert.Same(t, b, p.Pick(newRequest("GET", "/", nil, ""), nil))
+}
+
+func TestPool_RingKeyedByURL(t *testing.T) {
+	p, err := NewPool([]string{"http://a/v1", "http://a/v2"}, PoolOptions{Strategy: ConsistentHash, HashHeader: "x-user"})
+	require.NoError(t, err)
+
+	seen := map[*Upstream]bool{}
+	for i := range 50 {
+		seen[p.Pick(newRequest("GET", "/", map[string]string{"x-user": strconv.Itoa(i)}, ""), nil)] = true
+	}
+	assert.Len(t, seen, 2)
+}
+
+func TestPool_HealthChecks(t *testing.T) {
+	var healthy atomic.Bool
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" || !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer upstream.Close()
+
+	p, err := NewPool([]string{upstream.URL}, PoolOptions{})
+	require.NoError(t, err)
+	stop := p.StartHealthChecks(10*time.Millisecond, "/healthz")
+	defer stop()
+
+	u := p.Upstreams()[0]
+	assert.Eventually(t, func() bool { return !u.Available() }, time.Second, 5*time.Millisecond)
+	healthy.Store(true)
+	assert.Eventually(t, u.Available, time.Second, 5*time.Millisecond)
+}
+
+func TestReverseProxy_RetriesIdempotentRequests(t *testing.T) {
+	var brokenHits atomic.Int32
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		brokenHits.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer broken.Close()
+	working := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer working.Close()
+
+	p, err := NewBalanced([]string{broken.URL, working.URL}, "", PoolOptions{MaxFails: 10})
+	require.NoError(t, err)
+
+	w := response.New()
+	p.Handle(w, newRequest("GET", "/", nil, ""))
+	assert.Equal(t, http.StatusOK, w.StatusCode())
+	assert.Equal(t, "ok", string(w.Body()))
+	assert.Equal(t, int32(1), brokenHits.Load())
+
+	// non idempotent requests are not retried, the upstream answer is relayed
+	w = response.New()
+	p.Handle(w, newRequest("POST", "/", nil, "data"))
+	assert.Equal(t, http.StatusServiceUnavailable, w.StatusCode())
+	assert.Equal(t, int32(2), brokenHits.Load())
+
+	for _, u := range p.Pool.upstreams {
+		assert.Zero(t, u.ActiveConnections(), u.URL.Host)
+	}
+}
+
+func TestReverseProxy_ActiveUntilBodyRelayed(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "first ")
+		w.(http.Flusher).Flush()
+		<-release
+		io.WriteString(w, "second")
+	}))
+	defer upstream.Close()
+
+	p, err := New(upstream.URL, "")
+	require.NoError(t, err)
+	u := p.Pool.upstreams[0]
+
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w := response.NewWriter(pw)
+		p.Handle(w, newRequest("GET", "/", nil, ""))
+		w.Finish()
+		pw.Close()
+	}()
+
+	// the response head is in, but the body is still being relayed
+	_, err = bufio.NewReader(pr).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), u.ActiveConnections())
+
+	close(release)
+	io.Copy(io.Discard, pr)
+	<-done
+	assert.Zero(t, u.ActiveConnections())
+}
+
+func TestReverseProxy_NoHealthyUpstream(t *testing.T) {
+	p, err := NewBalanced([]string{"http://a", "http://b"}, "", PoolOptions{})
+	require.NoError(t, err)
+	for _, u := range p.Pool.Upstreams() {
+		u.healthy.Store(false)
+	}
+
+	w := response.New()
+	p.Handle(w, newRequest("GET", "/", nil, ""))
+	assert.Equal(t, http.StatusServiceUnavailable, w.StatusCode())
+}
@@ -0,0 +1,321 @@
+package proxy
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/abdo-355/http-from-tcp/internal/client"
+	"github.com/abdo-355/http-from-tcp/internal/errorpage"
+	"github.com/abdo-355/http-from-tcp/internal/request"
+	"github.com/abdo-355/http-from-tcp/internal/response"
+	"github.com/abdo-355/http-from-tcp/internal/tracing"
+)
+
+const defaultDialTimeout = 10 * time.Second
+
+var (
+	errNotAllowed = errors.New("destination is not allowed")
+	errProxyAuth  = errors.New("proxy authentication required")
+)
+
+// ForwardProxy is an HTTP forward proxy. It relays requests sent in absolute form
+// ("GET http://host/path") and opens TCP tunnels for CONNECT requests.
+type ForwardProxy struct {
+	// Allow lists the destinations clients may reach as "host:port" patterns. The host
+	// may be "*" or start with "*." to match subdomains, and the port may be "*".
+	// An empty list allows every destination.
+	Allow []string
+	// Credentials maps user names to passwords for Proxy-Authorization basic auth.
+	// A nil map lets every client through.
+	Credentials map[string]string
+	// Realm is sent in the Proxy-Authenticate challenge.
+	Realm string
+	// Client sends the relayed absolute-form requests.
+	Client *client.Client
+	// DialTimeout bounds connecting to a CONNECT destination.
+	DialTimeout time.Duration
+}
+
+// NewForward creates a ForwardProxy restricted to the allow patterns.
+func NewForward(allow []string) *ForwardProxy {
+	return &ForwardProxy{
+		Allow:       allow,
+		Realm:       "proxy",
+		Client:      &client.Client{Timeout: defaultTimeout, Pool: client.NewPool()},
+		DialTimeout: defaultDialTimeout,
+	}
+}
+
+// IsForwardRequest reports whether req is meant for a forward proxy rather than the
+// server itself: a CONNECT request or a request in absolute form.
+func IsForwardRequest(req *request.Request) bool {
+	return req.RequestLine.Method == "CONNECT" || strings.HasPrefix(req.RequestLine.RequestTarget, "http://")
+}
+
+// Handle relays req to its destination, or tunnels it for CONNECT.
+func (p *ForwardProxy) Handle(w *response.Writer, req *request.Request) {
+	if !p.authorized(req) {
+		w.SetHeader("proxy-authenticate", fmt.Sprintf("Basic realm=%q", p.Realm))
+		errorpage.Error(w, req, http.StatusProxyAuthRequired, errProxyAuth)
+		return
+	}
+
+	if req.RequestLine.Method == "CONNECT" {
+		p.tunnel(w, req)
+		return
+	}
+	p.forward(w, req)
+}
+
+func (p *ForwardProxy) forward(w *response.Writer, req *request.Request) {
+	u, err := url.Parse(req.RequestLine.RequestTarget)
+	if err != nil || u.Scheme != "http" || u.Host == "" {
+		errorpage.Error(w, req, http.StatusBadRequest, fmt.Errorf("invalid absolute-form target %q", req.RequestLine.RequestTarget))
+		return
+	}
+	port := u.Port()
+	if port == "" {
+		port = "80"
+	}
+	if !p.allowed(u.Hostname(), port) {
+		errorpage.Error(w, req, http.StatusForbidden, fmt.Errorf("destination %s is not allowed", u.Host))
+		return
+	}
+	addr, err := p.resolve(req.Context(), u.Hostname(), port)
+	if err != nil {
+		p.resolveError(w, req, u.Host, err)
+		return
+	}
+	if err := req.ReadBody(); err != nil {
+		proxyError(w, req, http.StatusBadRequest, err)
+		return
+	}
+
+	outReq, err := client.NewRequest(req.RequestLine.Method, u.String(), req.Body)
+	if err != nil {
+		proxyError(w, req, http.StatusBadRequest, err)
+		return
+	}
+	outReq.Addr = addr
+	outReq.Headers = req.Headers.Clone()
+	removeHopHeaders(outReq.Headers)
+	outReq.Headers.Del("content-length")
+	outReq.Headers.Del("expect")
+	setRequestID(outReq.Headers, req)
+	tracing.Inject(req.Context(), outReq.Headers)
+
+	res, upstreamBody, err := p.Client.DoStream(req.Context(), outReq)
+	switch {
+	case errors.Is(err, context.Canceled):
+		return
+	case errors.Is(err, context.DeadlineExceeded):
+		proxyError(w, req, http.StatusGatewayTimeout, err)
+		return
+	case err != nil:
+		proxyError(w, req, http.StatusBadGateway, err)
+		return
+	}
+
+	defer upstreamBody.Close()
+
+	h := res.Headers.Clone()
+	removeHopHeaders(h)
+	w.WriteStatusLine("HTTP/1.1", res.StatusCode, res.Reason)
+	if req.IsHead() {
+		w.WriteHeaders(h)
+		return
+	}
+	body := w.BodyWriter(h)
+	_, err = copyBody(w, body, upstreamBody)
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	if err != nil {
+		// closing body would end it like a complete one
+		req.Logger().Error("error relaying upstream response", "err", err)
+		w.Abort()
+		return
+	}
+	if err := body.Close(); err != nil {
+		req.Logger().Error("error finishing response", "err", err)
+	}
+}
+
+// tunnel connects to the CONNECT target, takes the client connection over and copies
+// bytes both ways until either side closes.
+func (p *ForwardProxy) tunnel(w *response.Writer, req *request.Request) {
+	target := req.RequestLine.RequestTarget
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		errorpage.Error(w, req, http.StatusBadRequest, fmt.Errorf("CONNECT target must be host:port: %w", err))
+		return
+	}
+	if !p.allowed(host, port) {
+		errorpage.Error(w, req, http.StatusForbidden, fmt.Errorf("destination %s is not allowed", target))
+		return
+	}
+
+	addr, err := p.resolve(req.Context(), host, port)
+	if err != nil {
+		p.resolveError(w, req, target, err)
+		return
+	}
+
+	dialer := &net.Dialer{Timeout: p.DialTimeout}
+	upstream, err := dialer.DialContext(req.Context(), "tcp", addr)
+	if err != nil {
+		proxyError(w, req, http.StatusBadGateway, err)
+		return
+	}
+
+	conn, rw, err := w.Hijack()
+	if err != nil {
+		upstream.Close()
+		proxyError(w, req, http.StatusInternalServerError, err)
+		return
+	}
+	defer conn.Close()
+	defer upstream.Close()
+
+	rw.WriteString("HTTP/1.1 200 Connection Established\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		return
+	}
+
+	var once sync.Once
+	closeBoth := func() {
+		once.Do(func() {
+			conn.Close()
+			upstream.Close()
+		})
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		defer closeBoth()
+		// rw.Reader holds anything the client sent right after the CONNECT request
+		copyTunnel(req, upstream, rw.Reader)
+	}()
+	go func() {
+		defer wg.Done()
+		defer closeBoth()
+		copyTunnel(req, conn, upstream)
+	}()
+	wg.Wait()
+}
+
+func copyTunnel(req *request.Request, dst io.Writer, src io.Reader) {
+	if _, err := io.Copy(dst, src); err != nil && !errors.Is(err, net.ErrClosed) {
+		req.Logger().Debug("tunnel closed", "err", err)
+	}
+}
+
+func (p *ForwardProxy) authorized(req *request.Request) bool {
+	if p.Credentials == nil {
+		return true
+	}
+
+	scheme, encoded, ok := strings.Cut(req.Headers.Get("proxy-authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "basic") {
+		return false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return false
+	}
+	user, password, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return false
+	}
+	want, known := p.Credentials[user]
+	return known && subtle.ConstantTimeCompare([]byte(password), []byte(want)) == 1
+}
+
+func (p *ForwardProxy) allowed(host, port string) bool {
+	if len(p.Allow) == 0 {
+		return true
+	}
+	host = strings.ToLower(strings.Trim(host, "[]"))
+	for _, pattern := range p.Allow {
+		patternHost, patternPort, err := net.SplitHostPort(pattern)
+		if err != nil {
+			continue
+		}
+		if patternPort != "*" && patternPort != port {
+			continue
+		}
+		if matchHost(strings.ToLower(patternHost), host) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve looks host up and returns the first of its addresses that may be dialed, as
+// "ip:port". The allow list matches names, which could resolve to anything, so addresses
+// inside the network (loopback, private, link-local) are only accepted when a pattern
+// names them literally. Dialing the checked address keeps a second lookup from
+// answering differently.
+func (p *ForwardProxy) resolve(ctx context.Context, host, port string) (string, error) {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, strings.Trim(host, "[]"))
+	if err != nil {
+		return "", err
+	}
+	for _, a := range addrs {
+		if p.allowedIP(a.IP, port) {
+			return net.JoinHostPort(a.String(), port), nil
+		}
+	}
+	return "", errNotAllowed
+}
+
+func (p *ForwardProxy) resolveError(w *response.Writer, req *request.Request, target string, err error) {
+	if errors.Is(err, errNotAllowed) {
+		errorpage.Error(w, req, http.StatusForbidden, fmt.Errorf("destination %s is not allowed", target))
+		return
+	}
+	proxyError(w, req, http.StatusBadGateway, err)
+}
+
+func (p *ForwardProxy) allowedIP(ip net.IP, port string) bool {
+	if len(p.Allow) == 0 || !internalIP(ip) {
+		return true
+	}
+	for _, pattern := range p.Allow {
+		patternHost, patternPort, err := net.SplitHostPort(pattern)
+		if err != nil || (patternPort != "*" && patternPort != port) {
+			continue
+		}
+		if ip.Equal(net.ParseIP(patternHost)) {
+			return true
+		}
+	}
+	return false
+}
+
+func internalIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast()
+}
+
+func matchHost(pattern, host string) bool {
+	switch {
+	case pattern == "*":
+		return true
+	case strings.HasPrefix(pattern, "*."):
+		return strings.HasSuffix(host, pattern[1:])
+	}
+	return pattern == host
+}
@@ -0,0 +1,236 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/abdo-355/http-from-tcp/internal/response"
+	"github.com/abdo-355/http-from-tcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// echoListener accepts connections and writes back whatever it reads.
+func echoListener(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func startForwardProxy(t *testing.T, p *ForwardProxy) string {
+	t.Helper()
+	srv, err := server.Serve(0, p.Handle)
+	require.NoError(t, err)
+	t.Cleanup(func() { srv.Close() })
+	return "127.0.0.1:" + strconv.Itoa(srv.Listener.Addr().(*net.TCPAddr).Port)
+}
+
+func basicAuth(user, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+}
+
+func TestForwardProxy_Connect(t *testing.T) {
+	target := echoListener(t)
+	proxyAddr := startForwardProxy(t, NewForward([]string{"127.0.0.1:*"}))
+
+	conn, err := net.Dial("tcp", proxyAddr)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// the first tunnel bytes are sent along with the CONNECT request
+	_, err = io.WriteString(conn, "CONNECT "+target+" HTTP/1.1\r\nHost: "+target+"\r\n\r\nhello ")
+	require.NoError(t, err)
+
+	br := bufio.NewReader(conn)
+	res, err := response.FromReaderForMethod(br, "CONNECT")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	_, err = io.WriteString(conn, "tunnel\n")
+	require.NoError(t, err)
+	line, err := br.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "hello tunnel\n", line)
+}
+
+func TestForwardProxy_ConnectRejected(t *testing.T) {
+	target := echoListener(t)
+	_, port, _ := net.SplitHostPort(target)
+
+	p := NewForward([]string{"example.com:443"})
+	p.Credentials = map[string]string{"alice": "secret"}
+	proxyAddr := startForwardProxy(t, p)
+
+	testCases := []struct {
+		name   string
+		target string
+		auth   string
+		status int
+	}{
+		{name: "Missing credentials", target: target, status: http.StatusProxyAuthRequired},
+		{name: "Wrong password", target: target, auth: basicAuth("alice", "guess"), status: http.StatusProxyAuthRequired},
+		{name: "Destination not allowed", target: target, auth: basicAuth("alice", "secret"), status: http.StatusForbidden},
+		{name: "Malformed target", target: "localhost", auth: basicAuth("alice", "secret"), status: http.StatusBadRequest},
+		{name: "Unknown port", target: "example.com:" + port, auth: basicAuth("alice", "secret"), status: http.StatusForbidden},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", proxyAddr)
+			require.NoError(t, err)
+			defer conn.Close()
+
+			raw := "CONNECT " + tc.target + " HTTP/1.1\r\nHost: " + tc.target + "\r\n"
+			if tc.auth != "" {
+				raw += "Proxy-Authorization: " + tc.auth + "\r\n"
+			}
+			_, err = io.WriteString(conn, raw+"\r\n")
+			require.NoError(t, err)
+
+			res, err := response.FromReader(conn)
+			require.NoError(t, err)
+			assert.Equal(t, tc.status, res.StatusCode)
+			if tc.status == http.StatusProxyAuthRequired {
+				assert.Equal(t, `Basic realm="proxy"`, res.Headers.Get("proxy-authenticate"))
+				assert.Equal(t, "407 Proxy Authentication Required: proxy authentication required", string(res.Body))
+			}
+		})
+	}
+}
+
+func TestForwardProxy_AbsoluteForm(t *testing.T) {
+	var got *http.Request
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		w.Header().Set("X-Upstream", "yes")
+		io.WriteString(w, "from upstream")
+	}))
+	defer upstream.Close()
+
+	p := NewForward(nil)
+	p.Credentials = map[string]string{"alice": "secret"}
+
+	req := newRequest("GET", upstream.URL+"/page?q=1", map[string]string{
+		"host":                upstream.Listener.Addr().String(),
+		"proxy-authorization": basicAuth("alice", "secret"),
+		"proxy-connection":    "keep-alive",
+		"accept":              "text/plain",
+	}, "")
+	w := response.New()
+	p.Handle(w, req)
+
+	require.NotNil(t, got)
+	assert.Equal(t, "/page", got.URL.Path)
+	assert.Equal(t, "q=1", got.URL.RawQuery)
+	assert.Equal(t, "text/plain", got.Header.Get("Accept"))
+	assert.Empty(t, got.Header.Get("Proxy-Authorization"))
+	assert.Empty(t, got.Header.Get("Proxy-Connection"))
+
+	assert.Equal(t, http.StatusOK, w.StatusCode())
+	h := w.Header()
+	assert.Equal(t, "yes", h.Get("x-upstream"))
+	assert.Equal(t, "from upstream", string(w.Body()))
+}
+
+func TestForwardProxy_UpstreamDiesMidBody(t *testing.T) {
+	p := NewForward(nil)
+	w, got := handleOnConn(p.Handle, newRequest("GET", "http://"+truncatingUpstream(t)+"/", nil, ""))
+
+	assert.Contains(t, got, "partial")
+	assert.NotContains(t, got, "0\r\n\r\n")
+	assert.True(t, w.Aborted())
+}
+
+func TestForwardProxy_AbsoluteFormErrors(t *testing.T) {
+	p := NewForward([]string{"allowed.test:80", "localhost:80"})
+
+	testCases := []struct {
+		name   string
+		target string
+		status int
+		body   string
+	}{
+		{name: "Destination not allowed", target: "http://other.test/", status: http.StatusForbidden},
+		{name: "Wrong port", target: "http://allowed.test:8080/", status: http.StatusForbidden},
+		{
+			name:   "Unreachable upstream",
+			target: "http://allowed.test/",
+			status: http.StatusBadGateway,
+			body:   "502 Bad Gateway: the upstream could not be reached",
+		},
+		{name: "Name resolving to loopback", target: "http://localhost/", status: http.StatusForbidden},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := response.New()
+			p.Handle(w, newRequest("GET", tc.target, nil, ""))
+			assert.Equal(t, tc.status, w.StatusCode())
+			if tc.body != "" {
+				assert.Equal(t, tc.body, string(w.Body()))
+			}
+		})
+	}
+}
+
+func TestForwardProxy_Allowed(t *testing.T) {
+	p := &ForwardProxy{Allow: []string{"example.com:443", "*.internal.test:*", "[::1]:8080"}}
+
+	testCases := []struct {
+		host, port string
+		expected   bool
+	}{
+		{host: "example.com", port: "443", expected: true},
+		{host: "EXAMPLE.com", port: "443", expected: true},
+		{host: "example.com", port: "80", expected: false},
+		{host: "api.internal.test", port: "9000", expected: true},
+		{host: "internal.test", port: "9000", expected: false},
+		{host: "::1", port: "8080", expected: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.host+":"+tc.port, func(t *testing.T) {
+			assert.Equal(t, tc.expected, p.allowed(tc.host, tc.port))
+		})
+	}
+	assert.True(t, IsForwardRequest(newRequest("CONNECT", "example.com:443", nil, "")))
+
+	ipCases := []struct {
+		ip       string
+		expected bool
+	}{
+		{ip: "93.184.216.34", expected: true},
+		{ip: "127.0.0.1", expected: false},
+		{ip: "10.1.2.3", expected: false},
+		{ip: "169.254.169.254", expected: false},
+		{ip: "::1", expected: true},
+	}
+	for _, tc := range ipCases {
+		t.Run(tc.ip, func(t *testing.T) {
+			assert.Equal(t, tc.expected, p.allowedIP(net.ParseIP(tc.ip), "8080"))
+		})
+	}
+	assert.True(t, IsForwardRequest(newRequest("GET", "http://example.com/", nil, "")))
+	assert.False(t, IsForwardRequest(newRequest("GET", "/", nil, "")))
+}
@@ -0,0 +1,338 @@
+// Package proxy implements a reverse proxy handler that forwards requests to an upstream server.
+package proxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abdo-355/http-from-tcp/internal/client"
+	"github.com/abdo-355/http-from-tcp/internal/errorpage"
+	"github.com/abdo-355/http-from-tcp/internal/headers"
+	"github.com/abdo-355/http-from-tcp/internal/httperrors"
+	"github.com/abdo-355/http-from-tcp/internal/request"
+	"github.com/abdo-355/http-from-tcp/internal/response"
+	"github.com/abdo-355/http-from-tcp/internal/tracing"
+)
+
+const (
+	bufferSize     = 32 * 1024
+	defaultTimeout = 30 * time.Second
+)
+
+// hopHeaders are meaningful only for a single connection and must not be forwarded (RFC 9110 section 7.6.1).
+var hopHeaders = []string{
+	"connection",
+	"keep-alive",
+	"proxy-authenticate",
+	"proxy-authorization",
+	"proxy-connection",
+	"te",
+	"trailer",
+	"transfer-encoding",
+	"upgrade",
+}
+
+// ReverseProxy forwards requests whose target starts with Prefix to the upstreams in Pool.
+type ReverseProxy struct {
+	Pool   *Pool
+	Prefix string
+	Client *client.Client
+	// Retries is how many other upstreams an idempotent request is retried on
+	// after a connection error or a 502, 503 or 504 response.
+	Retries int
+}
+
+// New creates a ReverseProxy for the upstream base URL. The prefix is stripped from
+// the request target before it is appended to the upstream path.
+func New(upstream, prefix string) (*ReverseProxy, error) {
+	return NewBalanced([]string{upstream}, prefix, PoolOptions{})
+}
+
+// NewBalanced creates a ReverseProxy that spreads requests over several upstreams.
+func NewBalanced(upstreams []string, prefix string, opts PoolOptions) (*ReverseProxy, error) {
+	pool, err := NewPool(upstreams, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ReverseProxy{
+		Pool:    pool,
+		Prefix:  prefix,
+		Retries: len(upstreams) - 1,
+		// the client never follows redirects, so they are relayed as is
+		Client: &client.Client{Timeout: defaultTimeout, Pool: client.NewPool()},
+	}, nil
+}
+
+// Handle forwards req upstream and streams the upstream response back using chunked encoding.
+// The SHA-256 and length of the relayed body are sent as trailers.
+func (p *ReverseProxy) Handle(w *response.Writer, req *request.Request) {
+	if err := req.ReadBody(); err != nil {
+		proxyError(w, req, http.StatusBadRequest, err)
+		return
+	}
+
+	res, upstreamBody, err := p.roundTrip(req)
+	if err != nil {
+		var se httperrors.StatusError
+		switch {
+		case errors.Is(err, context.Canceled):
+			// the client went away, nobody is left to answer
+			return
+		case errors.Is(err, context.DeadlineExceeded):
+			proxyError(w, req, http.StatusGatewayTimeout, err)
+		case errors.As(err, &se):
+			proxyError(w, req, se.Code, se.Err)
+		default:
+			proxyError(w, req, http.StatusBadGateway, err)
+		}
+		return
+	}
+	defer upstreamBody.Close()
+
+	h := res.Headers.Clone()
+	removeHopHeaders(h)
+	w.WriteStatusLine("HTTP/1.1", res.StatusCode, res.Reason)
+	if req.IsHead() {
+		// the upstream's Content-Length is the one a GET would get, so it is passed on
+		w.WriteHeaders(h)
+		return
+	}
+	h.Del("content-length")
+	h.Set("transfer-encoding", "chunked")
+	h.Set("trailer", "X-Content-SHA256, X-Content-Length")
+
+	w.WriteHeaders(h)
+	cw := response.NewChunkedWriter(w)
+
+	body := response.NewHashWriter(cw, sha256.New())
+	n, err := copyBody(w, body, upstreamBody)
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	if err != nil {
+		// the status line is already out, so the only way to tell the client the body
+		// is incomplete is to end the stream without its last chunk
+		req.Logger().Error("error relaying upstream response", "err", err)
+		w.Abort()
+		return
+	}
+
+	cw.SetTrailer("X-Content-SHA256", hex.EncodeToString(body.Hash.Sum(nil)))
+	cw.SetTrailer("X-Content-Length", strconv.FormatInt(n, 10))
+	if err := body.Close(); err != nil {
+		req.Logger().Error("error writing trailers", "err", err)
+	}
+}
+
+// proxyErrors are the details clients get when proxying fails. The errors behind them
+// can name upstream hosts and addresses, so those only go to the log.
+var proxyErrors = map[int]error{
+	http.StatusBadRequest:          errors.New("the request could not be forwarded"),
+	http.StatusInternalServerError: errors.New("the connection could not be taken over"),
+	http.StatusBadGateway:          errors.New("the upstream could not be reached"),
+	http.StatusServiceUnavailable:  errors.New("no upstream is available"),
+	http.StatusGatewayTimeout:      errors.New("the upstream did not answer in time"),
+}
+
+// proxyError logs err and answers req with status and a generic detail.
+func proxyError(w *response.Writer, req *request.Request, status int, err error) {
+	req.Logger().Error("error proxying request", "err", err, "status", status)
+	errorpage.Error(w, req, status, proxyErrors[status])
+}
+
+// copyBody copies src to dst as it arrives, flushing w after every read so that streamed
+// responses, like server-sent events, reach the client right away.
+func copyBody(w *response.Writer, dst io.Writer, src io.Reader) (int64, error) {
+	buf := make([]byte, bufferSize)
+	var written int64
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			if _, err := dst.Write(buf[:n]); err != nil {
+				return written, err
+			}
+			written += int64(n)
+			if err := w.Flush(); err != nil {
+				return written, err
+			}
+		}
+		if err == io.EOF {
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+}
+
+// roundTrip sends req to an upstream picked from the pool, retrying idempotent
+// requests on other upstreams when the chosen one fails.
+func (p *ReverseProxy) roundTrip(req *request.Request) (*response.Response, io.ReadCloser, error) {
+	attempts := 1
+	if idempotent(req.RequestLine.Method) {
+		attempts += p.Retries
+	}
+
+	var tried []*Upstream
+	lastErr := httperrors.Newf(http.StatusServiceUnavailable, "no healthy upstream available")
+	for range attempts {
+		upstream := p.Pool.Pick(req, tried)
+		if upstream == nil {
+			break
+		}
+		tried = append(tried, upstream)
+
+		outReq, err := p.outgoingRequest(req, upstream.URL)
+		if err != nil {
+			return nil, nil, httperrors.New(http.StatusBadRequest, err)
+		}
+
+		upstream.active.Add(1)
+		res, body, err := p.Client.DoStream(req.Context(), outReq)
+		if err != nil {
+			upstream.active.Add(-1)
+		} else {
+			// the request is in flight until its body is relayed
+			body = &activeBody{ReadCloser: body, upstream: upstream}
+		}
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			// not the upstream's fault, and no point trying another one
+			return nil, nil, err
+		}
+		if err != nil {
+			req.Logger().Error("error reaching upstream", "err", err, "upstream", upstream.URL.Host)
+			p.Pool.ReportFailure(req, upstream)
+			lastErr = httperrors.New(http.StatusBadGateway, err)
+			continue
+		}
+
+		switch res.StatusCode {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			p.Pool.ReportFailure(req, upstream)
+			if len(tried) < attempts {
+				body.Close()
+				lastErr = httperrors.Newf(res.StatusCode, "upstream %s responded with %d %s", upstream.URL.Host, res.StatusCode, res.Reason)
+				continue
+			}
+		default:
+			p.Pool.ReportSuccess(upstream)
+		}
+		return res, body, nil
+	}
+
+	return nil, nil, lastErr
+}
+
+// activeBody counts a request as active on its upstream until the body is closed.
+type activeBody struct {
+	io.ReadCloser
+	upstream *Upstream
+	closed   bool
+}
+
+func (b *activeBody) Close() error {
+	if !b.closed {
+		b.closed = true
+		b.upstream.active.Add(-1)
+	}
+	return b.ReadCloser.Close()
+}
+
+func idempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
+
+func (p *ReverseProxy) outgoingRequest(req *request.Request, upstream *url.URL) (*client.Request, error) {
+	target := strings.TrimPrefix(req.RequestLine.RequestTarget, p.Prefix)
+	if !strings.HasPrefix(target, "/") {
+		target = "/" + target
+	}
+	path, rawQuery, _ := strings.Cut(target, "?")
+
+	u := *upstream
+	u.Path = strings.TrimSuffix(u.Path, "/") + path
+	u.RawPath = ""
+	u.RawQuery = rawQuery
+
+	outReq, err := client.NewRequest(req.RequestLine.Method, u.String(), req.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	outReq.Headers = req.Headers.Clone()
+	removeHopHeaders(outReq.Headers)
+	outReq.Headers.Del("host")
+	outReq.Headers.Del("content-length")
+	// the body was already read, there is nothing left to wait for upstream
+	outReq.Headers.Del("expect")
+	addForwardedHeaders(outReq.Headers, req)
+	setRequestID(outReq.Headers, req)
+	tracing.Inject(req.Context(), outReq.Headers)
+
+	return outReq, nil
+}
+
+// removeHopHeaders drops the hop-by-hop headers plus any header named in Connection.
+func removeHopHeaders(h headers.Headers) {
+	for _, name := range strings.Split(h.Get("connection"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			h.Del(name)
+		}
+	}
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+}
+
+// setRequestID passes the request ID on, replacing an incoming one the server did not accept.
+func setRequestID(out headers.Headers, req *request.Request) {
+	if req.ID != "" {
+		out.Set(request.IDHeader, req.ID)
+	}
+}
+
+func addForwardedHeaders(out headers.Headers, req *request.Request) {
+	clientIP := req.RemoteAddr
+	if host, _, err := net.SplitHostPort(clientIP); err == nil {
+		clientIP = host
+	}
+	if clientIP == "" {
+		return
+	}
+
+	if prior := req.Headers.Get("x-forwarded-for"); prior != "" {
+		out.Set("X-Forwarded-For", prior+", "+clientIP)
+	} else {
+		out.Set("X-Forwarded-For", clientIP)
+	}
+
+	node := clientIP
+	if strings.Contains(node, ":") {
+		// IPv6 addresses have to be quoted and bracketed (RFC 7239 section 6)
+		node = `"[` + node + `]"`
+	}
+	forwarded := "for=" + node + ";proto=http"
+	if host := req.Headers.Get("host"); host != "" {
+		forwarded += `;host="` + host + `"`
+		out.Set("X-Forwarded-Host", host)
+	}
+	if prior := req.Headers.Get("forwarded"); prior != "" {
+		forwarded = prior + ", " + forwarded
+	}
+	out.Set("Forwarded", forwarded)
+	out.Set("X-Forwarded-Proto", "http")
+}
@@ -0,0 +1,297 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abdo-355/http-from-tcp/internal/headers"
+	"github.com/abdo-355/http-from-tcp/internal/request"
+	"github.com/abdo-355/http-from-tcp/internal/response"
+	"github.com/abdo-355/http-from-tcp/internal/tracing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRequest(method, target string, h map[string]string, body string) *request.Request {
+	req := &request.Request{
+		RequestLine: request.RequestLine{Method: method, RequestTarget: target, HTTPVersion: "1.1"},
+		Headers:     headers.NewHeaders(),
+		Body:        []byte(body),
+		RemoteAddr:  "10.0.0.7:51234",
+	}
+	for k, v := range h {
+		req.Headers.Set(k, v)
+	}
+	return req
+}
+
+func TestReverseProxy_ForwardsRequest(t *testing.T) {
+	var got *http.Request
+	var gotBody []byte
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		gotBody, _ = io.ReadAll(r.Body)
+		w.Header().Set("X-Upstream", "yes")
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, "created")
+	}))
+	defer upstream.Close()
+
+	p, err := New(upstream.URL+"/api", "/proxy")
+	require.NoError(t, err)
+
+	req := newRequest("POST", "/proxy/items?id=3", map[string]string{
+		"host":            "example.com",
+		"content-type":    "application/json",
+		"content-length":  "7",
+		"connection":      "keep-alive, x-internal",
+		"x-internal":      "drop me",
+		"x-forwarded-for": "1.2.3.4",
+		"x-request-id":    "not trusted",
+	}, `{"a":1}`)
+	req.ID = "req-42"
+	span := tracing.StartServerSpan(req, time.Now())
+	req.SetContext(tracing.ContextWithSpan(req.Context(), span))
+	w := response.New()
+	p.Handle(w, req)
+
+	require.NotNil(t, got)
+	assert.Equal(t, "POST", got.Method)
+	assert.Equal(t, "/api/items", got.URL.Path)
+	assert.Equal(t, "id=3", got.URL.RawQuery)
+	assert.Equal(t, `{"a":1}`, string(gotBody))
+	assert.Equal(t, "application/json", got.Header.Get("Content-Type"))
+	assert.Equal(t, "", got.Header.Get("X-Internal"))
+	assert.Equal(t, "1.2.3.4, 10.0.0.7", got.Header.Get("X-Forwarded-For"))
+	assert.Equal(t, "req-42", got.Header.Get("X-Request-ID"))
+	assert.Equal(t, span.TraceParent().String(), got.Header.Get("Traceparent"))
+	assert.Equal(t, `for=10.0.0.7;proto=http;host="example.com"`, got.Header.Get("Forwarded"))
+
+	assert.Equal(t, http.StatusCreated, w.StatusCode())
+	h := w.Header()
+	assert.Equal(t, "yes", h.Get("x-upstream"))
+	assert.Equal(t, "", h.Get("connection"))
+	assert.Equal(t, "chunked", h.Get("transfer-encoding"))
+	assert.Equal(t, "created", string(w.Body()))
+
+	sum := sha256.Sum256([]byte("created"))
+	assert.Equal(t, hex.EncodeToString(sum[:]), w.Trailers().M["X-Content-SHA256"])
+	assert.Equal(t, "7", w.Trailers().M["X-Content-Length"])
+}
+
+func TestReverseProxy_StreamsResponse(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: first\n\n")
+		w.(http.Flusher).Flush()
+		<-release
+		io.WriteString(w, "data: second\n\n")
+	}))
+	defer upstream.Close()
+
+	p, err := New(upstream.URL, "")
+	require.NoError(t, err)
+
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w := response.NewWriter(pw)
+		p.Handle(w, newRequest("GET", "/events", nil, ""))
+		w.Finish()
+		pw.Close()
+	}()
+
+	// the first event arrives while the upstream is still holding the response open
+	br := bufio.NewReader(pr)
+	for {
+		line, err := br.ReadString('\n')
+		require.NoError(t, err)
+		if strings.Contains(line, "data: first") {
+			break
+		}
+	}
+	close(release)
+
+	rest, err := io.ReadAll(br)
+	require.NoError(t, err)
+	assert.Contains(t, string(rest), "data: second")
+	assert.Contains(t, string(rest), "X-Content-Length: 27")
+	<-done
+}
+
+// truncatingUpstream answers one request with a chunked body and closes the connection
+// before the last chunk.
+func truncatingUpstream(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		bufio.NewReader(conn).ReadString('\n')
+		io.WriteString(conn, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n7\r\npartial\r\n")
+		conn.Close()
+	}()
+	return ln.Addr().String()
+}
+
+// handleOnConn runs handle with a Writer on a connection and returns what the client
+// received by the time the connection was closed.
+func handleOnConn(handle func(w *response.Writer, req *request.Request), req *request.Request) (*response.Writer, string) {
+	serverSide, clientSide := net.Pipe()
+	done := make(chan []byte)
+	go func() {
+		got, _ := io.ReadAll(clientSide)
+		done <- got
+	}()
+	w := response.NewWriter(serverSide)
+	handle(w, req)
+	w.Finish()
+	serverSide.Close()
+	return w, string(<-done)
+}
+
+func TestReverseProxy_UpstreamDiesMidBody(t *testing.T) {
+	p, err := New("http://"+truncatingUpstream(t), "")
+	require.NoError(t, err)
+
+	w, got := handleOnConn(p.Handle, newRequest("GET", "/", nil, ""))
+
+	// the client must not be able to mistake the truncated body for a complete one
+	assert.Contains(t, got, "partial")
+	assert.NotContains(t, got, "0\r\n\r\n")
+	assert.NotContains(t, got, "X-Content-Length: ")
+	assert.True(t, w.Aborted())
+}
+
+func TestReverseProxy_Head(t *testing.T) {
+	var method string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method = r.Method
+		w.Header().Set("Content-Length", "1234")
+	}))
+	defer upstream.Close()
+
+	p, err := New(upstream.URL, "")
+	require.NoError(t, err)
+
+	w := response.New()
+	w.OmitBody()
+	p.Handle(w, newRequest("HEAD", "/file", nil, ""))
+	require.NoError(t, w.Finish())
+
+	assert.Equal(t, "HEAD", method)
+	assert.Equal(t, http.StatusOK, w.StatusCode())
+	h := w.Header()
+	assert.Equal(t, "1234", h.Get("content-length"))
+	assert.Equal(t, "", h.Get("transfer-encoding"))
+}
+
+func TestReverseProxy_RelaysErrorsAndRedirects(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/elsewhere", http.StatusFound)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer upstream.Close()
+
+	p, err := New(upstream.URL, "/proxy")
+	require.NoError(t, err)
+
+	w := response.New()
+	p.Handle(w, newRequest("GET", "/proxy/missing", nil, ""))
+	assert.Equal(t, http.StatusNotFound, w.StatusCode())
+
+	w = response.New()
+	p.Handle(w, newRequest("GET", "/proxy/redirect", nil, ""))
+	assert.Equal(t, http.StatusFound, w.StatusCode())
+	h := w.Header()
+	assert.Equal(t, "/elsewhere", h.Get("location"))
+}
+
+func TestReverseProxy_BadGateway(t *testing.T) {
+	upstream := httptest.NewServer(http.NotFoundHandler())
+	url := upstream.URL
+	upstream.Close()
+
+	p, err := New(url, "")
+	require.NoError(t, err)
+
+	w := response.New()
+	p.Handle(w, newRequest("GET", "/", nil, ""))
+	assert.Equal(t, http.StatusBadGateway, w.StatusCode())
+	// the dial error naming the upstream only goes to the log
+	assert.Equal(t, "502 Bad Gateway: the upstream could not be reached", string(w.Body()))
+}
+
+func TestNew_InvalidUpstream(t *testing.T) {
+	_, err := New("ftp://example.com", "")
+	require.Error(t, err)
+}
+
+func TestReverseProxy_RequestContext(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer upstream.Close()
+	defer close(release)
+
+	p, err := New(upstream.URL, "")
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name   string
+		ctx    func() (context.Context, context.CancelFunc)
+		status int
+	}{
+		{
+			name: "Client gone",
+			ctx: func() (context.Context, context.CancelFunc) {
+				ctx, cancel := context.WithCancel(context.Background())
+				time.AfterFunc(50*time.Millisecond, cancel)
+				return ctx, cancel
+			},
+			status: 0,
+		},
+		{
+			name: "Timed out",
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 50*time.Millisecond)
+			},
+			status: http.StatusGatewayTimeout,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := tc.ctx()
+			defer cancel()
+
+			req := newRequest("GET", "/slow", nil, "")
+			req.SetContext(ctx)
+			w := response.New()
+			start := time.Now()
+			p.Handle(w, req)
+
+			assert.Less(t, time.Since(start), time.Second)
+			assert.Equal(t, tc.status, w.StatusCode())
+		})
+	}
+}
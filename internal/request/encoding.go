@@ -0,0 +1,85 @@
+package request
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/abdo-355/http-from-tcp/internal/httperrors"
+)
+
+// DecodeBody replaces a body sent with Content-Encoding gzip or deflate by its decoded form.
+// Decoding stops with a 413 error once more than maxSize bytes were produced, which protects
+// against decompression bombs. Unknown encodings yield a 415 and corrupt data a 400 error;
+// all of them are httperrors.StatusError values.
+// On success the content-encoding header is removed and content-length updated.
+func (r *Request) DecodeBody(maxSize int64) error {
+	ce := r.Headers.Get("content-encoding")
+	if ce == "" {
+		return nil
+	}
+
+	encodings := strings.Split(ce, ",")
+	body := r.Body
+	// codings are listed in the order they were applied, so undo them in reverse
+	for i := len(encodings) - 1; i >= 0; i-- {
+		encoding := strings.ToLower(strings.TrimSpace(encodings[i]))
+		decoded, err := decode(encoding, body, maxSize)
+		if err != nil {
+			return err
+		}
+		body = decoded
+	}
+
+	r.Body = body
+	r.Headers.Del("content-encoding")
+	r.Headers.Set("content-length", strconv.Itoa(len(body)))
+	return nil
+}
+
+func decode(encoding string, body []byte, maxSize int64) ([]byte, error) {
+	var rc io.ReadCloser
+	var err error
+
+	switch encoding {
+	case "identity", "":
+		return body, nil
+	case "gzip", "x-gzip":
+		rc, err = gzip.NewReader(bytes.NewReader(body))
+	case "deflate":
+		rc, err = newDeflateReader(body)
+	default:
+		return nil, httperrors.Newf(http.StatusUnsupportedMediaType, "unsupported content-encoding: %s", encoding)
+	}
+	if err != nil {
+		return nil, httperrors.Newf(http.StatusBadRequest, "invalid %s body: %w", encoding, err)
+	}
+	defer rc.Close()
+
+	// read one byte past the limit so an exact fit is not reported as too large
+	decoded, err := io.ReadAll(io.LimitReader(rc, maxSize+1))
+	if err != nil {
+		return nil, httperrors.Newf(http.StatusBadRequest, "invalid %s body: %w", encoding, err)
+	}
+	if int64(len(decoded)) > maxSize {
+		return nil, httperrors.Newf(http.StatusRequestEntityTooLarge, "decoded body exceeds %d bytes", maxSize)
+	}
+
+	return decoded, nil
+}
+
+// newDeflateReader accepts both zlib wrapped data, which is what "deflate" means in HTTP,
+// and the raw deflate streams some clients send instead.
+func newDeflateReader(body []byte) (io.ReadCloser, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(body))
+	if errors.Is(err, zlib.ErrHeader) {
+		return flate.NewReader(bytes.NewReader(body)), nil
+	}
+	return zr, err
+}
@@ -0,0 +1,106 @@
+package request
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/abdo-355/http-from-tcp/internal/headers"
+	"github.com/abdo-355/http-from-tcp/internal/httperrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, err := zw.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func zlibBytes(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	_, err := zw.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func rawDeflateBytes(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	zw, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	require.NoError(t, err)
+	_, err = zw.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func TestDecodeBody(t *testing.T) {
+	payload := []byte(`{"metric":"cpu","value":0.93}`)
+
+	testCases := []struct {
+		name           string
+		encoding       string
+		body           []byte
+		maxSize        int64
+		expectedBody   []byte
+		expectedStatus int
+	}{
+		{name: "No encoding", encoding: "", body: payload, maxSize: 1024, expectedBody: payload},
+		{name: "Gzip", encoding: "gzip", body: gzipBytes(t, payload), maxSize: 1024, expectedBody: payload},
+		{name: "Zlib deflate", encoding: "deflate", body: zlibBytes(t, payload), maxSize: 1024, expectedBody: payload},
+		{name: "Raw deflate", encoding: "Deflate", body: rawDeflateBytes(t, payload), maxSize: 1024, expectedBody: payload},
+		{name: "Stacked encodings", encoding: "deflate, gzip", body: gzipBytes(t, zlibBytes(t, payload)), maxSize: 1024, expectedBody: payload},
+		{name: "Exact size limit", encoding: "gzip", body: gzipBytes(t, payload), maxSize: int64(len(payload)), expectedBody: payload},
+		{name: "Decompression bomb", encoding: "gzip", body: gzipBytes(t, bytes.Repeat([]byte("a"), 1<<20)), maxSize: 1024, expectedStatus: http.StatusRequestEntityTooLarge},
+		{name: "Unsupported encoding", encoding: "br", body: payload, maxSize: 1024, expectedStatus: http.StatusUnsupportedMediaType},
+		{name: "Corrupt gzip", encoding: "gzip", body: []byte("not gzip at all"), maxSize: 1024, expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &Request{Headers: headers.NewHeaders(), Body: tc.body}
+			if tc.encoding != "" {
+				r.Headers.Set("content-encoding", tc.encoding)
+			}
+
+			err := r.DecodeBody(tc.maxSize)
+			if tc.expectedStatus != 0 {
+				var se httperrors.StatusError
+				require.True(t, errors.As(err, &se))
+				assert.Equal(t, tc.expectedStatus, se.Code)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedBody, r.Body)
+			assert.Equal(t, "", r.Headers.Get("content-encoding"))
+		})
+	}
+}
+
+func TestDecodeBody_FromReader(t *testing.T) {
+	compressed := gzipBytes(t, []byte("hello world"))
+	reader := &chunkReader{
+		data: "POST /telemetry HTTP/1.1\r\n" +
+			"Host: localhost:8080\r\n" +
+			"Content-Encoding: gzip\r\n" +
+			"Content-Length: " + strconv.Itoa(len(compressed)) + "\r\n" +
+			"\r\n" + string(compressed),
+		numBytesPerRead: 5,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NoError(t, r.DecodeBody(1024))
+	assert.Equal(t, "hello world", string(r.Body))
+	assert.Equal(t, "11", r.Headers.Get("content-length"))
+}
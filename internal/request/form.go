@@ -0,0 +1,285 @@
+package request
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/abdo-355/http-from-tcp/internal/headers"
+	"github.com/abdo-355/http-from-tcp/internal/httperrors"
+)
+
+// FormOptions limits what ParseForm accepts. Fields left at zero take their value from
+// DefaultFormOptions.
+type FormOptions struct {
+	// MaxSize caps the whole body.
+	MaxSize int64
+	// MaxMemory is how many bytes of uploaded files are kept in memory. Files past it are
+	// written to temporary files, which Form.RemoveAll deletes. This bounds memory when the
+	// body is streamed from the connection, i.e. when it was not read before ParseForm.
+	MaxMemory int64
+	// MaxFieldSize caps the value of each field that is not a file.
+	MaxFieldSize int64
+	// MaxParts caps the number of fields and files.
+	MaxParts int
+}
+
+// DefaultFormOptions accepts bodies up to 32MB with up to 1000 parts, keeping 10MB of
+// files in memory and fields up to 1MB.
+func DefaultFormOptions() FormOptions {
+	return FormOptions{
+		MaxSize:      32 << 20,
+		MaxMemory:    10 << 20,
+		MaxFieldSize: 1 << 20,
+		MaxParts:     1000,
+	}
+}
+
+// Form holds the fields and files of a submitted form.
+type Form struct {
+	Value map[string][]string
+	File  map[string][]*FormFile
+}
+
+// Get returns the first value of the field name, or "".
+func (f *Form) Get(name string) string {
+	if v := f.Value[name]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// RemoveAll deletes the temporary files holding uploaded files.
+func (f *Form) RemoveAll() error {
+	var errs []error
+	for _, files := range f.File {
+		for _, file := range files {
+			if file.tmpfile != "" {
+				if err := os.Remove(file.tmpfile); err != nil && !errors.Is(err, os.ErrNotExist) {
+					errs = append(errs, err)
+				}
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// FormFile is a file uploaded in a multipart/form-data body.
+type FormFile struct {
+	Filename string
+	// Header holds the part's headers, like content-type.
+	Header headers.Headers
+	Size   int64
+
+	content []byte
+	tmpfile string
+}
+
+// Open returns the file's content.
+func (f *FormFile) Open() (io.ReadCloser, error) {
+	if f.tmpfile != "" {
+		return os.Open(f.tmpfile)
+	}
+	return io.NopCloser(bytes.NewReader(f.content)), nil
+}
+
+// ParseForm parses the body as application/x-www-form-urlencoded or multipart/form-data,
+// reading it through BodyReader so that a body that was not read yet is parsed as it
+// arrives. Other content types yield a 415 error, bodies breaking the limits of opts a 413
+// and malformed ones a 400; all of them are httperrors.StatusError values.
+// Callers should defer RemoveAll on the returned form when it may hold files.
+func (r *Request) ParseForm(opts FormOptions) (*Form, error) {
+	opts = opts.withDefaults()
+	mediaType, params, err := mime.ParseMediaType(r.Headers.Get("content-type"))
+	if err != nil {
+		return nil, httperrors.Newf(http.StatusUnsupportedMediaType, "form content-type expected")
+	}
+	if mediaType != "application/x-www-form-urlencoded" && mediaType != "multipart/form-data" {
+		return nil, httperrors.Newf(http.StatusUnsupportedMediaType, "unsupported form content-type: %s", mediaType)
+	}
+
+	if mediaType == "multipart/form-data" && params["boundary"] == "" {
+		return nil, httperrors.Newf(http.StatusBadRequest, "multipart form without boundary")
+	}
+
+	src, err := r.BodyReader()
+	if err != nil {
+		return nil, httperrors.Newf(http.StatusBadRequest, "error reading form body: %w", err)
+	}
+	body := &sizeLimiter{r: src, remaining: opts.MaxSize}
+	tooLarge := httperrors.Newf(http.StatusRequestEntityTooLarge, "form body exceeds %d bytes", opts.MaxSize)
+
+	if mediaType == "multipart/form-data" {
+		form, err := parseMultipart(multipart.NewReader(body, params["boundary"]), opts)
+		if body.exceeded() {
+			return nil, tooLarge
+		}
+		return form, err
+	}
+
+	data, err := io.ReadAll(body)
+	if body.exceeded() {
+		return nil, tooLarge
+	}
+	if err != nil {
+		return nil, httperrors.Newf(http.StatusBadRequest, "error reading form body: %w", err)
+	}
+	return parseURLEncoded(string(data), opts)
+}
+
+func (o FormOptions) withDefaults() FormOptions {
+	d := DefaultFormOptions()
+	if o.MaxSize <= 0 {
+		o.MaxSize = d.MaxSize
+	}
+	if o.MaxMemory <= 0 {
+		o.MaxMemory = d.MaxMemory
+	}
+	if o.MaxFieldSize <= 0 {
+		o.MaxFieldSize = d.MaxFieldSize
+	}
+	if o.MaxParts <= 0 {
+		o.MaxParts = d.MaxParts
+	}
+	return o
+}
+
+var errBodyTooLarge = errors.New("body too large")
+
+// sizeLimiter fails once more than remaining bytes were read from r. It reads one byte
+// past the limit, so that a body of exactly the limit is not reported as too large.
+type sizeLimiter struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (l *sizeLimiter) Read(p []byte) (int, error) {
+	if l.exceeded() {
+		return 0, errBodyTooLarge
+	}
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	if l.exceeded() {
+		return n, errBodyTooLarge
+	}
+	return n, err
+}
+
+func (l *sizeLimiter) exceeded() bool {
+	return l.remaining < 0
+}
+
+func parseURLEncoded(body string, opts FormOptions) (*Form, error) {
+	form := &Form{Value: map[string][]string{}}
+	if body == "" {
+		return form, nil
+	}
+	if strings.Count(body, "&")+1 > opts.MaxParts {
+		return nil, httperrors.Newf(http.StatusRequestEntityTooLarge, "form has more than %d fields", opts.MaxParts)
+	}
+	values, err := url.ParseQuery(body)
+	if err != nil {
+		return nil, httperrors.Newf(http.StatusBadRequest, "invalid form body: %w", err)
+	}
+	for name, vs := range values {
+		for _, v := range vs {
+			if int64(len(v)) > opts.MaxFieldSize {
+				return nil, httperrors.Newf(http.StatusRequestEntityTooLarge, "form field %q exceeds %d bytes", name, opts.MaxFieldSize)
+			}
+		}
+	}
+	form.Value = values
+	return form, nil
+}
+
+func parseMultipart(mr *multipart.Reader, opts FormOptions) (_ *Form, err error) {
+	form := &Form{Value: map[string][]string{}, File: map[string][]*FormFile{}}
+	defer func() {
+		if err != nil {
+			form.RemoveAll()
+		}
+	}()
+
+	memory := opts.MaxMemory
+	for parts := 0; ; parts++ {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return form, nil
+		}
+		if err != nil {
+			return nil, httperrors.Newf(http.StatusBadRequest, "invalid multipart body: %w", err)
+		}
+		if parts == opts.MaxParts {
+			return nil, httperrors.Newf(http.StatusRequestEntityTooLarge, "form has more than %d parts", opts.MaxParts)
+		}
+
+		name := part.FormName()
+		if name == "" {
+			continue
+		}
+		if part.FileName() == "" {
+			// read one byte past the limit so an exact fit is not reported as too large
+			value, err := io.ReadAll(io.LimitReader(part, opts.MaxFieldSize+1))
+			if err != nil {
+				return nil, httperrors.Newf(http.StatusBadRequest, "invalid multipart body: %w", err)
+			}
+			if int64(len(value)) > opts.MaxFieldSize {
+				return nil, httperrors.Newf(http.StatusRequestEntityTooLarge, "form field %q exceeds %d bytes", name, opts.MaxFieldSize)
+			}
+			form.Value[name] = append(form.Value[name], string(value))
+			continue
+		}
+
+		file, err := readFormFile(part, memory)
+		if err != nil {
+			return nil, err
+		}
+		if file.tmpfile == "" {
+			memory -= file.Size
+		}
+		form.File[name] = append(form.File[name], file)
+	}
+}
+
+// readFormFile keeps the part in memory when it fits in memory bytes, and streams it to a
+// temporary file otherwise.
+func readFormFile(part *multipart.Part, memory int64) (*FormFile, error) {
+	file := &FormFile{Filename: part.FileName(), Header: headers.NewHeaders()}
+	for key, values := range part.Header {
+		file.Header.Set(key, strings.Join(values, ", "))
+	}
+
+	var buf bytes.Buffer
+	n, err := io.CopyN(&buf, part, memory+1)
+	if err != nil && err != io.EOF {
+		return nil, httperrors.Newf(http.StatusBadRequest, "invalid multipart body: %w", err)
+	}
+	if n <= memory {
+		file.content = buf.Bytes()
+		file.Size = n
+		return file, nil
+	}
+
+	tmp, err := os.CreateTemp("", "form-")
+	if err != nil {
+		return nil, err
+	}
+	defer tmp.Close()
+	file.tmpfile = tmp.Name()
+	size, err := io.Copy(tmp, io.MultiReader(&buf, part))
+	if err != nil {
+		os.Remove(file.tmpfile)
+		return nil, httperrors.Newf(http.StatusBadRequest, "invalid multipart body: %w", err)
+	}
+	file.Size = size
+	return file, nil
+}
@@ -0,0 +1,197 @@
+package request
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/abdo-355/http-from-tcp/internal/headers"
+	"github.com/abdo-355/http-from-tcp/internal/httperrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func formRequest(contentType string, body []byte) *Request {
+	r := &Request{Headers: headers.NewHeaders(), Body: body}
+	r.Headers.Set("content-type", contentType)
+	r.Headers.Set("content-length", strconv.Itoa(len(body)))
+	return r
+}
+
+func multipartBody(t *testing.T, fields map[string]string, files map[string]string) (string, []byte) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for name, value := range fields {
+		require.NoError(t, mw.WriteField(name, value))
+	}
+	for filename, content := range files {
+		fw, err := mw.CreateFormFile("upload", filename)
+		require.NoError(t, err)
+		_, err = fw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, mw.Close())
+	return mw.FormDataContentType(), buf.Bytes()
+}
+
+func fileContent(t *testing.T, f *FormFile) string {
+	rc, err := f.Open()
+	require.NoError(t, err)
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestParseForm_URLEncoded(t *testing.T) {
+	r := formRequest("application/x-www-form-urlencoded", []byte("name=Ada+Lovelace&tag=a&tag=b&note=%3Chi%3E"))
+	form, err := r.ParseForm(DefaultFormOptions())
+	require.NoError(t, err)
+	assert.Equal(t, "Ada Lovelace", form.Get("name"))
+	assert.Equal(t, []string{"a", "b"}, form.Value["tag"])
+	assert.Equal(t, "<hi>", form.Get("note"))
+	assert.Equal(t, "", form.Get("missing"))
+}
+
+func TestParseForm_Multipart(t *testing.T) {
+	contentType, body := multipartBody(t,
+		map[string]string{"title": "report"},
+		map[string]string{"small.txt": "tiny", "big.txt": strings.Repeat("x", 100)},
+	)
+	opts := DefaultFormOptions()
+	opts.MaxMemory = 50
+
+	form, err := formRequest(contentType, body).ParseForm(opts)
+	require.NoError(t, err)
+	defer form.RemoveAll()
+
+	assert.Equal(t, "report", form.Get("title"))
+	require.Len(t, form.File["upload"], 2)
+	files := map[string]*FormFile{}
+	for _, f := range form.File["upload"] {
+		files[f.Filename] = f
+	}
+
+	small := files["small.txt"]
+	require.NotNil(t, small)
+	assert.Empty(t, small.tmpfile)
+	assert.Equal(t, int64(4), small.Size)
+	assert.Equal(t, "application/octet-stream", small.Header.Get("content-type"))
+	assert.Equal(t, "tiny", fileContent(t, small))
+
+	big := files["big.txt"]
+	require.NotNil(t, big)
+	assert.NotEmpty(t, big.tmpfile)
+	assert.Equal(t, int64(100), big.Size)
+	assert.Equal(t, strings.Repeat("x", 100), fileContent(t, big))
+
+	require.NoError(t, form.RemoveAll())
+	_, err = os.Stat(big.tmpfile)
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+}
+
+func TestParseForm_Errors(t *testing.T) {
+	contentType, body := multipartBody(t, map[string]string{"a": "1", "b": "2", "c": "3"}, nil)
+	longType, longBody := multipartBody(t, map[string]string{"a": "123456"}, nil)
+
+	testCases := []struct {
+		name           string
+		contentType    string
+		body           []byte
+		opts           func(*FormOptions)
+		expectedStatus int
+	}{
+		{name: "Missing content type", body: []byte("a=1"), expectedStatus: http.StatusUnsupportedMediaType},
+		{name: "JSON", contentType: "application/json", body: []byte(`{}`), expectedStatus: http.StatusUnsupportedMediaType},
+		{
+			name:           "Body too large",
+			contentType:    "application/x-www-form-urlencoded",
+			body:           []byte("a=1234567890"),
+			opts:           func(o *FormOptions) { o.MaxSize = 5 },
+			expectedStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:           "Too many fields",
+			contentType:    "application/x-www-form-urlencoded",
+			body:           []byte("a=1&b=2&c=3"),
+			opts:           func(o *FormOptions) { o.MaxParts = 2 },
+			expectedStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:           "Field too large",
+			contentType:    "application/x-www-form-urlencoded",
+			body:           []byte("a=123456"),
+			opts:           func(o *FormOptions) { o.MaxFieldSize = 5 },
+			expectedStatus: http.StatusRequestEntityTooLarge,
+		},
+		{name: "Invalid escape", contentType: "application/x-www-form-urlencoded", body: []byte("a=%zz"), expectedStatus: http.StatusBadRequest},
+		{
+			name:           "Too many parts",
+			contentType:    contentType,
+			body:           body,
+			opts:           func(o *FormOptions) { o.MaxParts = 2 },
+			expectedStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:           "Part too large",
+			contentType:    longType,
+			body:           longBody,
+			opts:           func(o *FormOptions) { o.MaxFieldSize = 5 },
+			expectedStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:           "Multipart body too large",
+			contentType:    contentType,
+			body:           body,
+			opts:           func(o *FormOptions) { o.MaxSize = 100 },
+			expectedStatus: http.StatusRequestEntityTooLarge,
+		},
+		{name: "Missing boundary", contentType: "multipart/form-data", body: body, expectedStatus: http.StatusBadRequest},
+		{name: "Truncated multipart", contentType: contentType, body: body[:len(body)-10], expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := DefaultFormOptions()
+			if tc.opts != nil {
+				tc.opts(&opts)
+			}
+			_, err := formRequest(tc.contentType, tc.body).ParseForm(opts)
+			require.Error(t, err)
+			var se httperrors.StatusError
+			require.True(t, errors.As(err, &se))
+			assert.Equal(t, tc.expectedStatus, se.Code)
+		})
+	}
+}
+
+func TestParseForm_ZeroOptions(t *testing.T) {
+	form, err := formRequest("application/x-www-form-urlencoded", []byte("a=1&b=2")).ParseForm(FormOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "2", form.Get("b"))
+}
+
+func TestParseForm_StreamsBody(t *testing.T) {
+	contentType, body := multipartBody(t, map[string]string{"title": "notes"}, map[string]string{"a.txt": strings.Repeat("x", 4096)})
+	raw := "POST /upload HTTP/1.1\r\nContent-Type: " + contentType + "\r\nContent-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + string(body)
+
+	r, err := HeadFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	opts := DefaultFormOptions()
+	opts.MaxMemory = 1024
+	form, err := r.ParseForm(opts)
+	require.NoError(t, err)
+	defer form.RemoveAll()
+
+	assert.Equal(t, "notes", form.Get("title"))
+	assert.Equal(t, strings.Repeat("x", 4096), fileContent(t, form.File["upload"][0]))
+	assert.NotEmpty(t, form.File["upload"][0].tmpfile)
+	// the body went straight from the connection into the form
+	assert.Empty(t, r.Body)
+}
@@ -0,0 +1,45 @@
+package request
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+)
+
+// IDHeader carries the request ID between clients, proxies and upstreams.
+const IDHeader = "x-request-id"
+
+const maxIDLength = 128
+
+// NewID generates a random request ID.
+func NewID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ValidID reports whether an incoming request ID can be trusted as is: short and made
+// only of characters that cannot break a log line or a header.
+func ValidID(id string) bool {
+	if id == "" || len(id) > maxIDLength {
+		return false
+	}
+	for _, c := range []byte(id) {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':', c == '+', c == '=', c == '/':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Logger returns the default logger with the request ID attached, so that every record
+// about the request can be tied to it.
+func (r *Request) Logger() *slog.Logger {
+	if r.ID == "" {
+		return slog.Default()
+	}
+	return slog.Default().With("request_id", r.ID)
+}
@@ -0,0 +1,36 @@
+package request
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidID(t *testing.T) {
+	testCases := []struct {
+		name  string
+		id    string
+		valid bool
+	}{
+		{name: "Generated", id: NewID(), valid: true},
+		{name: "UUID", id: "123e4567-e89b-12d3-a456-426614174000", valid: true},
+		{name: "Base64", id: "aGVsbG8+d29ybGQ/Lw==", valid: true},
+		{name: "Query", id: "abc?x=1", valid: false},
+		{name: "Empty", id: "", valid: false},
+		{name: "Too long", id: strings.Repeat("a", 129), valid: false},
+		{name: "Spaces", id: "abc def", valid: false},
+		{name: "Log injection", id: "abc\nlevel=ERROR", valid: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.valid, ValidID(tc.id))
+		})
+	}
+}
+
+func TestNewID(t *testing.T) {
+	assert.Len(t, NewID(), 32)
+	assert.NotEqual(t, NewID(), NewID())
+}
@@ -3,10 +3,12 @@ package request
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/abdo-355/http-from-tcp/internal/headers"
 )
@@ -24,8 +26,19 @@ type Request struct {
 	RequestLine RequestLine
 	Headers     headers.Headers
 	Body        []byte
-
-	state requestState
+	// RemoteAddr is the network address of the client, set by the server.
+	RemoteAddr string
+	// ID identifies the request in logs and across proxies. The server takes it from a
+	// valid X-Request-ID header or generates one.
+	ID string
+
+	ctx          context.Context
+	state        requestState
+	src          io.Reader
+	buf          []byte
+	bufferOffset int
+	beforeBody   func() error
+	afterBody    func(start, end time.Time)
 }
 
 type RequestLine struct {
@@ -49,42 +62,214 @@ func growBuffer(buf []byte, offset int) []byte {
 }
 
 func RequestFromReader(reader io.Reader) (*Request, error) {
-	buf := make([]byte, bufferSize)
-	bufferOffset := 0
-	r := Request{state: Initialized}
+	r, err := HeadFromReader(reader)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.ReadBody(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// HeadFromReader parses the request line and headers. The body is left on the reader
+// until ReadBody is called, which lets the server answer Expect: 100-continue first.
+func HeadFromReader(reader io.Reader) (*Request, error) {
+	r := Request{
+		state: Initialized,
+		src:   reader,
+		buf:   make([]byte, bufferSize),
+	}
+	err := r.read(func() bool {
+		return r.state == ParsingBody || r.state == Done
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
 
-	for r.state != Done {
-		buf = growBuffer(buf, bufferOffset)
-		bytesRead, err := reader.Read(buf[bufferOffset:])
+// ReadBody reads the rest of the body announced by Content-Length into Body. The hook
+// set with OnBodyRead runs first. Calling it again once the body is complete does nothing.
+func (r *Request) ReadBody() error {
+	start := time.Now()
+	if r.state == Done || r.src == nil {
+		r.bodyDone(start)
+		return nil
+	}
+	if hook := r.beforeBody; hook != nil {
+		r.beforeBody = nil
+		if err := hook(); err != nil {
+			return err
+		}
+	}
+	err := r.read(func() bool {
+		return r.state == Done
+	})
+	if err == nil {
+		r.bodyDone(start)
+	}
+	return err
+}
+
+// BodyReader returns the body as a stream, for handlers that process it as it arrives
+// rather than holding all of it in Body. A body that was already read is read from Body.
+// Otherwise the hook set with OnBodyRead runs first and the rest of the body is read
+// from the connection, leaving Body empty.
+func (r *Request) BodyReader() (io.Reader, error) {
+	start := time.Now()
+	if r.state == Done || r.src == nil {
+		r.bodyDone(start)
+		return bytes.NewReader(r.Body), nil
+	}
+	if hook := r.beforeBody; hook != nil {
+		r.beforeBody = nil
+		if err := hook(); err != nil {
+			return nil, err
+		}
+	}
+
+	// parse validated Content-Length and moved what arrived with the headers into Body
+	length, _ := strconv.ParseInt(r.Headers.Get("content-length"), 10, 64)
+	received := r.Body
+	r.Body = nil
+	r.state = Done
+	body := &bodyReader{r: r.src, remaining: length - int64(len(received)), done: func() { r.bodyDone(start) }}
+	return io.MultiReader(bytes.NewReader(received), body), nil
+}
+
+// bodyReader reads the part of a body still on the connection, calling done once all
+// of it was read.
+type bodyReader struct {
+	r         io.Reader
+	remaining int64
+	done      func()
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	if b.remaining <= 0 {
+		b.finish()
+		return 0, io.EOF
+	}
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.r.Read(p)
+	b.remaining -= int64(n)
+	if err == io.EOF && b.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	if b.remaining <= 0 {
+		b.finish()
+	}
+	return n, err
+}
+
+func (b *bodyReader) finish() {
+	if done := b.done; done != nil {
+		b.done = nil
+		done()
+	}
+}
+
+// OnBodyRead registers fn to run right before ReadBody starts reading the body.
+func (r *Request) OnBodyRead(fn func() error) {
+	r.beforeBody = fn
+}
+
+// OnBodyDone registers fn to run once the body was read completely, by ReadBody or
+// through BodyReader, with the time reading it started and ended. The server uses it to
+// time the body in the request's span.
+func (r *Request) OnBodyDone(fn func(start, end time.Time)) {
+	r.afterBody = fn
+}
+
+func (r *Request) bodyDone(start time.Time) {
+	if fn := r.afterBody; fn != nil {
+		r.afterBody = nil
+		fn(start, time.Now())
+	}
+}
+
+// Unread returns the rest of the connection: the bytes already read past what was parsed,
+// followed by whatever the client has not sent yet.
+func (r *Request) Unread() io.Reader {
+	buffered := bytes.NewReader(bytes.Clone(r.buf[:r.bufferOffset]))
+	if r.src == nil {
+		return buffered
+	}
+	return io.MultiReader(buffered, r.src)
+}
+
+// Context returns the request's context. The server cancels it when the client closes
+// the connection, the server shuts down, the request times out or the handler returns.
+func (r *Request) Context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+// SetContext replaces the request's context, e.g. to pass a derived one to the next handler.
+func (r *Request) SetContext(ctx context.Context) {
+	r.ctx = ctx
+}
+
+// SetValue attaches val to the request's context under key, for handlers further down
+// the chain to read with Value.
+func (r *Request) SetValue(key, val any) {
+	r.ctx = context.WithValue(r.Context(), key, val)
+}
+
+// Value returns the value attached to the request's context under key, or nil.
+func (r *Request) Value(key any) any {
+	return r.Context().Value(key)
+}
+
+// ExpectsContinue reports whether the client waits for a 100 Continue before sending the body.
+func (r *Request) ExpectsContinue() bool {
+	return strings.EqualFold(r.Headers.Get("expect"), "100-continue")
+}
+
+// IsHead reports whether the client asked for the headers only. Handlers answer HEAD
+// like GET, the server drops the body they write.
+func (r *Request) IsHead() bool {
+	return r.RequestLine.Method == "HEAD"
+}
+
+func (r *Request) read(done func() bool) error {
+	for !done() {
+		r.buf = growBuffer(r.buf, r.bufferOffset)
+		bytesRead, err := r.src.Read(r.buf[r.bufferOffset:])
 		if err == io.EOF {
 			if r.state == Initialized {
-				return nil, fmt.Errorf("unexpected EOF")
+				return fmt.Errorf("unexpected EOF")
 			}
 			switch r.state {
 			case ParsingHeaders:
-				return nil, fmt.Errorf("unexpected EOF while parsing headers")
+				return fmt.Errorf("unexpected EOF while parsing headers")
 			case ParsingBody:
-				return nil, fmt.Errorf("unexpected EOF while parsing Body")
+				return fmt.Errorf("unexpected EOF while parsing Body")
 			}
 			break
 		}
-		bufferOffset += bytesRead
+		r.bufferOffset += bytesRead
 
 		if err != nil {
-			return nil, err
+			return err
 		}
 
-		bytesParsed, err := r.parse(buf[:bufferOffset])
+		bytesParsed, err := r.parse(r.buf[:r.bufferOffset])
 		if err != nil {
-			return nil, err
+			return err
 		}
 
 		// Remove the data that was parsed successfully from the buffer (this keeps our buffer small and memory efficient).
-		copy(buf, buf[bytesParsed:])
-		bufferOffset -= bytesParsed
+		copy(r.buf, r.buf[bytesParsed:])
+		r.bufferOffset -= bytesParsed
 	}
 
-	return &r, nil
+	return nil
 }
 
 func parseRequestLine(data []byte) (*RequestLine, int, error) {
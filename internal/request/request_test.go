@@ -1,8 +1,12 @@
 package request
 
 import (
+	"context"
+	"errors"
 	"io"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -191,6 +195,139 @@ func TestBodyParse(t *testing.T) {
 	})
 }
 
+// gatedReader only hands out the body once open is set, like a client waiting for 100 Continue.
+type gatedReader struct {
+	head, body *strings.Reader
+	open       bool
+}
+
+func (g *gatedReader) Read(p []byte) (int, error) {
+	if g.head.Len() > 0 {
+		return g.head.Read(p)
+	}
+	if !g.open {
+		return 0, errors.New("body read before the client was told to continue")
+	}
+	return g.body.Read(p)
+}
+
+func TestHeadFromReader(t *testing.T) {
+	reader := &gatedReader{
+		head: strings.NewReader("PUT /upload HTTP/1.1\r\n" +
+			"Host: localhost:8080\r\n" +
+			"Content-Length: 11\r\n" +
+			"Expect: 100-Continue\r\n" +
+			"\r\n"),
+		body: strings.NewReader("hello world"),
+	}
+
+	r, err := HeadFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "PUT", r.RequestLine.Method)
+	assert.True(t, r.ExpectsContinue())
+	assert.Empty(t, r.Body)
+
+	calls := 0
+	r.OnBodyRead(func() error {
+		calls++
+		reader.open = true
+		return nil
+	})
+	require.NoError(t, r.ReadBody())
+	assert.Equal(t, "hello world", string(r.Body))
+
+	require.NoError(t, r.ReadBody())
+	assert.Equal(t, 1, calls)
+}
+
+func TestReadBody_HookError(t *testing.T) {
+	reader := &gatedReader{
+		head: strings.NewReader("POST / HTTP/1.1\r\nContent-Length: 3\r\n\r\n"),
+		body: strings.NewReader("abc"),
+	}
+
+	r, err := HeadFromReader(reader)
+	require.NoError(t, err)
+	r.OnBodyRead(func() error { return errors.New("rejected") })
+	require.EqualError(t, r.ReadBody(), "rejected")
+	assert.Empty(t, r.Body)
+}
+
+func TestBodyReader(t *testing.T) {
+	reader := &chunkReader{
+		data:            "POST /upload HTTP/1.1\r\nHost: localhost:8080\r\nContent-Length: 11\r\n\r\nhello world",
+		numBytesPerRead: 50,
+	}
+
+	r, err := HeadFromReader(reader)
+	require.NoError(t, err)
+	require.NotEmpty(t, r.Body, "part of the body arrived with the headers")
+
+	calls, doneCalls := 0, 0
+	r.OnBodyRead(func() error {
+		calls++
+		return nil
+	})
+	r.OnBodyDone(func(start, end time.Time) {
+		doneCalls++
+		assert.False(t, end.Before(start))
+	})
+	body, err := r.BodyReader()
+	require.NoError(t, err)
+	assert.Equal(t, 0, doneCalls, "the body is not read yet")
+	data, err := io.ReadAll(body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(data))
+	assert.Empty(t, r.Body)
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, 1, doneCalls)
+
+	// a body read before is served from Body
+	r, err = RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nContent-Length: 3\r\n\r\nabc"))
+	require.NoError(t, err)
+	body, err = r.BodyReader()
+	require.NoError(t, err)
+	data, err = io.ReadAll(body)
+	require.NoError(t, err)
+	assert.Equal(t, "abc", string(data))
+
+	r, err = HeadFromReader(strings.NewReader("POST / HTTP/1.1\r\nContent-Length: 10\r\n\r\nabc"))
+	require.NoError(t, err)
+	body, err = r.BodyReader()
+	require.NoError(t, err)
+	_, err = io.ReadAll(body)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestUnread(t *testing.T) {
+	reader := &chunkReader{
+		data:            "GET /tunnel HTTP/1.1\r\nHost: localhost:8080\r\n\r\nnext bytes",
+		numBytesPerRead: 64,
+	}
+
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	rest, err := io.ReadAll(r.Unread())
+	require.NoError(t, err)
+	assert.Equal(t, "next bytes", string(rest))
+}
+
+func TestContext(t *testing.T) {
+	type key string
+	r := &Request{}
+	assert.Equal(t, context.Background(), r.Context())
+
+	r.SetValue(key("user"), "alice")
+	assert.Equal(t, "alice", r.Value(key("user")))
+	assert.Nil(t, r.Value(key("missing")))
+
+	ctx, cancel := context.WithCancel(r.Context())
+	r.SetContext(ctx)
+	cancel()
+	assert.ErrorIs(t, r.Context().Err(), context.Canceled)
+	assert.Equal(t, "alice", r.Value(key("user")))
+}
+
 func TestParseRequestLine(t *testing.T) {
 	testCases := []struct {
 		name        string
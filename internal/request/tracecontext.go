@@ -0,0 +1,186 @@
+package request
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// TraceParent is the traceparent header of W3C Trace Context: the trace a request belongs
+// to and the span that sent it.
+type TraceParent struct {
+	Version  byte
+	TraceID  [16]byte
+	ParentID [8]byte
+	Flags    byte
+}
+
+// FlagSampled is set in TraceParent.Flags when the caller may have recorded its span.
+const FlagSampled = 0x01
+
+const (
+	traceParentLength    = 55
+	maxTraceStateMembers = 32
+	maxTraceStateKey     = 256
+	maxTraceStateValue   = 256
+)
+
+// ParseTraceParent parses a traceparent header value. Versions above 00 are accepted as
+// long as they start like version 00, as the specification asks.
+func ParseTraceParent(s string) (TraceParent, error) {
+	var tp TraceParent
+	if len(s) < traceParentLength {
+		return tp, errors.New("traceparent is too short")
+	}
+	if s[2] != '-' || s[35] != '-' || s[52] != '-' ||
+		!isLowerHex(s[0:2]) || !isLowerHex(s[3:35]) || !isLowerHex(s[36:52]) || !isLowerHex(s[53:55]) {
+		return tp, errors.New("traceparent must be lowercase hex fields separated by dashes")
+	}
+
+	version, _ := hex.DecodeString(s[0:2])
+	tp.Version = version[0]
+	switch {
+	case tp.Version == 0xff:
+		return tp, errors.New("traceparent version ff is invalid")
+	case tp.Version == 0 && len(s) != traceParentLength:
+		return tp, errors.New("traceparent version 00 has extra data")
+	case len(s) > traceParentLength && s[traceParentLength] != '-':
+		return tp, errors.New("traceparent has malformed extra data")
+	}
+
+	hex.Decode(tp.TraceID[:], []byte(s[3:35]))
+	hex.Decode(tp.ParentID[:], []byte(s[36:52]))
+	flags, _ := hex.DecodeString(s[53:55])
+	tp.Flags = flags[0]
+
+	if tp.TraceID == [16]byte{} {
+		return tp, errors.New("traceparent trace-id is all zeroes")
+	}
+	if tp.ParentID == [8]byte{} {
+		return tp, errors.New("traceparent parent-id is all zeroes")
+	}
+	return tp, nil
+}
+
+// String formats tp as a version 00 traceparent, the only version this package sends.
+func (tp TraceParent) String() string {
+	return fmt.Sprintf("00-%x-%x-%02x", tp.TraceID, tp.ParentID, tp.Flags)
+}
+
+// Sampled reports whether the sampled flag is set.
+func (tp TraceParent) Sampled() bool {
+	return tp.Flags&FlagSampled != 0
+}
+
+// TraceState is the vendor-specific data of the tracestate header, most recently
+// updated member first.
+type TraceState []TraceStateMember
+
+// TraceStateMember is a single key=value entry of a TraceState.
+type TraceStateMember struct {
+	Key   string
+	Value string
+}
+
+// ParseTraceState parses a tracestate header value. Empty members are skipped, and
+// a duplicate key or a malformed member makes the whole header invalid.
+func ParseTraceState(s string) (TraceState, error) {
+	var ts TraceState
+	seen := map[string]bool{}
+	for member := range strings.SplitSeq(s, ",") {
+		member = strings.Trim(member, " \t")
+		if member == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(member, "=")
+		if !ok || !validTraceStateKey(key) || !validTraceStateValue(value) {
+			return nil, fmt.Errorf("invalid tracestate member %q", member)
+		}
+		if seen[key] {
+			return nil, fmt.Errorf("duplicate tracestate key %q", key)
+		}
+		seen[key] = true
+		ts = append(ts, TraceStateMember{Key: key, Value: value})
+	}
+	if len(ts) > maxTraceStateMembers {
+		return nil, fmt.Errorf("tracestate has %d members, at most %d are allowed", len(ts), maxTraceStateMembers)
+	}
+	return ts, nil
+}
+
+func (ts TraceState) String() string {
+	parts := make([]string, len(ts))
+	for i, m := range ts {
+		parts[i] = m.Key + "=" + m.Value
+	}
+	return strings.Join(parts, ",")
+}
+
+// TraceParent returns the request's traceparent, reporting false when it is missing or invalid.
+func (r *Request) TraceParent() (TraceParent, bool) {
+	tp, err := ParseTraceParent(r.Headers.Get("traceparent"))
+	return tp, err == nil
+}
+
+// TraceState returns the request's tracestate. It is nil when the header is invalid or
+// when there is no valid traceparent, in which case the tracestate must be ignored.
+func (r *Request) TraceState() TraceState {
+	if _, ok := r.TraceParent(); !ok {
+		return nil
+	}
+	ts, err := ParseTraceState(r.Headers.Get("tracestate"))
+	if err != nil {
+		return nil
+	}
+	return ts
+}
+
+// validTraceStateKey checks a simple key ("vendor") or a multi-tenant one ("tenant@vendor").
+func validTraceStateKey(key string) bool {
+	if len(key) == 0 || len(key) > maxTraceStateKey {
+		return false
+	}
+	tenant, system, multi := strings.Cut(key, "@")
+	if !multi {
+		return validKeyPart(key, true)
+	}
+	return len(tenant) <= 241 && len(system) <= 14 && validKeyPart(tenant, false) && validKeyPart(system, true)
+}
+
+func validKeyPart(s string, letterFirst bool) bool {
+	if s == "" {
+		return false
+	}
+	for i, c := range []byte(s) {
+		switch {
+		case c >= 'a' && c <= 'z':
+		case c >= '0' && c <= '9' && (i > 0 || !letterFirst):
+		case i > 0 && (c == '_' || c == '-' || c == '*' || c == '/'):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func validTraceStateValue(value string) bool {
+	if len(value) == 0 || len(value) > maxTraceStateValue || value[len(value)-1] == ' ' {
+		return false
+	}
+	for _, c := range []byte(value) {
+		if c < 0x20 || c > 0x7e || c == ',' || c == '=' {
+			return false
+		}
+	}
+	return true
+}
+
+func isLowerHex(s string) bool {
+	for _, c := range []byte(s) {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
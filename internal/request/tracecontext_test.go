@@ -0,0 +1,104 @@
+package request
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/abdo-355/http-from-tcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const validTraceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func TestParseTraceParent(t *testing.T) {
+	testCases := []struct {
+		name    string
+		value   string
+		wantErr bool
+	}{
+		{name: "Valid", value: validTraceParent},
+		{name: "Not sampled", value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00"},
+		{name: "Future version with extra data", value: "cc-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-what-the-future"},
+		{name: "Version 00 with extra data", value: validTraceParent + "-extra", wantErr: true},
+		{name: "Version ff", value: "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", wantErr: true},
+		{name: "Uppercase", value: "00-4BF92F3577B34DA6A3CE929D0E0E4736-00F067AA0BA902B7-01", wantErr: true},
+		{name: "Zero trace-id", value: "00-00000000000000000000000000000000-00f067aa0ba902b7-01", wantErr: true},
+		{name: "Zero parent-id", value: "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", wantErr: true},
+		{name: "Dash inside a field", value: "00-4bf92f3577b34da6a3ce929d0e0e47-6-00f067aa0ba902b7-01", wantErr: true},
+		{name: "Too short", value: "00-4bf92f3577b34da6-00f067aa0ba902b7-01", wantErr: true},
+		{name: "Empty", value: "", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tp, err := ParseTraceParent(tc.value)
+			if tc.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			// always sent as version 00
+			assert.Equal(t, "00"+tc.value[2:55], tp.String())
+		})
+	}
+
+	tp, err := ParseTraceParent(validTraceParent)
+	require.NoError(t, err)
+	assert.True(t, tp.Sampled())
+	assert.Equal(t, validTraceParent, tp.String())
+}
+
+func TestParseTraceState(t *testing.T) {
+	testCases := []struct {
+		name    string
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{name: "Single", value: "congo=t61rcWkgMzE", want: "congo=t61rcWkgMzE"},
+		{name: "Several with spaces", value: "rojo=00f067aa0ba902b7 , congo=t61rcWkgMzE", want: "rojo=00f067aa0ba902b7,congo=t61rcWkgMzE"},
+		{name: "Multi-tenant key", value: "fw529a3039@dt=abc", want: "fw529a3039@dt=abc"},
+		{name: "Empty members", value: "a=1,,b=2", want: "a=1,b=2"},
+		{name: "Empty", value: "", want: ""},
+		{name: "Uppercase key", value: "Rojo=1", wantErr: true},
+		{name: "Missing value", value: "rojo", wantErr: true},
+		{name: "Value with equals", value: "rojo=a=b", wantErr: true},
+		{name: "Duplicate key", value: "rojo=1,rojo=2", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ts, err := ParseTraceState(tc.value)
+			if tc.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, ts.String())
+		})
+	}
+
+	var members []string
+	for i := range 33 {
+		members = append(members, "k"+strings.Repeat("x", i)+"=1")
+	}
+	_, err := ParseTraceState(strings.Join(members, ","))
+	assert.Error(t, err, "more than 32 members")
+}
+
+func TestRequest_TraceContext(t *testing.T) {
+	h := headers.NewHeaders()
+	h.Set("tracestate", "rojo=00f067aa0ba902b7")
+	r := &Request{Headers: h}
+
+	_, ok := r.TraceParent()
+	assert.False(t, ok)
+	assert.Nil(t, r.TraceState(), "tracestate without a valid traceparent is ignored")
+
+	h.Set("traceparent", validTraceParent)
+	tp, ok := r.TraceParent()
+	require.True(t, ok)
+	assert.Equal(t, validTraceParent, tp.String())
+	assert.Equal(t, TraceState{{Key: "rojo", Value: "00f067aa0ba902b7"}}, r.TraceState())
+}
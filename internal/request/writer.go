@@ -0,0 +1,55 @@
+package request
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/abdo-355/http-from-tcp/internal/headers"
+)
+
+type WriterState int
+
+const (
+	WriteRequestLine WriterState = iota
+	WriteHeaders
+	WriteBody
+)
+
+// Writer serializes an HTTP request to w, mirroring response.Writer on the client side.
+type Writer struct {
+	w     io.Writer
+	State WriterState
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+func (w *Writer) WriteRequestLine(method, target string) error {
+	if w.State != WriteRequestLine {
+		panic("invalid operations order. make sure this is run first")
+	}
+	_, err := fmt.Fprintf(w.w, "%s %s HTTP/1.1\r\n", method, target)
+	w.State = WriteHeaders
+	return err
+}
+
+func (w *Writer) WriteHeaders(h headers.Headers) error {
+	if w.State != WriteHeaders {
+		panic("invalid operations order. make sure this runs after writing the request line and before writing the body")
+	}
+	if err := h.Write(w.w); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w.w, "\r\n")
+	w.State = WriteBody
+	return err
+}
+
+func (w *Writer) WriteBody(b []byte) error {
+	if w.State != WriteBody {
+		panic("invalid operations order. make sure this runs last")
+	}
+	_, err := w.w.Write(b)
+	return err
+}
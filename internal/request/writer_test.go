@@ -0,0 +1,33 @@
+package request
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/abdo-355/http-from-tcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+
+	require.NoError(t, w.WriteRequestLine("POST", "/submit"))
+	require.NoError(t, w.WriteHeaders(headers.Headers{M: map[string]string{"host": "example.com"}}))
+	require.NoError(t, w.WriteBody([]byte("hello")))
+	assert.Equal(t, "POST /submit HTTP/1.1\r\nhost: example.com\r\n\r\nhello", buf.String())
+
+	// what the writer produces is what the parser accepts
+	r, err := RequestFromReader(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, "POST", r.RequestLine.Method)
+	assert.Equal(t, "/submit", r.RequestLine.RequestTarget)
+	assert.Equal(t, "example.com", r.Headers.Get("host"))
+}
+
+func TestWriter_InvalidOrder(t *testing.T) {
+	w := NewWriter(new(bytes.Buffer))
+	assert.Panics(t, func() { w.WriteBody([]byte("x")) })
+	assert.Panics(t, func() { w.WriteHeaders(headers.NewHeaders()) })
+}
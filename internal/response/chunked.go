@@ -0,0 +1,132 @@
+package response
+
+import (
+	"fmt"
+	"hash"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/abdo-355/http-from-tcp/internal/headers"
+)
+
+// BodyWriter writes the headers and returns a writer for the body. When h carries a
+// Content-Length the body is written as is and must have exactly that length, otherwise
+// Transfer-Encoding: chunked is set and every Write becomes a chunk.
+// Closing the returned writer ends the body.
+func (w *Writer) BodyWriter(h headers.Headers) io.WriteCloser {
+	if cl := h.Get("content-length"); cl != "" && h.Get("transfer-encoding") == "" {
+		n, err := strconv.Atoi(cl)
+		if err == nil && n >= 0 {
+			w.WriteHeaders(h)
+			return &fixedWriter{w: w, remaining: n}
+		}
+	}
+
+	h = h.Clone()
+	h.Del("content-length")
+	h.Set("transfer-encoding", "chunked")
+	w.WriteHeaders(h)
+	return &ChunkedWriter{w: w, trailers: headers.NewHeaders()}
+}
+
+// ChunkedWriter writes a chunked body to a Writer. Trailers set on it are sent by Close,
+// but only those declared in the response's Trailer header.
+type ChunkedWriter struct {
+	w        *Writer
+	trailers headers.Headers
+	closed   bool
+}
+
+// NewChunkedWriter returns a ChunkedWriter for a response whose headers, including
+// Transfer-Encoding: chunked, were already written.
+func NewChunkedWriter(w *Writer) *ChunkedWriter {
+	return &ChunkedWriter{w: w, trailers: headers.NewHeaders()}
+}
+
+func (cw *ChunkedWriter) Write(p []byte) (int, error) {
+	if cw.closed {
+		return 0, fmt.Errorf("write on closed chunked body")
+	}
+	if _, err := cw.w.WriteChunkedBody(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// SetTrailer records a trailer field to send when the body is closed.
+func (cw *ChunkedWriter) SetTrailer(key, value string) {
+	cw.trailers.SetTrailer(key, value)
+}
+
+// Close writes the last chunk followed by the declared trailers.
+func (cw *ChunkedWriter) Close() error {
+	if cw.closed {
+		return nil
+	}
+	cw.closed = true
+
+	if _, err := cw.w.WriteChunkedBodyDone(); err != nil {
+		return err
+	}
+
+	declared := map[string]bool{}
+	for _, name := range strings.Split(cw.w.header.Get("trailer"), ",") {
+		declared[strings.ToLower(strings.TrimSpace(name))] = true
+	}
+	t := headers.NewHeaders()
+	for k, v := range cw.trailers.M {
+		if declared[strings.ToLower(k)] {
+			t.SetTrailer(k, v)
+		}
+	}
+	return cw.w.WriteTrailers(t)
+}
+
+// fixedWriter writes a body whose length was announced with Content-Length.
+type fixedWriter struct {
+	w         *Writer
+	remaining int
+	closed    bool
+}
+
+func (fw *fixedWriter) Write(p []byte) (int, error) {
+	if fw.closed {
+		return 0, fmt.Errorf("write on closed body")
+	}
+	if len(p) > fw.remaining {
+		return 0, fmt.Errorf("body exceeds the declared content-length by %d bytes", len(p)-fw.remaining)
+	}
+	fw.w.WriteBody(p)
+	fw.remaining -= len(p)
+	return len(p), nil
+}
+
+func (fw *fixedWriter) Close() error {
+	if fw.closed {
+		return nil
+	}
+	fw.closed = true
+	if fw.remaining > 0 {
+		return fmt.Errorf("body is %d bytes shorter than the declared content-length", fw.remaining)
+	}
+	return nil
+}
+
+// HashWriter feeds everything written to the body into a hash, so handlers can
+// compute a digest of what they send (e.g. for a trailer) without buffering it.
+type HashWriter struct {
+	io.WriteCloser
+	Hash hash.Hash
+}
+
+// NewHashWriter wraps wc so that all written bytes also go through h.
+func NewHashWriter(wc io.WriteCloser, h hash.Hash) *HashWriter {
+	return &HashWriter{WriteCloser: wc, Hash: h}
+}
+
+func (hw *HashWriter) Write(p []byte) (int, error) {
+	n, err := hw.WriteCloser.Write(p)
+	hw.Hash.Write(p[:n])
+	return n, err
+}
@@ -0,0 +1,91 @@
+package response
+
+import (
+	"crypto/sha256"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/abdo-355/http-from-tcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBodyWriter_Chunked(t *testing.T) {
+	w := New()
+	w.WriteStatusLine("HTTP/1.1", http.StatusOK, "OK")
+	bw := w.BodyWriter(headers.Headers{M: map[string]string{"trailer": "X-Sum"}})
+
+	cw, ok := bw.(*ChunkedWriter)
+	require.True(t, ok)
+	_, err := cw.Write([]byte("hello "))
+	require.NoError(t, err)
+	_, err = cw.Write([]byte("world"))
+	require.NoError(t, err)
+	cw.SetTrailer("X-Sum", "abc")
+	cw.SetTrailer("X-Undeclared", "dropped")
+	require.NoError(t, cw.Close())
+
+	out := string(w.Bytes())
+	assert.Contains(t, out, "transfer-encoding: chunked\r\n")
+	assert.True(t, strings.HasSuffix(out, "6\r\nhello \r\n5\r\nworld\r\n0\r\nX-Sum: abc\r\n\r\n"))
+	assert.Equal(t, Done, w.State)
+
+	_, err = cw.Write([]byte("late"))
+	require.Error(t, err)
+}
+
+func TestBodyWriter_FixedLength(t *testing.T) {
+	w := New()
+	w.WriteStatusLine("HTTP/1.1", http.StatusOK, "OK")
+	bw := w.BodyWriter(GetDefaultHeaders(5))
+
+	_, err := bw.Write([]byte("hel"))
+	require.NoError(t, err)
+	_, err = bw.Write([]byte("looo"))
+	require.Error(t, err)
+	_, err = bw.Write([]byte("lo"))
+	require.NoError(t, err)
+	require.NoError(t, bw.Close())
+
+	assert.False(t, w.IsChunked())
+	assert.Equal(t, "hello", string(w.Body()))
+
+	w = New()
+	w.WriteStatusLine("HTTP/1.1", http.StatusOK, "OK")
+	bw = w.BodyWriter(GetDefaultHeaders(5))
+	require.Error(t, bw.Close())
+}
+
+func TestWriteHeaders_DropsContentLengthWhenChunked(t *testing.T) {
+	w := New()
+	w.WriteStatusLine("HTTP/1.1", http.StatusOK, "OK")
+	h := headers.Headers{M: map[string]string{"content-length": "10", "transfer-encoding": "chunked"}}
+	w.WriteHeaders(h)
+
+	assert.NotContains(t, string(w.Bytes()), "content-length")
+	// the caller's headers are left untouched
+	assert.Equal(t, "10", h.Get("content-length"))
+}
+
+func TestWriteBody_AfterChunkPanics(t *testing.T) {
+	w := New()
+	w.State = WriteBody
+	_, err := w.WriteChunkedBody([]byte("a"))
+	require.NoError(t, err)
+	assert.Panics(t, func() { w.WriteBody([]byte("b")) })
+}
+
+func TestHashWriter(t *testing.T) {
+	w := New()
+	w.WriteStatusLine("HTTP/1.1", http.StatusOK, "OK")
+	hw := NewHashWriter(w.BodyWriter(headers.NewHeaders()), sha256.New())
+
+	_, err := hw.Write([]byte("hello world"))
+	require.NoError(t, err)
+	require.NoError(t, hw.Close())
+
+	expected := sha256.Sum256([]byte("hello world"))
+	assert.Equal(t, expected[:], hw.Hash.Sum(nil))
+	assert.Equal(t, "hello world", string(w.Body()))
+}
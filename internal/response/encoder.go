@@ -0,0 +1,41 @@
+package response
+
+import (
+	"io"
+
+	"github.com/abdo-355/http-from-tcp/internal/headers"
+)
+
+// Encoder applies a content coding (like gzip) to a body as it is written. Flush pushes
+// out what it holds so far, so flushed bytes reach the client right away.
+type Encoder interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// EncoderFunc decides, when a response starts streaming, whether its body gets a content
+// coding. It may change the headers h, and returns an Encoder writing the encoded body
+// to dst, or nil to send the body as it is.
+type EncoderFunc func(status int, h headers.Headers, dst io.Writer) Encoder
+
+// SetEncoder makes a recorder encode its body with the Encoder fn returns once it is
+// flushed and streams the rest of the response (see Streaming). The body is then sent in
+// chunks, and the Encoder is closed when the body ends.
+func (w *Writer) SetEncoder(fn EncoderFunc) {
+	w.encoder = fn
+}
+
+// chunkSink writes what an Encoder produces as chunks of w's body.
+type chunkSink struct {
+	w *Writer
+}
+
+func (s chunkSink) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if _, err := s.w.writeChunk(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
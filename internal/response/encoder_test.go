@@ -0,0 +1,79 @@
+package response
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/abdo-355/http-from-tcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// upperEncoder upper-cases the body and ends it with "!".
+type upperEncoder struct {
+	dst     io.Writer
+	flushes int
+}
+
+func (e *upperEncoder) Write(p []byte) (int, error) {
+	return e.dst.Write([]byte(strings.ToUpper(string(p))))
+}
+
+func (e *upperEncoder) Flush() error {
+	e.flushes++
+	return nil
+}
+
+func (e *upperEncoder) Close() error {
+	_, err := e.dst.Write([]byte("!"))
+	return err
+}
+
+func TestSetEncoder(t *testing.T) {
+	testCases := []struct {
+		name   string
+		encode bool
+		want   string
+	}{
+		{name: "Encoded", encode: true, want: "HELLO WORLD!"},
+		{name: "Left as is", want: "hello world"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var out bytes.Buffer
+			w := NewWriter(&out)
+			rec := w.Recorder()
+			enc := &upperEncoder{}
+			rec.SetEncoder(func(status int, h headers.Headers, dst io.Writer) Encoder {
+				if !tc.encode {
+					return nil
+				}
+				h.Set("content-encoding", "upper")
+				enc.dst = dst
+				return enc
+			})
+
+			h := headers.NewHeaders()
+			h.Set("content-length", "11")
+			rec.WriteStatusLine("HTTP/1.1", http.StatusOK, "OK")
+			rec.WriteHeaders(h)
+			rec.WriteBody([]byte("hello "))
+			require.NoError(t, rec.Flush())
+			rec.WriteBody([]byte("world"))
+			require.NoError(t, w.Finish())
+
+			res, err := FromReader(&out)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, string(res.Body))
+			if tc.encode {
+				assert.Equal(t, "upper", res.Headers.Get("content-encoding"))
+				assert.Equal(t, "", res.Headers.Get("content-length"))
+				assert.Equal(t, 1, enc.flushes)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package response
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// StrongETag formats a digest (e.g. a SHA-256 sum) as a strong entity tag.
+func StrongETag(sum []byte) string {
+	return `"` + hex.EncodeToString(sum) + `"`
+}
+
+// WeakETag formats a digest as a weak entity tag.
+func WeakETag(sum []byte) string {
+	return "W/" + StrongETag(sum)
+}
+
+// ContentETag returns a strong entity tag derived from the SHA-256 of the body.
+func ContentETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return StrongETag(sum[:])
+}
+
+// IsWeakETag reports whether the entity tag carries the W/ prefix.
+func IsWeakETag(etag string) bool {
+	return strings.HasPrefix(etag, "W/")
+}
+
+func opaqueTag(etag string) string {
+	return strings.TrimPrefix(etag, "W/")
+}
+
+// ETagStrongMatch uses the strong comparison function from RFC 9110 section 8.8.3.2:
+// both tags have to be strong and their opaque parts identical.
+func ETagStrongMatch(a, b string) bool {
+	if IsWeakETag(a) || IsWeakETag(b) {
+		return false
+	}
+	return a != "" && a == b
+}
+
+// ETagWeakMatch uses the weak comparison function: the W/ prefix is ignored.
+func ETagWeakMatch(a, b string) bool {
+	return a != "" && opaqueTag(a) == opaqueTag(b)
+}
+
+// ParseETagList splits an If-Match / If-None-Match value into its entity tags.
+// A lone "*" is returned as is. Malformed members are skipped.
+func ParseETagList(value string) []string {
+	value = strings.TrimSpace(value)
+	if value == "*" {
+		return []string{"*"}
+	}
+
+	var tags []string
+	for value != "" {
+		value = strings.TrimLeft(value, " \t,")
+		if value == "" {
+			break
+		}
+
+		prefix := ""
+		if strings.HasPrefix(value, "W/") {
+			prefix = "W/"
+			value = value[2:]
+		}
+		if !strings.HasPrefix(value, `"`) {
+			// not an entity tag, skip to the next member
+			idx := strings.IndexByte(value, ',')
+			if idx == -1 {
+				break
+			}
+			value = value[idx+1:]
+			continue
+		}
+
+		end := strings.IndexByte(value[1:], '"')
+		if end == -1 {
+			break
+		}
+		tags = append(tags, prefix+value[:end+2])
+		value = value[end+2:]
+	}
+
+	return tags
+}
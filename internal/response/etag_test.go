@@ -0,0 +1,62 @@
+package response
+
+import (
+	"crypto/sha256"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestETagFormatting(t *testing.T) {
+	sum := sha256.Sum256([]byte("hello"))
+	strong := StrongETag(sum[:])
+
+	assert.Equal(t, `"2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"`, strong)
+	assert.Equal(t, "W/"+strong, WeakETag(sum[:]))
+	assert.Equal(t, strong, ContentETag([]byte("hello")))
+	assert.False(t, IsWeakETag(strong))
+	assert.True(t, IsWeakETag(WeakETag(sum[:])))
+}
+
+func TestETagComparison(t *testing.T) {
+	testCases := []struct {
+		name   string
+		a, b   string
+		strong bool
+		weak   bool
+	}{
+		{name: "Same strong tags", a: `"1"`, b: `"1"`, strong: true, weak: true},
+		{name: "Weak and strong", a: `W/"1"`, b: `"1"`, strong: false, weak: true},
+		{name: "Both weak", a: `W/"1"`, b: `W/"1"`, strong: false, weak: true},
+		{name: "Different tags", a: `"1"`, b: `"2"`, strong: false, weak: false},
+		{name: "Empty tag", a: "", b: "", strong: false, weak: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.strong, ETagStrongMatch(tc.a, tc.b))
+			assert.Equal(t, tc.weak, ETagWeakMatch(tc.a, tc.b))
+		})
+	}
+}
+
+func TestParseETagList(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		expected []string
+	}{
+		{name: "Wildcard", input: " * ", expected: []string{"*"}},
+		{name: "Single tag", input: `"abc"`, expected: []string{`"abc"`}},
+		{name: "Mixed list", input: `"a", W/"b",  "c"`, expected: []string{`"a"`, `W/"b"`, `"c"`}},
+		{name: "Comma inside tag", input: `"a,b", "c"`, expected: []string{`"a,b"`, `"c"`}},
+		{name: "Skips malformed members", input: `abc, "d"`, expected: []string{`"d"`}},
+		{name: "Unterminated tag", input: `"abc`, expected: nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, ParseETagList(tc.input))
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package response
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/abdo-355/http-from-tcp/internal/headers"
+)
+
+// Flush sends everything written so far. The first flush commits the headers: when the
+// handler gave no Content-Length the body switches to chunked encoding so it can keep
+// streaming. Flush does nothing on a Writer created with New.
+func (w *Writer) Flush() error {
+	if w.child != nil {
+		return w.child.Flush()
+	}
+	if w.dst == nil && w.parent != nil {
+		if err := w.attach(); err != nil {
+			return err
+		}
+	}
+	if w.dst == nil || w.hijacked || w.aborted {
+		return nil
+	}
+	w.commit(false)
+	if w.enc != nil {
+		if err := w.enc.Flush(); err != nil {
+			return err
+		}
+	}
+	return w.send()
+}
+
+// Finish completes the response once the handler is done: the headers get their final
+// framing (Content-Length when the whole body is buffered), a chunked body that was left
+// open is terminated, and everything still buffered is sent.
+func (w *Writer) Finish() error {
+	if w.child != nil {
+		return w.child.Finish()
+	}
+	if w.hijacked || w.aborted {
+		return nil
+	}
+	w.commit(true)
+
+	switch {
+	case w.chunked && w.State == WriteBody:
+		if _, err := w.WriteChunkedBodyDone(); err != nil {
+			return err
+		}
+		if err := w.WriteTrailers(headers.NewHeaders()); err != nil {
+			return err
+		}
+	case w.chunked && w.State == WriteTrailers:
+		if err := w.WriteTrailers(headers.NewHeaders()); err != nil {
+			return err
+		}
+	case w.State == WriteBody:
+		w.State = Done
+	}
+
+	if w.dst == nil {
+		return nil
+	}
+	return w.send()
+}
+
+func (w *Writer) send() error {
+	_, err := w.dst.Write(w.buffer.Bytes())
+	w.buffer.Reset()
+	w.bodyStart = 0
+	return err
+}
+
+// commit fills in the framing, Date and Server headers and re-renders the head of the
+// response with them. final tells whether the whole body is already buffered.
+func (w *Writer) commit(final bool) {
+	if w.committed {
+		return
+	}
+	if w.State == WriteStatusLine {
+		w.WriteStatusLine("HTTP/1.1", http.StatusOK, "OK")
+	}
+	if w.State == WriteHeaders {
+		w.WriteHeaders(headers.NewHeaders())
+	}
+	w.committed = true
+
+	h := w.header.Clone()
+	body := bytes.Clone(w.buffer.Bytes()[w.bodyStart:])
+
+	var enc Encoder
+	if w.encoder != nil && w.State == WriteBody && bodyAllowed(w.statusCode) {
+		if enc = w.encoder(w.statusCode, h, chunkSink{w}); enc != nil {
+			// the encoded length is only known once the body ends
+			body = bytes.Clone(w.Body())
+			h.Del("content-length")
+			h.Set("transfer-encoding", "chunked")
+			w.autoChunk = true
+		}
+	}
+
+	te := h.Get("transfer-encoding")
+	switch {
+	case !bodyAllowed(w.statusCode):
+		// 1xx and 204 responses must not announce a body at all
+		if w.statusCode != http.StatusNotModified {
+			h.Del("content-length")
+		}
+		h.Del("transfer-encoding")
+	case w.chunked || strings.HasSuffix(strings.ToLower(te), "chunked"):
+		w.chunked = true
+		h.Del("content-length")
+		if te == "" {
+			h.Set("transfer-encoding", "chunked")
+		}
+	case h.Get("content-length") != "" || te != "":
+	case final:
+		h.Set("content-length", strconv.Itoa(len(body)+w.omitted))
+	default:
+		h.Set("transfer-encoding", "chunked")
+		w.autoChunk = true
+	}
+	if h.Get("date") == "" {
+		h.Set("date", httpDate(time.Now()))
+	}
+	if w.ServerName != "" && h.Get("server") == "" {
+		h.Set("server", w.ServerName)
+	}
+
+	w.buffer.Reset()
+	fmt.Fprintf(w.buffer, "%s %d %s\r\n", w.proto, w.statusCode, w.statusText)
+	h.Write(w.buffer)
+	w.buffer.WriteString("\r\n")
+	w.header = h
+	w.bodyStart = w.buffer.Len()
+
+	if w.autoChunk {
+		w.chunked = true
+		w.enc = enc
+		w.WriteChunkedBody(body)
+	} else if !w.bodyOmitted() {
+		w.buffer.Write(body)
+	}
+}
+
+type cachedDate struct {
+	unix  int64
+	value string
+}
+
+var lastDate atomic.Pointer[cachedDate]
+
+// httpDate formats now as an IMF-fixdate. The value only changes once per second,
+// so it is cached instead of being formatted for every response.
+func httpDate(now time.Time) string {
+	unix := now.Unix()
+	if d := lastDate.Load(); d != nil && d.unix == unix {
+		return d.value
+	}
+	d := &cachedDate{unix: unix, value: now.UTC().Format(http.TimeFormat)}
+	lastDate.Store(d)
+	return d.value
+}
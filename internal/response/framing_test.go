@@ -0,0 +1,285 @@
+package response
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abdo-355/http-from-tcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFinish_FillsContentLengthAndDate(t *testing.T) {
+	var out bytes.Buffer
+	w := NewWriter(&out)
+	w.ServerName = "test-server"
+	w.WriteStatusLine("HTTP/1.1", http.StatusOK, "OK")
+	w.WriteHeaders(headers.Headers{M: map[string]string{"content-type": "text/html"}})
+	w.WriteBody([]byte("<p>hi</p>"))
+	require.NoError(t, w.Finish())
+
+	res, err := FromReader(&out)
+	require.NoError(t, err)
+	assert.Equal(t, "9", res.Headers.Get("content-length"))
+	assert.Equal(t, "test-server", res.Headers.Get("server"))
+	assert.Equal(t, "<p>hi</p>", string(res.Body))
+
+	date, err := http.ParseTime(res.Headers.Get("date"))
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), date, 2*time.Second)
+}
+
+func TestFinish_KeepsHandlerHeaders(t *testing.T) {
+	w := New()
+	w.ServerName = "test-server"
+	w.WriteStatusLine("HTTP/1.1", http.StatusOK, "OK")
+	w.WriteHeaders(headers.Headers{M: map[string]string{
+		"content-length": "2",
+		"date":           "Mon, 01 Jan 2024 00:00:00 GMT",
+		"server":         "custom",
+	}})
+	w.WriteBody([]byte("ok"))
+	require.NoError(t, w.Finish())
+
+	h := w.Header()
+	assert.Equal(t, "2", h.Get("content-length"))
+	assert.Equal(t, "Mon, 01 Jan 2024 00:00:00 GMT", h.Get("date"))
+	assert.Equal(t, "custom", h.Get("server"))
+}
+
+func TestFinish_EmptyHandler(t *testing.T) {
+	var out bytes.Buffer
+	w := NewWriter(&out)
+	require.NoError(t, w.Finish())
+
+	res, err := FromReader(&out)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "0", res.Headers.Get("content-length"))
+}
+
+func TestFinish_NoContentHasNoFraming(t *testing.T) {
+	w := New()
+	w.WriteStatusLine("HTTP/1.1", http.StatusNoContent, "No Content")
+	w.WriteHeaders(headers.Headers{M: map[string]string{"content-length": "0"}})
+	require.NoError(t, w.Finish())
+
+	assert.NotContains(t, string(w.Bytes()), "content-length")
+	assert.NotContains(t, string(w.Bytes()), "transfer-encoding")
+}
+
+func TestFlush_SwitchesToChunked(t *testing.T) {
+	var out bytes.Buffer
+	w := NewWriter(&out)
+	w.WriteStatusLine("HTTP/1.1", http.StatusOK, "OK")
+	w.WriteHeaders(headers.Headers{M: map[string]string{"content-type": "text/plain"}})
+	w.WriteBody([]byte("first "))
+	require.NoError(t, w.Flush())
+
+	// the head and the first chunk are on the wire before the handler finishes
+	assert.Contains(t, out.String(), "transfer-encoding: chunked\r\n")
+	assert.True(t, strings.HasSuffix(out.String(), "6\r\nfirst \r\n"))
+
+	w.WriteBody([]byte("second"))
+	require.NoError(t, w.Flush())
+	require.NoError(t, w.Finish())
+
+	res, err := FromReader(&out)
+	require.NoError(t, err)
+	assert.Equal(t, "first second", string(res.Body))
+	assert.Equal(t, "", res.Headers.Get("content-length"))
+}
+
+func TestWriteBody_DeclaredChunked(t *testing.T) {
+	var out bytes.Buffer
+	w := NewWriter(&out)
+	w.WriteStatusLine("HTTP/1.1", http.StatusOK, "OK")
+	w.WriteHeaders(headers.Headers{M: map[string]string{"transfer-encoding": "chunked"}})
+	w.WriteBody([]byte("hello"))
+	w.WriteBody([]byte(" world"))
+	require.NoError(t, w.Finish())
+
+	assert.Contains(t, out.String(), "\r\n\r\n5\r\nhello\r\n6\r\n world\r\n0\r\n\r\n")
+	res, err := FromReader(&out)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(res.Body))
+}
+
+func TestFinish_ClosesChunkedBody(t *testing.T) {
+	var out bytes.Buffer
+	w := NewWriter(&out)
+	w.WriteStatusLine("HTTP/1.1", http.StatusOK, "OK")
+	w.WriteHeaders(headers.Headers{M: map[string]string{"transfer-encoding": "chunked"}})
+	_, err := w.WriteChunkedBody([]byte("data"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+
+	assert.True(t, strings.HasSuffix(out.String(), "4\r\ndata\r\n0\r\n\r\n"))
+	assert.Equal(t, Done, w.State)
+}
+
+func TestFlush_WithoutDestination(t *testing.T) {
+	w := New()
+	w.WriteStatusLine("HTTP/1.1", http.StatusOK, "OK")
+	w.WriteHeaders(headers.NewHeaders())
+	w.WriteBody([]byte("buffered"))
+	require.NoError(t, w.Flush())
+
+	assert.Equal(t, "buffered", string(w.Body()))
+	assert.NotContains(t, string(w.Bytes()), "transfer-encoding")
+}
+
+func TestHTTPDate(t *testing.T) {
+	now := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	assert.Equal(t, "Mon, 06 May 2024 07:08:09 GMT", httpDate(now))
+	assert.Equal(t, "Mon, 06 May 2024 07:08:09 GMT", httpDate(now.Add(500*time.Millisecond)))
+	assert.Equal(t, "Mon, 06 May 2024 07:08:10 GMT", httpDate(now.Add(time.Second)))
+}
+
+func TestOmitBody(t *testing.T) {
+	t.Run("Keeps the computed Content-Length", func(t *testing.T) {
+		var out bytes.Buffer
+		w := NewWriter(&out)
+		w.OmitBody()
+		w.WriteStatusLine("HTTP/1.1", http.StatusOK, "OK")
+		w.WriteHeaders(headers.NewHeaders())
+		w.WriteBody([]byte("hello "))
+		w.WriteBody([]byte("world"))
+		require.NoError(t, w.Finish())
+
+		assert.Contains(t, out.String(), "content-length: 11\r\n")
+		assert.True(t, strings.HasSuffix(out.String(), "\r\n\r\n"))
+		assert.NotContains(t, out.String(), "hello")
+	})
+
+	t.Run("Chunked body sends no chunks", func(t *testing.T) {
+		var out bytes.Buffer
+		w := NewWriter(&out)
+		w.OmitBody()
+		w.WriteStatusLine("HTTP/1.1", http.StatusOK, "OK")
+		cw := w.BodyWriter(headers.Headers{M: map[string]string{"trailer": "X-Sum"}}).(*ChunkedWriter)
+		_, err := cw.Write([]byte("data"))
+		require.NoError(t, err)
+		cw.SetTrailer("X-Sum", "1")
+		require.NoError(t, cw.Close())
+		require.NoError(t, w.Finish())
+
+		assert.Contains(t, out.String(), "transfer-encoding: chunked\r\n")
+		assert.True(t, strings.HasSuffix(out.String(), "\r\n\r\n"))
+		assert.NotContains(t, out.String(), "data")
+		assert.NotContains(t, out.String(), "X-Sum: 1")
+	})
+}
+
+func TestBodilessStatuses(t *testing.T) {
+	testCases := []struct {
+		name   string
+		status int
+		text   string
+	}{
+		{name: "No Content", status: http.StatusNoContent, text: "No Content"},
+		{name: "Not Modified", status: http.StatusNotModified, text: "Not Modified"},
+		{name: "Informational", status: http.StatusProcessing, text: "Processing"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var out bytes.Buffer
+			w := NewWriter(&out)
+			w.WriteStatusLine("HTTP/1.1", tc.status, tc.text)
+			w.WriteHeaders(headers.NewHeaders())
+			w.WriteBody([]byte("should not be sent"))
+			require.NoError(t, w.Finish())
+
+			assert.True(t, strings.HasSuffix(out.String(), "\r\n\r\n"))
+			assert.NotContains(t, out.String(), "should not be sent")
+			assert.NotContains(t, out.String(), "content-length")
+		})
+	}
+}
+
+func TestSetAndAddHeader(t *testing.T) {
+	t.Run("Before the handler writes its headers", func(t *testing.T) {
+		var out bytes.Buffer
+		w := NewWriter(&out)
+		w.SetHeader("X-Frame-Options", "DENY")
+		w.AddHeader("Vary", "Origin")
+		w.AddHeader("Vary", "accept-encoding")
+
+		h := headers.NewHeaders()
+		h.Set("x-frame-options", "SAMEORIGIN")
+		h.Set("vary", "Accept-Encoding")
+		w.WriteStatusLine("HTTP/1.1", http.StatusOK, "OK")
+		w.WriteHeaders(h)
+		require.NoError(t, w.Finish())
+
+		assert.Contains(t, out.String(), "x-frame-options: DENY\r\n")
+		assert.Contains(t, out.String(), "vary: Accept-Encoding, Origin\r\n")
+		assert.Equal(t, "SAMEORIGIN", h.Get("x-frame-options"))
+	})
+
+	t.Run("Only token lists are deduplicated", func(t *testing.T) {
+		var out bytes.Buffer
+		w := NewWriter(&out)
+		w.AddHeader("set-cookie", "a=1")
+		w.AddHeader("set-cookie", "a=1")
+		w.AddHeader("x-list", "a")
+		w.AddHeader("x-list", "a")
+		w.WriteStatusLine("HTTP/1.1", http.StatusOK, "OK")
+		w.WriteHeaders(headers.NewHeaders())
+		require.NoError(t, w.Finish())
+
+		assert.Equal(t, 2, strings.Count(out.String(), "set-cookie: a=1\r\n"))
+		assert.Contains(t, out.String(), "x-list: a, a\r\n")
+	})
+
+	t.Run("After the headers were written", func(t *testing.T) {
+		var out bytes.Buffer
+		w := NewWriter(&out)
+		w.WriteStatusLine("HTTP/1.1", http.StatusOK, "OK")
+		w.WriteHeaders(headers.NewHeaders())
+		w.SetHeader("X-Late", "1")
+		require.NoError(t, w.Flush())
+		w.SetHeader("X-Too-Late", "1")
+		require.NoError(t, w.Finish())
+
+		assert.Contains(t, out.String(), "x-late: 1\r\n")
+		assert.NotContains(t, out.String(), "x-too-late")
+	})
+}
+
+func TestReset(t *testing.T) {
+	t.Run("Before anything was sent", func(t *testing.T) {
+		var out bytes.Buffer
+		w := NewWriter(&out)
+		w.SetHeader("X-Request-ID", "abc")
+		w.WriteStatusLine("HTTP/1.1", http.StatusOK, "OK")
+		w.WriteHeaders(headers.NewHeaders())
+		w.WriteBody([]byte("partial"))
+
+		require.NoError(t, w.Reset())
+		assert.Equal(t, 0, w.StatusCode())
+		w.WriteStatusLine("HTTP/1.1", http.StatusInternalServerError, "Internal Server Error")
+		w.WriteHeaders(headers.NewHeaders())
+		w.WriteBody([]byte("oops"))
+		require.NoError(t, w.Finish())
+
+		assert.True(t, strings.HasPrefix(out.String(), "HTTP/1.1 500 Internal Server Error\r\n"))
+		assert.Contains(t, out.String(), "x-request-id: abc\r\n")
+		assert.Contains(t, out.String(), "content-length: 4\r\n")
+		assert.NotContains(t, out.String(), "partial")
+	})
+
+	t.Run("After a flush", func(t *testing.T) {
+		var out bytes.Buffer
+		w := NewWriter(&out)
+		w.WriteStatusLine("HTTP/1.1", http.StatusOK, "OK")
+		w.WriteHeaders(headers.NewHeaders())
+		require.NoError(t, w.Flush())
+
+		assert.ErrorIs(t, w.Reset(), ErrCommitted)
+	})
+}
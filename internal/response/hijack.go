@@ -0,0 +1,103 @@
+package response
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+)
+
+// ErrNotHijackable is returned by Hijack when the Writer does not write to a connection.
+var ErrNotHijackable = errors.New("response writer is not backed by a connection")
+
+// SetConnReader sets what Hijack reads from. The server passes the bytes it already read
+// past the request followed by the connection, so nothing the client sent gets lost.
+func (w *Writer) SetConnReader(r io.Reader) {
+	w.connReader = r
+}
+
+// OnHijack registers fn to run right before Hijack hands the connection over. The server
+// uses it to stop reading from the connection itself.
+func (w *Writer) OnHijack(fn func()) {
+	w.beforeHijack = fn
+}
+
+// Hijack hands the connection over to the caller, along with a buffered reader holding
+// any bytes the server already read past the request and a buffered writer that has to
+// be flushed. Reading the returned connection also yields those bytes first; it shares
+// its input with the buffered reader, so callers should read through only one of them.
+// Anything buffered in w is discarded. The server neither writes a response nor closes
+// the connection afterwards: both become the caller's job. It fails once the response
+// headers were sent.
+func (w *Writer) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if w.hijacked {
+		return nil, nil, errors.New("connection already hijacked")
+	}
+	if w.committed {
+		return nil, nil, ErrCommitted
+	}
+	if w.dst == nil && w.parent != nil {
+		conn, rw, err := w.parent.Hijack()
+		if err == nil {
+			w.hijacked = true
+		}
+		return conn, rw, err
+	}
+
+	conn, ok := w.dst.(net.Conn)
+	if !ok {
+		return nil, nil, ErrNotHijackable
+	}
+	w.hijacked = true
+	w.buffer.Reset()
+	if fn := w.beforeHijack; fn != nil {
+		w.beforeHijack = nil
+		fn()
+	}
+
+	if w.connReader != nil {
+		conn = &readerConn{Conn: conn, r: w.connReader}
+	}
+	return conn, bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn)), nil
+}
+
+// readerConn is a hijacked connection whose reads start with what the server had
+// already read from it.
+type readerConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *readerConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// Hijacked reports whether Hijack took the connection over.
+func (w *Writer) Hijacked() bool {
+	return w.hijacked
+}
+
+// Abort gives up on a response that cannot be completed, e.g. because its source failed
+// halfway through the body: everything still buffered is dropped and the connection is
+// closed, so the client sees the response cut short rather than one that looks complete.
+// Nothing is written afterwards, also not by the Writers a recorder records for.
+func (w *Writer) Abort() error {
+	if w.child != nil {
+		return w.child.Abort()
+	}
+	for ; w.parent != nil; w = w.parent {
+		w.aborted = true
+		w.buffer.Reset()
+	}
+	w.aborted = true
+	w.buffer.Reset()
+	if c, ok := w.dst.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// Aborted reports whether Abort was called.
+func (w *Writer) Aborted() bool {
+	return w.aborted
+}
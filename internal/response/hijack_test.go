@@ -0,0 +1,128 @@
+package response
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/abdo-355/http-from-tcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHijack(t *testing.T) {
+	serverSide, clientSide := net.Pipe()
+	defer clientSide.Close()
+
+	w := NewWriter(serverSide)
+	w.WriteStatusLine("HTTP/1.1", http.StatusOK, "OK")
+	w.WriteHeaders(headers.NewHeaders())
+
+	conn, _, err := w.Hijack()
+	require.NoError(t, err)
+	assert.True(t, w.Hijacked())
+
+	// nothing buffered before the hijack reaches the connection
+	require.NoError(t, w.Finish())
+	go func() {
+		conn.Write([]byte("raw"))
+		conn.Close()
+	}()
+	got, err := io.ReadAll(clientSide)
+	require.NoError(t, err)
+	assert.Equal(t, "raw", string(got))
+
+	_, _, err = w.Hijack()
+	assert.Error(t, err)
+}
+
+func TestHijack_Errors(t *testing.T) {
+	t.Run("Not backed by a connection", func(t *testing.T) {
+		_, _, err := NewWriter(new(bytes.Buffer)).Hijack()
+		assert.ErrorIs(t, err, ErrNotHijackable)
+		_, _, err = New().Hijack()
+		assert.ErrorIs(t, err, ErrNotHijackable)
+	})
+
+	t.Run("Headers already sent", func(t *testing.T) {
+		serverSide, clientSide := net.Pipe()
+		defer clientSide.Close()
+		go io.Copy(io.Discard, clientSide)
+
+		w := NewWriter(serverSide)
+		require.NoError(t, w.Flush())
+		_, _, err := w.Hijack()
+		assert.ErrorIs(t, err, ErrCommitted)
+	})
+}
+
+func TestRecorder_ForwardsHijack(t *testing.T) {
+	serverSide, clientSide := net.Pipe()
+	defer clientSide.Close()
+
+	w := NewWriter(serverSide)
+	rec := w.Recorder()
+	conn, _, err := rec.Hijack()
+	require.NoError(t, err)
+	assert.Equal(t, serverSide, conn)
+	assert.True(t, rec.Hijacked())
+	assert.True(t, w.Hijacked())
+}
+
+func TestHijack_BufferedBytes(t *testing.T) {
+	serverSide, clientSide := net.Pipe()
+	defer clientSide.Close()
+
+	w := NewWriter(serverSide)
+	w.SetConnReader(io.MultiReader(strings.NewReader("already read "), serverSide))
+
+	_, rw, err := w.Hijack()
+	require.NoError(t, err)
+
+	go func() {
+		clientSide.Write([]byte("still on the wire\n"))
+	}()
+	line, err := rw.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "already read still on the wire\n", line)
+
+	go func() {
+		rw.WriteString("pong")
+		rw.Flush()
+	}()
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(clientSide, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "pong", string(buf))
+}
+
+func TestAbort(t *testing.T) {
+	serverSide, clientSide := net.Pipe()
+	defer clientSide.Close()
+
+	w := NewWriter(serverSide)
+	rec := w.Recorder()
+	done := make(chan []byte)
+	go func() {
+		got, _ := io.ReadAll(clientSide)
+		done <- got
+	}()
+
+	rec.WriteStatusLine("HTTP/1.1", http.StatusOK, "OK")
+	cw := rec.BodyWriter(headers.NewHeaders())
+	cw.Write([]byte("partial"))
+	require.NoError(t, rec.Flush())
+	cw.Write([]byte("never sent"))
+	require.NoError(t, rec.Abort())
+	assert.True(t, rec.Aborted())
+	assert.True(t, w.Aborted())
+	require.NoError(t, w.Finish())
+
+	got := string(<-done)
+	assert.Contains(t, got, "7\r\npartial\r\n")
+	assert.NotContains(t, got, "never sent")
+	assert.NotContains(t, got, "0\r\n\r\n")
+}
@@ -0,0 +1,39 @@
+package response
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/abdo-355/http-from-tcp/internal/headers"
+)
+
+// ErrCommitted is returned when an interim response is written after the final
+// response was already sent.
+var ErrCommitted = errors.New("final response already sent")
+
+// WriteInformational sends a 1xx interim response, like 100 Continue or 103 Early Hints,
+// right away and ahead of the final response. It can be called several times until the
+// final response is committed.
+func (w *Writer) WriteInformational(statusCode int, h headers.Headers) error {
+	if statusCode < 100 || statusCode > 199 || statusCode == http.StatusSwitchingProtocols {
+		return fmt.Errorf("%d is not an interim status code", statusCode)
+	}
+	if w.committed {
+		return ErrCommitted
+	}
+	if w.dst == nil {
+		if w.parent != nil {
+			return w.parent.WriteInformational(statusCode, h)
+		}
+		return nil
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "HTTP/1.1 %d %s\r\n", statusCode, http.StatusText(statusCode))
+	h.Write(&buf)
+	buf.WriteString("\r\n")
+	_, err := w.dst.Write(buf.Bytes())
+	return err
+}
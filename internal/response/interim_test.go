@@ -0,0 +1,55 @@
+package response
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/abdo-355/http-from-tcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteInformational(t *testing.T) {
+	var out bytes.Buffer
+	w := NewWriter(&out)
+
+	hints := headers.NewHeaders()
+	hints.Set("link", "</style.css>; rel=preload; as=style")
+	require.NoError(t, w.WriteInformational(http.StatusEarlyHints, hints))
+
+	w.WriteStatusLine("HTTP/1.1", http.StatusOK, "OK")
+	w.WriteHeaders(headers.NewHeaders())
+	w.WriteBody([]byte("page"))
+	require.NoError(t, w.Finish())
+
+	assert.True(t, strings.HasPrefix(out.String(), "HTTP/1.1 103 Early Hints\r\nlink: </style.css>; rel=preload; as=style\r\n\r\nHTTP/1.1 200 OK\r\n"))
+
+	res, err := FromReader(&out)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	require.Len(t, res.Interim, 1)
+	assert.Equal(t, http.StatusEarlyHints, res.Interim[0].StatusCode)
+	assert.Equal(t, "page", string(res.Body))
+}
+
+func TestWriteInformational_Errors(t *testing.T) {
+	var out bytes.Buffer
+	w := NewWriter(&out)
+
+	assert.Error(t, w.WriteInformational(http.StatusOK, headers.NewHeaders()))
+	assert.Error(t, w.WriteInformational(http.StatusSwitchingProtocols, headers.NewHeaders()))
+
+	require.NoError(t, w.Flush())
+	assert.ErrorIs(t, w.WriteInformational(http.StatusContinue, headers.NewHeaders()), ErrCommitted)
+}
+
+func TestRecorder_ForwardsInformational(t *testing.T) {
+	var out bytes.Buffer
+	w := NewWriter(&out)
+	rec := w.Recorder()
+
+	require.NoError(t, rec.WriteInformational(http.StatusContinue, headers.NewHeaders()))
+	assert.Equal(t, "HTTP/1.1 100 Continue\r\n\r\n", out.String())
+}
@@ -0,0 +1,320 @@
+package response
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/abdo-355/http-from-tcp/internal/headers"
+)
+
+// maxLineLength bounds the status line, header lines and chunk size lines.
+const maxLineLength = 64 * 1024
+
+// maxHeaderBytes bounds the header section (or the trailers) as a whole, since many
+// lines within maxLineLength could still add up to any size.
+const maxHeaderBytes = 1 << 20
+
+// DefaultMaxBodySize bounds the bodies read by FromReader and FromReaderForMethod.
+const DefaultMaxBodySize = 10 << 20
+
+// ErrBodyTooLarge is returned when a response body is larger than the limit it is read with.
+var ErrBodyTooLarge = errors.New("response body too large")
+
+// Response is a parsed HTTP response.
+type Response struct {
+	HTTPVersion string
+	StatusCode  int
+	Reason      string
+	Headers     headers.Headers
+	Body        []byte
+	Trailers    headers.Headers
+	// Interim holds the 1xx responses (other than 101) received before this one.
+	Interim []*Response
+}
+
+// FromReader parses a response from reader, the counterpart of request.RequestFromReader.
+// If reader is a *bufio.Reader it is used directly, so bytes following the response stay
+// buffered in it for the next read on the same connection.
+func FromReader(reader io.Reader) (*Response, error) {
+	return FromReaderForMethod(reader, "GET")
+}
+
+// FromReaderForMethod parses a response to a request made with method. It is needed
+// because responses to HEAD, and successful responses to CONNECT, never carry a body.
+func FromReaderForMethod(reader io.Reader, method string) (*Response, error) {
+	return FromReaderWithLimit(reader, method, DefaultMaxBodySize)
+}
+
+// FromReaderWithLimit is like FromReaderForMethod but fails with ErrBodyTooLarge once the
+// body grows past maxBodySize bytes. The body is read as it arrives, so a large announced
+// length costs nothing until the bytes are actually there.
+func FromReaderWithLimit(reader io.Reader, method string, maxBodySize int64) (*Response, error) {
+	res, body, err := HeadFromReader(reader, method)
+	if err != nil {
+		return nil, err
+	}
+	if err := ReadBody(res, body, maxBodySize); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// HeadFromReader parses a response up to the end of its headers and returns a reader for
+// the body, which ends where the response's framing says it does. Trailers of a chunked
+// body are stored in the Response once the body reader returns io.EOF.
+func HeadFromReader(reader io.Reader, method string) (*Response, io.Reader, error) {
+	r, ok := reader.(*bufio.Reader)
+	if !ok {
+		r = bufio.NewReader(reader)
+	}
+
+	var interim []*Response
+	for {
+		res, err := readHead(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		// 1xx responses are followed by the final one, except for 101 after which
+		// the connection speaks another protocol
+		if res.StatusCode >= 200 || res.StatusCode == 101 {
+			res.Interim = interim
+			// a successful CONNECT turns the connection into a tunnel (RFC 9112 section 6.3)
+			tunnel := method == "CONNECT" && res.StatusCode >= 200 && res.StatusCode < 300
+			if method == "HEAD" || tunnel || !bodyAllowed(res.StatusCode) {
+				return res, eofReader{}, nil
+			}
+			body, err := bodyReader(r, res)
+			if err != nil {
+				return nil, nil, err
+			}
+			return res, body, nil
+		}
+		interim = append(interim, res)
+	}
+}
+
+// ReadBody reads body, as returned by HeadFromReader, into res.Body. It fails with
+// ErrBodyTooLarge once the body grows past maxBodySize bytes, or right away when the
+// response announces a longer one.
+func ReadBody(res *Response, body io.Reader, maxBodySize int64) error {
+	if res.Headers.Get("transfer-encoding") == "" {
+		if n, err := strconv.ParseInt(res.Headers.Get("content-length"), 10, 64); err == nil && n > maxBodySize {
+			return ErrBodyTooLarge
+		}
+	}
+
+	b, err := io.ReadAll(io.LimitReader(body, maxBodySize+1))
+	if err != nil {
+		return fmt.Errorf("error reading body: %w", err)
+	}
+	if int64(len(b)) > maxBodySize {
+		return ErrBodyTooLarge
+	}
+	res.Body = b
+	return nil
+}
+
+type eofReader struct{}
+
+func (eofReader) Read([]byte) (int, error) { return 0, io.EOF }
+
+func bodyAllowed(status int) bool {
+	return status >= 200 && status != 204 && status != 304
+}
+
+func readHead(r *bufio.Reader) (*Response, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, fmt.Errorf("error reading status line: %w", err)
+	}
+	res, err := parseStatusLine(line)
+	if err != nil {
+		return nil, err
+	}
+
+	res.Headers, err = readHeaders(r)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func bodyReader(r *bufio.Reader, res *Response) (io.Reader, error) {
+	te := strings.ToLower(res.Headers.Get("transfer-encoding"))
+	cl := res.Headers.Get("content-length")
+	switch {
+	case te != "" && te != "identity":
+		if !strings.HasSuffix(te, "chunked") {
+			// any other final coding means the body ends when the connection closes
+			return r, nil
+		}
+		return &chunkedReader{r: r, res: res}, nil
+	case cl != "":
+		n, err := strconv.ParseInt(cl, 10, 64)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid content-length value: %s", cl)
+		}
+		return &lengthReader{r: r, remaining: n}, nil
+	default:
+		return r, nil
+	}
+}
+
+// lengthReader reads a body of a known length, reporting io.ErrUnexpectedEOF when the
+// connection ends before all of it arrived.
+type lengthReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (l *lengthReader) Read(p []byte) (int, error) {
+	if l.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > l.remaining {
+		p = p[:l.remaining]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	if err == io.EOF && l.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// chunkedReader decodes a chunked body as it is read.
+type chunkedReader struct {
+	r   *bufio.Reader
+	res *Response
+	// remaining is what is left of the current chunk
+	remaining int64
+	started   bool
+	err       error
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	if c.remaining == 0 {
+		if c.err = c.nextChunk(); c.err != nil {
+			return 0, c.err
+		}
+	}
+
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.r.Read(p)
+	c.remaining -= int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	c.err = err
+	return n, err
+}
+
+// nextChunk reads the size line of the next chunk, or the trailers after the last one.
+func (c *chunkedReader) nextChunk() error {
+	if c.started {
+		if line, err := readLine(c.r); err != nil || line != "" {
+			return fmt.Errorf("missing CRLF after chunk data")
+		}
+	}
+	c.started = true
+
+	line, err := readLine(c.r)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	// chunk extensions after ';' are ignored
+	sizeStr, _, _ := strings.Cut(line, ";")
+	size, err := strconv.ParseInt(strings.TrimSpace(sizeStr), 16, 64)
+	if err != nil || size < 0 {
+		return fmt.Errorf("invalid chunk size: %s", line)
+	}
+	if size > 0 {
+		c.remaining = size
+		return nil
+	}
+
+	if c.res.Trailers, err = readHeaders(c.r); err != nil {
+		return err
+	}
+	return io.EOF
+}
+
+func parseStatusLine(line string) (*Response, error) {
+	parts := strings.SplitN(line, " ", 3)
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("malformed status-line: %s", line)
+	}
+
+	version, ok := strings.CutPrefix(parts[0], "HTTP/")
+	if !ok || (version != "1.1" && version != "1.0") {
+		return nil, fmt.Errorf("unrecognized HTTP-version: %s", parts[0])
+	}
+
+	code, err := strconv.Atoi(parts[1])
+	if err != nil || len(parts[1]) != 3 || code < 100 {
+		return nil, fmt.Errorf("invalid status code: %s", parts[1])
+	}
+
+	res := &Response{HTTPVersion: version, StatusCode: code}
+	if len(parts) == 3 {
+		res.Reason = parts[2]
+	}
+	return res, nil
+}
+
+// readHeaders reads header lines up to and including the empty line that ends them.
+func readHeaders(r *bufio.Reader) (headers.Headers, error) {
+	h := headers.NewHeaders()
+	size := 0
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return h, fmt.Errorf("error reading headers: %w", err)
+		}
+		if line == "" {
+			return h, nil
+		}
+		size += len(line) + len(headers.CRLF)
+		if size > maxHeaderBytes {
+			return h, fmt.Errorf("headers exceed %d bytes", maxHeaderBytes)
+		}
+		if !strings.Contains(line, ":") {
+			return h, fmt.Errorf("malformed header line: %s", line)
+		}
+		if _, _, err := h.Parse([]byte(line + headers.CRLF)); err != nil {
+			return h, err
+		}
+	}
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		part, isPrefix, err := r.ReadLine()
+		if err != nil {
+			if err == io.EOF && len(line) > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return "", err
+		}
+		line = append(line, part...)
+		if len(line) > maxLineLength {
+			return "", fmt.Errorf("line exceeds %d bytes", maxLineLength)
+		}
+		if !isPrefix {
+			return string(line), nil
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package response
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/abdo-355/http-from-tcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFromReaderForMethod(t *testing.T) {
+	testCases := []struct {
+		name             string
+		raw              string
+		method           string
+		expectedStatus   int
+		expectedReason   string
+		expectedBody     string
+		expectedTrailers map[string]string
+		expectError      bool
+	}{
+		{
+			name:           "Content-Length body",
+			raw:            "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhello",
+			expectedStatus: 200,
+			expectedReason: "OK",
+			expectedBody:   "hello",
+		},
+		{
+			name:             "Chunked body with trailers",
+			raw:              "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nTrailer: X-Sum\r\n\r\n5;ext=1\r\nhello\r\n6\r\n world\r\n0\r\nX-Sum: abc\r\n\r\n",
+			expectedStatus:   200,
+			expectedReason:   "OK",
+			expectedBody:     "hello world",
+			expectedTrailers: map[string]string{"x-sum": "abc"},
+		},
+		{
+			name:           "Close delimited body",
+			raw:            "HTTP/1.1 404 Not Found\r\nContent-Type: text/plain\r\n\r\nmissing",
+			expectedStatus: 404,
+			expectedReason: "Not Found",
+			expectedBody:   "missing",
+		},
+		{
+			name:           "Empty reason phrase",
+			raw:            "HTTP/1.1 299 \r\nContent-Length: 0\r\n\r\n",
+			expectedStatus: 299,
+		},
+		{
+			name:           "HEAD response has no body",
+			raw:            "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\n",
+			method:         "HEAD",
+			expectedStatus: 200,
+			expectedReason: "OK",
+		},
+		{
+			name:           "Successful CONNECT starts a tunnel",
+			raw:            "HTTP/1.1 200 Connection Established\r\n\r\ntunnel bytes",
+			method:         "CONNECT",
+			expectedStatus: 200,
+			expectedReason: "Connection Established",
+		},
+		{
+			name:           "No content for 204 and 304",
+			raw:            "HTTP/1.1 204 No Content\r\nContent-Length: 5\r\n\r\n",
+			expectedStatus: 204,
+			expectedReason: "No Content",
+		},
+		{
+			name:           "Not modified ignores announced length",
+			raw:            "HTTP/1.1 304 Not Modified\r\nContent-Length: 1234\r\nETag: \"a\"\r\n\r\n",
+			expectedStatus: 304,
+			expectedReason: "Not Modified",
+		},
+		{
+			name:           "Interim responses are skipped",
+			raw:            "HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 103 Early Hints\r\nLink: </style.css>\r\n\r\nHTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok",
+			expectedStatus: 200,
+			expectedReason: "OK",
+			expectedBody:   "ok",
+		},
+		{
+			name:           "Switching protocols is final",
+			raw:            "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\n\r\n\x81\x00",
+			expectedStatus: 101,
+			expectedReason: "Switching Protocols",
+		},
+		{name: "Invalid version", raw: "HTTP/2.0 200 OK\r\n\r\n", expectError: true},
+		{name: "Invalid status code", raw: "HTTP/1.1 20 OK\r\n\r\n", expectError: true},
+		{name: "Truncated body", raw: "HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nshort", expectError: true},
+		{name: "Invalid chunk size", raw: "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\n", expectError: true},
+		{name: "Malformed header", raw: "HTTP/1.1 200 OK\r\nno colon here\r\n\r\n", expectError: true},
+		{
+			// every line is short, but together they are over the limit
+			name:        "Headers too large",
+			raw:         "HTTP/1.1 200 OK\r\n" + strings.Repeat("X-Pad: "+strings.Repeat("a", 1000)+"\r\n", 1100) + "\r\n",
+			expectError: true,
+		},
+		{
+			name:        "Trailers too large",
+			raw:         "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n" + strings.Repeat("X-Pad: "+strings.Repeat("a", 1000)+"\r\n", 1100) + "\r\n",
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			method := tc.method
+			if method == "" {
+				method = "GET"
+			}
+			res, err := FromReaderForMethod(strings.NewReader(tc.raw), method)
+			if tc.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedStatus, res.StatusCode)
+			assert.Equal(t, tc.expectedReason, res.Reason)
+			assert.Equal(t, tc.expectedBody, string(res.Body))
+			for k, v := range tc.expectedTrailers {
+				assert.Equal(t, v, res.Trailers.Get(k))
+			}
+		})
+	}
+}
+
+func TestFromReaderWithLimit(t *testing.T) {
+	testCases := []struct {
+		name        string
+		raw         string
+		expectedErr error
+	}{
+		{name: "Within limit", raw: "HTTP/1.1 200 OK\r\nContent-Length: 8\r\n\r\n12345678"},
+		{name: "Announced length too large", raw: "HTTP/1.1 200 OK\r\nContent-Length: 9\r\n\r\n", expectedErr: ErrBodyTooLarge},
+		{name: "Huge announced length", raw: "HTTP/1.1 200 OK\r\nContent-Length: 9223372036854775807\r\n\r\n", expectedErr: ErrBodyTooLarge},
+		{name: "Chunked within limit", raw: "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n4\r\n1234\r\n4\r\n5678\r\n0\r\n\r\n"},
+		{name: "Chunks too large", raw: "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n4\r\n1234\r\n5\r\n56789\r\n0\r\n\r\n", expectedErr: ErrBodyTooLarge},
+		{name: "Huge chunk size", raw: "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n7fffffffffffffff\r\nabc", expectedErr: io.ErrUnexpectedEOF},
+		{name: "Close delimited too large", raw: "HTTP/1.1 200 OK\r\n\r\n123456789", expectedErr: ErrBodyTooLarge},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := FromReaderWithLimit(strings.NewReader(tc.raw), "GET", 8)
+			if tc.expectedErr != nil {
+				assert.ErrorIs(t, err, tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "12345678", string(res.Body))
+		})
+	}
+}
+
+func TestFromReader_Interim(t *testing.T) {
+	raw := "HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 103 Early Hints\r\nLink: </style.css>\r\n\r\nHTTP/1.1 204 No Content\r\n\r\n"
+	res, err := FromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	require.Len(t, res.Interim, 2)
+	assert.Equal(t, 100, res.Interim[0].StatusCode)
+	assert.Equal(t, "</style.css>", res.Interim[1].Headers.Get("link"))
+}
+
+func TestFromReader_KeepsFollowingBytes(t *testing.T) {
+	raw := "HTTP/1.1 200 OK\r\nContent-Length: 1\r\n\r\naHTTP/1.1 200 OK\r\nContent-Length: 1\r\n\r\nb"
+	r := bufio.NewReader(strings.NewReader(raw))
+
+	first, err := FromReader(r)
+	require.NoError(t, err)
+	second, err := FromReader(r)
+	require.NoError(t, err)
+	assert.Equal(t, "a", string(first.Body))
+	assert.Equal(t, "b", string(second.Body))
+}
+
+func TestFromReader_WriterRoundTrip(t *testing.T) {
+	w := New()
+	w.WriteStatusLine("HTTP/1.1", http.StatusOK, "OK")
+	w.WriteHeaders(headers.Headers{M: map[string]string{"transfer-encoding": "chunked", "trailer": "X-Sum"}})
+	_, err := w.WriteChunkedBody([]byte("hello "))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBody([]byte("world"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	require.NoError(t, w.WriteTrailers(headers.Headers{M: map[string]string{"X-Sum": "abc"}}))
+
+	res, err := FromReader(strings.NewReader(string(w.Bytes())))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "hello world", string(res.Body))
+	assert.Equal(t, "abc", res.Trailers.Get("x-sum"))
+}
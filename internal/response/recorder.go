@@ -0,0 +1,63 @@
+package response
+
+import (
+	"bytes"
+	"errors"
+)
+
+// Recorder returns a Writer that buffers a whole response for w, so a middleware can
+// inspect it before writing it to w itself. Interim responses go straight to w, and
+// once the handler flushes the recorder takes over w's connection, see Streaming.
+func (w *Writer) Recorder() *Writer {
+	return &Writer{buffer: new(bytes.Buffer), parent: w}
+}
+
+// RecorderLimit is like Recorder, but once more than limit body bytes are buffered the
+// recorder flushes, which leaves the rest of the response streaming to w as it is written.
+func (w *Writer) RecorderLimit(limit int) *Writer {
+	rec := w.Recorder()
+	rec.flushAt = limit
+	return rec
+}
+
+// flushIfFull flushes a recorder holding more body bytes than its limit.
+func (w *Writer) flushIfFull() {
+	if w.flushAt > 0 && w.buffer.Len()-w.bodyStart > w.flushAt {
+		// a failed write shows up again on the next Flush or Finish
+		w.Flush()
+	}
+}
+
+// Streaming reports whether a recorder was flushed and now writes to the connection of
+// the Writer it records for. The middleware that created it must not write the recorded
+// response again; finishing the parent finishes the recorder.
+func (w *Writer) Streaming() bool {
+	return w.parent != nil && w.dst != nil
+}
+
+// attach makes a recorder write straight to the connection of its parent, taking over
+// the response settings the parent was given.
+func (w *Writer) attach() error {
+	p := w.parent
+	if p.dst == nil && p.parent != nil {
+		if err := p.attach(); err != nil {
+			return err
+		}
+	}
+	if p.dst == nil {
+		return nil
+	}
+	if p.committed || p.hijacked || p.State != WriteStatusLine {
+		return errors.New("the recorded writer was already written to")
+	}
+
+	w.dst = p.dst
+	w.ServerName = p.ServerName
+	w.omitBody = p.omitBody
+	for _, e := range p.edits {
+		w.editHeader(e)
+	}
+	p.edits = nil
+	p.child = w
+	return nil
+}
@@ -0,0 +1,66 @@
+package response
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	"github.com/abdo-355/http-from-tcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecorder_FlushStreamsToParent(t *testing.T) {
+	var out bytes.Buffer
+	w := NewWriter(&out)
+	w.ServerName = "test-server"
+	w.SetHeader("x-outer", "1")
+
+	rec := w.Recorder()
+	rec.WriteStatusLine("HTTP/1.1", http.StatusOK, "OK")
+	rec.WriteHeaders(headers.NewHeaders())
+	rec.WriteBody([]byte("first"))
+	assert.False(t, rec.Streaming())
+	assert.Empty(t, out.String())
+
+	require.NoError(t, rec.Flush())
+	assert.True(t, rec.Streaming())
+	assert.Contains(t, out.String(), "x-outer: 1\r\n")
+	assert.Contains(t, out.String(), "server: test-server\r\n")
+
+	rec.WriteBody([]byte(" second"))
+	require.NoError(t, w.Finish())
+
+	res, err := FromReader(&out)
+	require.NoError(t, err)
+	assert.Equal(t, "chunked", res.Headers.Get("transfer-encoding"))
+	assert.Equal(t, "first second", string(res.Body))
+}
+
+func TestRecorder_WithoutConnection(t *testing.T) {
+	rec := New().Recorder()
+	rec.WriteStatusLine("HTTP/1.1", http.StatusOK, "OK")
+	rec.WriteHeaders(headers.NewHeaders())
+	require.NoError(t, rec.Flush())
+	assert.False(t, rec.Streaming())
+}
+
+func TestRecorderLimit(t *testing.T) {
+	var out bytes.Buffer
+	w := NewWriter(&out)
+
+	rec := w.RecorderLimit(4)
+	rec.WriteStatusLine("HTTP/1.1", http.StatusOK, "OK")
+	rec.WriteHeaders(headers.NewHeaders())
+	rec.WriteBody([]byte("abcd"))
+	assert.False(t, rec.Streaming())
+	rec.WriteBody([]byte("e"))
+	assert.True(t, rec.Streaming())
+	assert.Contains(t, out.String(), "abcde")
+
+	rec.WriteBody([]byte("fgh"))
+	require.NoError(t, w.Finish())
+	res, err := FromReader(&out)
+	require.NoError(t, err)
+	assert.Equal(t, "abcdefgh", string(res.Body))
+}
@@ -3,9 +3,11 @@ package response
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
-	"hash"
+	"io"
 	"strconv"
+	"strings"
 
 	"github.com/abdo-355/http-from-tcp/internal/headers"
 )
@@ -13,12 +15,52 @@ import (
 type Writer struct {
 	buffer *bytes.Buffer
 	State  WriterState
+	// ServerName is sent as the Server header when the handler did not set one.
+	ServerName string
+
+	dst        io.Writer
+	parent     *Writer
+	child      *Writer
+	connReader io.Reader
+	// beforeHijack runs when Hijack hands the connection over
+	beforeHijack func()
+	proto        string
+	statusCode   int
+	statusText   string
+	header       headers.Headers
+	bodyStart    int
+	chunked      bool
+	trailers     headers.Headers
+	committed    bool
+	autoChunk    bool
+	omitBody     bool
+	omitted      int
+	hijacked     bool
+	aborted      bool
+	flushAt      int
+	encoder      EncoderFunc
+	// enc encodes the body once encoder chose to, see SetEncoder
+	enc   Encoder
+	edits []headerEdit
+	// applied keeps the edits WriteHeaders already applied, for Reset to queue them again
+	applied []headerEdit
+}
+
+type headerEdit struct {
+	key, value string
+	add        bool
 }
 
+// New creates a Writer that only buffers the response, see Bytes.
 func New() *Writer {
 	return &Writer{buffer: new(bytes.Buffer)}
 }
 
+// NewWriter creates a Writer that sends the response to dst on Flush and Finish.
+func NewWriter(dst io.Writer) *Writer {
+	return &Writer{buffer: new(bytes.Buffer), dst: dst}
+}
+
 func (w *Writer) Bytes() []byte {
 	return w.buffer.Bytes()
 }
@@ -30,6 +72,7 @@ const (
 	WriteHeaders
 	WriteBody
 	WriteTrailers
+	Done
 )
 
 func (w *Writer) Write(data []byte) (n int, err error) {
@@ -41,6 +84,9 @@ func (w *Writer) WriteStatusLine(proto string, statusCode int, statusText string
 		panic("invalid operations order. make sure this is run first")
 	}
 	fmt.Fprintf(w.buffer, "%s %d %s\r\n", proto, statusCode, statusText)
+	w.proto = proto
+	w.statusCode = statusCode
+	w.statusText = statusText
 	w.State = WriteHeaders
 }
 
@@ -58,44 +104,109 @@ func (w *Writer) WriteHeaders(headers headers.Headers) {
 	if w.State != WriteHeaders {
 		panic("invalid operations order. make sure this runs after writing the status line and before writing the body")
 	}
-	for k, v := range headers.M {
-		fmt.Fprintf(w.buffer, "%s: %s\r\n", k, v)
+	// a message must not carry both, and Transfer-Encoding wins (RFC 9112 section 6.3)
+	if headers.Get("transfer-encoding") != "" && headers.Get("content-length") != "" {
+		headers = headers.Clone()
+		headers.Del("content-length")
 	}
+	if len(w.edits) > 0 {
+		headers = headers.Clone()
+		for _, e := range w.edits {
+			e.apply(headers)
+		}
+		w.applied = append(w.applied, w.edits...)
+		w.edits = nil
+	}
+	headers.Write(w.buffer)
 
 	w.buffer.WriteString("\r\n")
+	w.header = headers
+	w.bodyStart = w.buffer.Len()
 	w.State = WriteBody
 }
 
 func (w *Writer) WriteBody(b []byte) {
-	if w.State != WriteBody {
+	declared := w.declaresChunked()
+	if w.State != WriteBody || (w.chunked && !w.autoChunk && !declared) {
 		panic("invalid operations order. make sure this runs last")
 	}
 
+	if w.bodyOmitted() {
+		w.omitted += len(b)
+		return
+	}
+	if w.autoChunk || declared {
+		// the headers already went out without a length, or announce chunked encoding
+		// themselves, so the body has to be sent in chunks
+		w.WriteChunkedBody(b)
+		return
+	}
 	w.buffer.Write(b)
+	w.flushIfFull()
 }
 
-func (w *Writer) WriteChunkedBody(p []byte, h hash.Hash) (int, error) {
-	chunkSizeHex := fmt.Sprintf("%x", len(p))
-	var bytesWritten int
-	n, err := w.Write([]byte(chunkSizeHex + "\r\n"))
-	if err != nil {
-		return 0, err
+// declaresChunked reports whether the headers passed to WriteHeaders set
+// Transfer-Encoding: chunked.
+func (w *Writer) declaresChunked() bool {
+	return strings.HasSuffix(strings.ToLower(w.header.Get("transfer-encoding")), "chunked")
+}
+
+// WriteChunkedBody writes p as a single chunk. Empty slices are skipped since a
+// zero sized chunk marks the end of the body.
+func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
+	if w.State != WriteBody {
+		return 0, fmt.Errorf("invalid operations order. make sure this runs after writing the headers")
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if w.enc != nil {
+		// the encoder hands its output back to writeChunk
+		n, err := w.enc.Write(p)
+		w.flushIfFull()
+		return n, err
+	}
+	n, err := w.writeChunk(p)
+	w.flushIfFull()
+	return n, err
+}
+
+func (w *Writer) writeChunk(p []byte) (int, error) {
+	w.chunked = true
+	if w.bodyOmitted() {
+		w.omitted += len(p)
+		return len(p), nil
 	}
-	bytesWritten += n
 
-	n, err = w.Write(append(p, "\r\n"...))
+	n, err := fmt.Fprintf(w.buffer, "%x\r\n", len(p))
 	if err != nil {
 		return 0, err
 	}
-	bytesWritten += n
-
-	_, err = h.Write(p)
-
-	return bytesWritten, err
+	m, err := w.Write(p)
+	if err != nil {
+		return n + m, err
+	}
+	k, err := w.Write([]byte("\r\n"))
+	return n + m + k, err
 }
 
 func (w *Writer) WriteChunkedBodyDone() (int, error) {
+	if w.State != WriteBody {
+		return 0, fmt.Errorf("invalid operations order. make sure this runs after writing the headers")
+	}
+	if w.enc != nil {
+		// the encoder still holds the end of the body
+		enc := w.enc
+		w.enc = nil
+		if err := enc.Close(); err != nil {
+			return 0, err
+		}
+	}
+	w.chunked = true
 	w.State = WriteTrailers
+	if w.bodyOmitted() {
+		return 0, nil
+	}
 	return w.Write([]byte("0\r\n"))
 }
 
@@ -104,12 +215,15 @@ func (w *Writer) WriteTrailers(h headers.Headers) error {
 	if w.State != WriteTrailers {
 		return fmt.Errorf("invalid operations order. make sure this runs last")
 	}
-	for k, v := range h.M {
-		_, err := w.Write([]byte(k + ": " + v + "\r\n"))
-
-		if err != nil {
-			return err
-		}
+	if w.bodyOmitted() {
+		w.trailers = h
+		w.State = Done
+		return nil
+	}
+	var buf bytes.Buffer
+	h.Write(&buf)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return err
 	}
 
 	_, err := w.Write([]byte("\r\n"))
@@ -117,5 +231,164 @@ func (w *Writer) WriteTrailers(h headers.Headers) error {
 		return err
 	}
 
+	w.trailers = h
+	w.State = Done
+	return nil
+}
+
+// SetHeader sets a header on the response no matter what the handler passes to
+// WriteHeaders, so middlewares can decorate responses they do not write themselves.
+// It has no effect once the headers were sent.
+func (w *Writer) SetHeader(key, value string) {
+	w.editHeader(headerEdit{key: key, value: value})
+}
+
+// AddHeader is like SetHeader but appends value to the one the handler set, for list
+// headers such as Vary and for Set-Cookie. In token lists (Vary and Connection) a token
+// already present is not added again.
+func (w *Writer) AddHeader(key, value string) {
+	w.editHeader(headerEdit{key: key, value: value, add: true})
+}
+
+func (w *Writer) editHeader(e headerEdit) {
+	switch {
+	case w.committed:
+	case w.State <= WriteHeaders:
+		w.edits = append(w.edits, e)
+	default:
+		// the head is rendered again on commit, so updating the headers is enough
+		w.header = w.header.Clone()
+		e.apply(w.header)
+	}
+}
+
+// tokenLists are the headers whose values are case-insensitive tokens, so that adding
+// one twice changes nothing.
+var tokenLists = map[string]bool{"vary": true, "connection": true}
+
+func (e headerEdit) apply(h headers.Headers) {
+	if e.add && !tokenLists[strings.ToLower(e.key)] {
+		h.Add(e.key, e.value)
+		return
+	}
+	if e.add {
+		for v := range strings.SplitSeq(h.Get(e.key), ",") {
+			if strings.EqualFold(strings.TrimSpace(v), e.value) {
+				return
+			}
+		}
+		h.Add(e.key, e.value)
+		return
+	}
+	h.Set(e.key, e.value)
+}
+
+// OmitBody makes the writer drop body bytes while keeping the headers, including the
+// Content-Length the body would have had. The server uses it to answer HEAD requests.
+func (w *Writer) OmitBody() {
+	w.omitBody = true
+}
+
+// bodyOmitted reports whether body bytes are dropped, either because of OmitBody or
+// because the status code does not allow a body (1xx, 204 and 304).
+func (w *Writer) bodyOmitted() bool {
+	return w.omitBody || (w.statusCode != 0 && !bodyAllowed(w.statusCode))
+}
+
+// CopyTo replays everything written to w into dst, which must not have been written to yet.
+func (w *Writer) CopyTo(dst *Writer) {
+	if dst.State != WriteStatusLine {
+		panic("invalid operations order. make sure the destination writer is empty")
+	}
+	dst.buffer.Write(w.buffer.Bytes())
+	dst.State = w.State
+	dst.statusCode = w.statusCode
+	dst.header = w.header
+	if len(dst.edits) > 0 && w.State >= WriteBody {
+		dst.header = w.header.Clone()
+		for _, e := range dst.edits {
+			e.apply(dst.header)
+		}
+		dst.applied = append(dst.applied, dst.edits...)
+		dst.edits = nil
+	}
+	dst.bodyStart = w.bodyStart
+	dst.chunked = w.chunked
+	dst.trailers = w.trailers
+	dst.proto = w.proto
+	dst.statusText = w.statusText
+}
+
+// Reset discards everything written so far, so that a different response (like an error
+// page) can be written instead. Header edits made with SetHeader and AddHeader before
+// WriteHeaders are kept. It fails once the headers were sent or the connection hijacked.
+func (w *Writer) Reset() error {
+	if w.committed || w.child != nil {
+		return ErrCommitted
+	}
+	if w.hijacked {
+		return errors.New("connection hijacked")
+	}
+	w.buffer.Reset()
+	w.State = WriteStatusLine
+	w.proto, w.statusCode, w.statusText = "", 0, ""
+	w.header = headers.Headers{}
+	w.trailers = headers.Headers{}
+	w.bodyStart = 0
+	w.chunked = false
+	w.omitted = 0
+	w.edits = append(w.applied, w.edits...)
+	w.applied = nil
 	return nil
 }
+
+// StatusCode returns the status code written by WriteStatusLine, or 0 if none was written yet.
+func (w *Writer) StatusCode() int {
+	return w.statusCode
+}
+
+// StatusText returns the reason phrase written by WriteStatusLine.
+func (w *Writer) StatusText() string {
+	return w.statusText
+}
+
+// Header returns the headers passed to WriteHeaders.
+func (w *Writer) Header() headers.Headers {
+	return w.header
+}
+
+// Trailers returns the trailers passed to WriteTrailers.
+func (w *Writer) Trailers() headers.Headers {
+	return w.trailers
+}
+
+// IsChunked reports whether the body was written with chunked transfer encoding.
+func (w *Writer) IsChunked() bool {
+	return w.chunked
+}
+
+// Body returns the payload written so far, with any chunk framing removed.
+func (w *Writer) Body() []byte {
+	if w.State < WriteBody {
+		return nil
+	}
+	raw := w.buffer.Bytes()[w.bodyStart:]
+	if !w.chunked {
+		return raw
+	}
+
+	var body []byte
+	for {
+		idx := bytes.Index(raw, []byte("\r\n"))
+		if idx == -1 {
+			return body
+		}
+		size, err := strconv.ParseInt(string(raw[:idx]), 16, 64)
+		if err != nil || size == 0 || int(size)+idx+4 > len(raw) {
+			return body
+		}
+		raw = raw[idx+2:]
+		body = append(body, raw[:size]...)
+		raw = raw[size+2:]
+	}
+}
@@ -1,7 +1,6 @@
 package response
 
 import (
-	"crypto/sha256"
 	"net/http"
 	"testing"
 
@@ -228,18 +227,22 @@ func TestWriteBody(t *testing.T) {
 func TestWriteChunkedBody(t *testing.T) {
 	w := New()
 	w.State = WriteBody // Assume it's in body writing state for chunked
-	h := sha256.New()
 
 	data := []byte("hello world")
-	n, err := w.WriteChunkedBody(data, h)
+	n, err := w.WriteChunkedBody(data)
 	require.NoError(t, err)
 	assert.Equal(t, 16, n) // "b\r\nhello world\r\n" is 16 bytes
 	assert.Equal(t, "b\r\nhello world\r\n", w.buffer.String())
 
-	// Check hash
-	expectedHash := sha256.Sum256(data)
-	actualHash := h.Sum(nil)
-	assert.Equal(t, expectedHash[:], actualHash)
+	// an empty chunk would end the body, so it is skipped
+	n, err = w.WriteChunkedBody(nil)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	// chunks can only be written in the body state
+	w = New()
+	_, err = w.WriteChunkedBody(data)
+	require.Error(t, err)
 }
 
 func TestWriteChunkedBodyDone(t *testing.T) {
@@ -251,6 +254,9 @@ func TestWriteChunkedBodyDone(t *testing.T) {
 	assert.Equal(t, 3, n) // "0\r\n"
 	assert.Equal(t, "0\r\n", w.buffer.String())
 	assert.Equal(t, WriteTrailers, w.State)
+
+	_, err = w.WriteChunkedBodyDone()
+	require.Error(t, err)
 }
 
 func TestWriteTrailers(t *testing.T) {
@@ -307,3 +313,39 @@ func TestWriteTrailers(t *testing.T) {
 		})
 	}
 }
+
+func TestWriterRecording(t *testing.T) {
+	t.Run("Fixed length body", func(t *testing.T) {
+		w := New()
+		w.WriteStatusLine("HTTP/1.1", http.StatusCreated, "Created")
+		w.WriteHeaders(GetDefaultHeaders(5))
+		w.WriteBody([]byte("hello"))
+
+		assert.Equal(t, http.StatusCreated, w.StatusCode())
+		assert.Equal(t, "5", w.Header().M["content-length"])
+		assert.Equal(t, "hello", string(w.Body()))
+		assert.False(t, w.IsChunked())
+	})
+
+	t.Run("Chunked body", func(t *testing.T) {
+		w := New()
+		w.WriteStatusLine("HTTP/1.1", http.StatusOK, "OK")
+		w.WriteHeaders(headers.Headers{M: map[string]string{"transfer-encoding": "chunked"}})
+		_, err := w.WriteChunkedBody([]byte("hello "))
+		require.NoError(t, err)
+		_, err = w.WriteChunkedBody([]byte("world"))
+		require.NoError(t, err)
+		_, err = w.WriteChunkedBodyDone()
+		require.NoError(t, err)
+		require.NoError(t, w.WriteTrailers(headers.Headers{M: map[string]string{"X-Sum": "1"}}))
+
+		assert.Equal(t, "hello world", string(w.Body()))
+		assert.True(t, w.IsChunked())
+		assert.Equal(t, "1", w.Trailers().M["X-Sum"])
+
+		dst := New()
+		w.CopyTo(dst)
+		assert.Equal(t, w.Bytes(), dst.Bytes())
+		assert.Equal(t, "hello world", string(dst.Body()))
+	})
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/abdo-355/http-from-tcp/internal/request"
+	"github.com/abdo-355/http-from-tcp/internal/response"
+)
+
+// CheckPreconditions evaluates the conditional request headers against the current
+// representation following the precedence in RFC 9110 section 13.2.2.
+// It returns 0 when the request should be processed normally, otherwise the status
+// code (304 or 412) to answer with. An empty etag or zero lastModified means the
+// representation has no such validator. It is meant for a resource that exists, so
+// "*" in If-Match or If-None-Match always matches it.
+func CheckPreconditions(req *request.Request, etag string, lastModified time.Time) int {
+	method := req.RequestLine.Method
+	isGetOrHead := method == "GET" || method == "HEAD"
+
+	// step 1 and 2: If-Match takes precedence over If-Unmodified-Since
+	if ifMatch := req.Headers.Get("if-match"); ifMatch != "" {
+		if !matchesAny(ifMatch, etag, response.ETagStrongMatch) {
+			return http.StatusPreconditionFailed
+		}
+	} else if ius := req.Headers.Get("if-unmodified-since"); ius != "" && !lastModified.IsZero() {
+		if t, err := http.ParseTime(ius); err == nil && lastModified.Truncate(time.Second).After(t) {
+			return http.StatusPreconditionFailed
+		}
+	}
+
+	// step 3 and 4: If-None-Match takes precedence over If-Modified-Since
+	if ifNoneMatch := req.Headers.Get("if-none-match"); ifNoneMatch != "" {
+		if matchesAny(ifNoneMatch, etag, response.ETagWeakMatch) {
+			if isGetOrHead {
+				return http.StatusNotModified
+			}
+			return http.StatusPreconditionFailed
+		}
+	} else if ims := req.Headers.Get("if-modified-since"); ims != "" && isGetOrHead && !lastModified.IsZero() {
+		if t, err := http.ParseTime(ims); err == nil && !lastModified.Truncate(time.Second).After(t) {
+			return http.StatusNotModified
+		}
+	}
+
+	return 0
+}
+
+func matchesAny(list, etag string, match func(a, b string) bool) bool {
+	for _, tag := range response.ParseETagList(list) {
+		// "*" matches any current representation, with or without a tag
+		if tag == "*" || (etag != "" && match(tag, etag)) {
+			return true
+		}
+	}
+	return false
+}
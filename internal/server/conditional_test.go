@@ -0,0 +1,101 @@
+package server
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abdo-355/http-from-tcp/internal/headers"
+	"github.com/abdo-355/http-from-tcp/internal/request"
+	"github.com/abdo-355/http-from-tcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRequest(method string, h map[string]string) *request.Request {
+	req := &request.Request{
+		RequestLine: request.RequestLine{Method: method, RequestTarget: "/", HTTPVersion: "1.1"},
+		Headers:     headers.NewHeaders(),
+	}
+	for k, v := range h {
+		req.Headers.Set(k, v)
+	}
+	return req
+}
+
+func TestCheckPreconditions(t *testing.T) {
+	etag := `"v1"`
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	before := modTime.Add(-time.Hour).Format(http.TimeFormat)
+	after := modTime.Add(time.Hour).Format(http.TimeFormat)
+
+	testCases := []struct {
+		name     string
+		method   string
+		headers  map[string]string
+		noETag   bool
+		expected int
+	}{
+		{name: "No conditions", method: "GET", expected: 0},
+		{name: "If-None-Match matches", method: "GET", headers: map[string]string{"if-none-match": `"v0", W/"v1"`}, expected: http.StatusNotModified},
+		{name: "If-None-Match differs", method: "GET", headers: map[string]string{"if-none-match": `"v0"`}, expected: 0},
+		{name: "If-None-Match wildcard on PUT", method: "PUT", headers: map[string]string{"if-none-match": "*"}, expected: http.StatusPreconditionFailed},
+		{name: "If-None-Match wildcard without ETag", method: "GET", headers: map[string]string{"if-none-match": "*"}, noETag: true, expected: http.StatusNotModified},
+		{name: "If-Match wildcard without ETag", method: "PUT", headers: map[string]string{"if-match": "*"}, noETag: true, expected: 0},
+		{name: "If-Match tag without ETag", method: "PUT", headers: map[string]string{"if-match": `"v1"`}, noETag: true, expected: http.StatusPreconditionFailed},
+		{name: "If-Match matches", method: "PUT", headers: map[string]string{"if-match": `"v1"`}, expected: 0},
+		{name: "If-Match weak tag fails", method: "PUT", headers: map[string]string{"if-match": `W/"v1"`}, expected: http.StatusPreconditionFailed},
+		{name: "If-Unmodified-Since passes", method: "PUT", headers: map[string]string{"if-unmodified-since": after}, expected: 0},
+		{name: "If-Unmodified-Since fails", method: "PUT", headers: map[string]string{"if-unmodified-since": before}, expected: http.StatusPreconditionFailed},
+		{name: "If-Match wins over If-Unmodified-Since", method: "PUT", headers: map[string]string{"if-match": `"v1"`, "if-unmodified-since": before}, expected: 0},
+		{name: "If-Modified-Since not modified", method: "GET", headers: map[string]string{"if-modified-since": after}, expected: http.StatusNotModified},
+		{name: "If-Modified-Since modified", method: "GET", headers: map[string]string{"if-modified-since": before}, expected: 0},
+		{name: "If-Modified-Since ignored for POST", method: "POST", headers: map[string]string{"if-modified-since": after}, expected: 0},
+		{name: "If-None-Match wins over If-Modified-Since", method: "GET", headers: map[string]string{"if-none-match": `"v0"`, "if-modified-since": after}, expected: 0},
+		{name: "Invalid date is ignored", method: "GET", headers: map[string]string{"if-modified-since": "yesterday"}, expected: 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := newTestRequest(tc.method, tc.headers)
+			tag := etag
+			if tc.noETag {
+				tag = ""
+			}
+			assert.Equal(t, tc.expected, CheckPreconditions(req, tag, modTime))
+		})
+	}
+}
+
+func TestServeFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hello.txt")
+	require.NoError(t, os.WriteFile(path, []byte("hello"), 0o644))
+	etag := response.ContentETag([]byte("hello"))
+
+	t.Run("Full response", func(t *testing.T) {
+		w := response.New()
+		ServeFile(w, newTestRequest("GET", nil), path, "text/plain")
+		out := string(w.Bytes())
+		assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+		assert.Contains(t, out, "etag: "+etag+"\r\n")
+		assert.Contains(t, out, "last-modified: ")
+		assert.True(t, strings.HasSuffix(out, "\r\n\r\nhello"))
+	})
+
+	t.Run("Not modified", func(t *testing.T) {
+		w := response.New()
+		ServeFile(w, newTestRequest("GET", map[string]string{"if-none-match": etag}), path, "text/plain")
+		out := string(w.Bytes())
+		assert.True(t, strings.HasPrefix(out, "HTTP/1.1 304 Not Modified\r\n"))
+		assert.True(t, strings.HasSuffix(out, "\r\n\r\n"))
+	})
+
+	t.Run("Missing file", func(t *testing.T) {
+		w := response.New()
+		ServeFile(w, newTestRequest("GET", nil), filepath.Join(t.TempDir(), "missing"), "text/plain")
+		assert.True(t, strings.HasPrefix(string(w.Bytes()), "HTTP/1.1 404 Not Found\r\n"))
+	})
+}
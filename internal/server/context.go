@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// WithRequestTimeout cancels the context of every request after d. Zero, the default,
+// leaves requests without a deadline.
+func WithRequestTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.requestTimeout = d
+	}
+}
+
+// requestContext derives the context of a new request from the server's, which is
+// cancelled by Close.
+func (s *Server) requestContext() (context.Context, context.CancelFunc) {
+	ctx := s.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if s.requestTimeout > 0 {
+		return context.WithTimeout(ctx, s.requestTimeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// WithHalfClose keeps the context of a request alive when the client only closes its
+// sending side (shutdown(SHUT_WR), nc -N) and still waits for the response. By default
+// an EOF while the handler runs cancels it like any other close.
+func WithHalfClose() Option {
+	return func(s *Server) {
+		s.halfClose = true
+	}
+}
+
+// connReader reads a request from its connection. While the handler runs it keeps a
+// read pending on the connection to notice the client closing it, since nothing else
+// reads from it then. A later read, like ReadBody after 100 Continue or a hijacker
+// reading the connection, stops the watch first and gets any byte it picked up.
+type connReader struct {
+	conn net.Conn
+	// halfClose ignores an EOF, see WithHalfClose
+	halfClose bool
+
+	watching bool
+	stopping atomic.Bool
+	done     chan struct{}
+	b        [1]byte
+	pending  []byte
+}
+
+func (cr *connReader) Read(p []byte) (int, error) {
+	cr.stopWatching()
+	if len(cr.pending) > 0 {
+		n := copy(p, cr.pending)
+		cr.pending = cr.pending[n:]
+		return n, nil
+	}
+	return cr.conn.Read(p)
+}
+
+// watch calls cancel when the client closes or resets the connection. A client sending
+// more data (e.g. a pipelined request) ends the watch without cancelling.
+func (cr *connReader) watch(cancel context.CancelFunc) {
+	cr.watching = true
+	cr.stopping.Store(false)
+	cr.done = make(chan struct{})
+	go func() {
+		defer close(cr.done)
+		n, err := cr.conn.Read(cr.b[:])
+		cr.pending = cr.b[:n]
+		if n == 0 && err != nil && !(cr.halfClose && errors.Is(err, io.EOF)) && !cr.stopping.Load() {
+			cancel()
+		}
+	}()
+}
+
+func (cr *connReader) stopWatching() {
+	if !cr.watching {
+		return
+	}
+	cr.watching = false
+	cr.stopping.Store(true)
+	// a deadline in the past unblocks the pending read
+	cr.conn.SetReadDeadline(time.Unix(1, 0))
+	<-cr.done
+	cr.conn.SetReadDeadline(time.Time{})
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/abdo-355/http-from-tcp/internal/request"
+	"github.com/abdo-355/http-from-tcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestContext(t *testing.T) {
+	testCases := []struct {
+		name    string
+		opts    []Option
+		trigger func(srv *Server, conn net.Conn)
+		want    error
+	}{
+		{
+			name:    "Client closes the connection",
+			trigger: func(srv *Server, conn net.Conn) { conn.Close() },
+			want:    context.Canceled,
+		},
+		{
+			name:    "Server closes",
+			trigger: func(srv *Server, conn net.Conn) { srv.Close() },
+			want:    context.Canceled,
+		},
+		{
+			name:    "Request times out",
+			opts:    []Option{WithRequestTimeout(50 * time.Millisecond)},
+			trigger: func(srv *Server, conn net.Conn) {},
+			want:    context.DeadlineExceeded,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			started := make(chan struct{})
+			errs := make(chan error, 1)
+			srv, err := Serve(0, func(w *response.Writer, req *request.Request) {
+				close(started)
+				select {
+				case <-req.Context().Done():
+					errs <- req.Context().Err()
+				case <-time.After(2 * time.Second):
+					errs <- nil
+				}
+			}, tc.opts...)
+			require.NoError(t, err)
+			defer srv.Close()
+
+			conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+			require.NoError(t, err)
+			defer conn.Close()
+			_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+			require.NoError(t, err)
+
+			<-started
+			tc.trigger(srv, conn)
+			assert.ErrorIs(t, <-errs, tc.want)
+		})
+	}
+}
+
+func TestRequestContext_PipelinedBytes(t *testing.T) {
+	errs := make(chan error, 1)
+	srv, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		// give the watcher time to read the extra bytes
+		time.Sleep(50 * time.Millisecond)
+		errs <- req.Context().Err()
+	})
+	require.NoError(t, err)
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\nGET /next HTTP/1.1\r\n"))
+	require.NoError(t, err)
+
+	assert.NoError(t, <-errs)
+}
+
+func TestRequestContext_Hijack(t *testing.T) {
+	got := make(chan string, 1)
+	srv, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		// give the watcher time to read the first byte after the request
+		time.Sleep(50 * time.Millisecond)
+		conn, _, err := w.Hijack()
+		if err != nil {
+			got <- err.Error()
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, 5)
+		io.ReadFull(conn, buf)
+		got <- string(buf)
+	})
+	require.NoError(t, err)
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\nhello"))
+	require.NoError(t, err)
+
+	assert.Equal(t, "hello", <-got)
+}
+
+func TestRequestContext_HalfClose(t *testing.T) {
+	srv, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		// give the watcher time to see the EOF
+		time.Sleep(50 * time.Millisecond)
+		if req.Context().Err() != nil {
+			return
+		}
+		writePlain(w, http.StatusOK, "still here")
+	}, WithHalfClose())
+	require.NoError(t, err)
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+
+	res, err := response.FromReader(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "still here", string(res.Body))
+}
+
+func TestConnReader_Watch(t *testing.T) {
+	testCases := []struct {
+		name       string
+		err        error
+		halfClose  bool
+		wantCancel bool
+	}{
+		{name: "EOF", err: io.EOF, wantCancel: true},
+		{name: "EOF with half-close", err: io.EOF, halfClose: true},
+		{name: "Reset", err: syscall.ECONNRESET, wantCancel: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			cr := &connReader{conn: &failingConn{MockConn: &MockConn{}, err: tc.err}, halfClose: tc.halfClose}
+			cr.watch(cancel)
+			<-cr.done
+			assert.Equal(t, tc.wantCancel, ctx.Err() != nil)
+		})
+	}
+}
+
+// failingConn fails every read with err.
+type failingConn struct {
+	*MockConn
+	err error
+}
+
+func (c *failingConn) Read([]byte) (int, error) { return 0, c.err }
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/abdo-355/http-from-tcp/internal/errorpage"
+	"github.com/abdo-355/http-from-tcp/internal/httperrors"
+	"github.com/abdo-355/http-from-tcp/internal/request"
+	"github.com/abdo-355/http-from-tcp/internal/response"
+)
+
+// DecodeRequestBody is an opt-in middleware that transparently decompresses request bodies
+// sent with Content-Encoding, answering 415, 413 or 400 when the body cannot be decoded.
+func DecodeRequestBody(maxSize int64) Middleware {
+	return func(next Handler) Handler {
+		return func(w *response.Writer, req *request.Request) {
+			if req.Headers.Get("content-encoding") == "" {
+				next(w, req)
+				return
+			}
+			if err := req.ReadBody(); err != nil {
+				req.Logger().Warn("error reading request body", "err", err)
+				errorpage.Error(w, req, http.StatusBadRequest, errors.New("malformed request body"))
+				return
+			}
+			if err := req.DecodeBody(maxSize); err != nil {
+				status := http.StatusBadRequest
+				var se httperrors.StatusError
+				if errors.As(err, &se) {
+					status = se.Code
+				}
+				req.Logger().Warn("error decoding request body", "err", err)
+				errorpage.Error(w, req, status, err)
+				return
+			}
+			next(w, req)
+		}
+	}
+}
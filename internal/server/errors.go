@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/abdo-355/http-from-tcp/internal/errorpage"
+	"github.com/abdo-355/http-from-tcp/internal/request"
+	"github.com/abdo-355/http-from-tcp/internal/response"
+)
+
+// ErrorHandler writes the response for an error, with status as its status code. When the
+// request could not be parsed, req only carries its ID.
+type ErrorHandler = errorpage.Handler
+
+// WithErrorHandler sets how errors are answered: malformed requests, rejected bodies,
+// timeouts, panics and everything handlers report with errorpage.Error. The default is
+// errorpage.Write, which answers in plain text, HTML or problem+json.
+func WithErrorHandler(h ErrorHandler) Option {
+	return func(s *Server) {
+		s.errorHandler = h
+	}
+}
+
+func (s *Server) errorContext(ctx context.Context) context.Context {
+	if s.errorHandler == nil {
+		return ctx
+	}
+	return errorpage.NewContext(ctx, s.errorHandler)
+}
+
+// errMalformedRequest answers requests that could not be parsed. The parser's error is
+// only logged, as it may echo what the client sent.
+var errMalformedRequest = errors.New("the request could not be parsed")
+
+// errRequestTimeout answers handlers that gave up on their timed out request without
+// writing anything.
+var errRequestTimeout = errors.New("the request took too long to handle")
+
+// runHandler calls the handler and turns a panic into a 500 response. It reports false
+// when the panic left a response that cannot be completed, so the connection has to go.
+func (s *Server) runHandler(w *response.Writer, req *request.Request) (ok bool) {
+	defer func() {
+		v := recover()
+		if v == nil {
+			return
+		}
+		req.Logger().Error("handler panicked", "panic", v, "stack", string(debug.Stack()))
+		if err := w.Reset(); err != nil {
+			return
+		}
+		errorpage.Error(w, req, http.StatusInternalServerError, errors.New("the server hit an unexpected error"))
+		ok = true
+	}()
+	s.handler(w, req)
+	return true
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abdo-355/http-from-tcp/internal/errorpage"
+	"github.com/abdo-355/http-from-tcp/internal/headers"
+	"github.com/abdo-355/http-from-tcp/internal/request"
+	"github.com/abdo-355/http-from-tcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandle_Panic(t *testing.T) {
+	testCases := []struct {
+		name    string
+		handler Handler
+		want500 bool
+	}{
+		{
+			name:    "Before writing",
+			handler: func(w *response.Writer, req *request.Request) { panic("boom") },
+			want500: true,
+		},
+		{
+			name: "With a buffered response",
+			handler: func(w *response.Writer, req *request.Request) {
+				w.WriteStatusLine("HTTP/1.1", http.StatusOK, "OK")
+				w.WriteHeaders(headers.NewHeaders())
+				w.WriteBody([]byte("half a response"))
+				panic("boom")
+			},
+			want500: true,
+		},
+		{
+			name: "After the headers were sent",
+			handler: func(w *response.Writer, req *request.Request) {
+				w.WriteStatusLine("HTTP/1.1", http.StatusOK, "OK")
+				w.WriteHeaders(headers.NewHeaders())
+				w.Flush()
+				panic("boom")
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conn := &MockConn{Reader: strings.NewReader("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"), Builder: new(strings.Builder)}
+			srv := &Server{handler: tc.handler}
+			srv.handle(conn)
+
+			out := conn.Builder.String()
+			assert.True(t, conn.closed)
+			assert.NotContains(t, out, "half a response")
+			if tc.want500 {
+				assert.True(t, strings.HasPrefix(out, "HTTP/1.1 500 Internal Server Error\r\n"))
+				assert.Contains(t, out, "x-request-id: ")
+				return
+			}
+			assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+			assert.NotContains(t, out, "0\r\n\r\n", "a broken chunked response must not look complete")
+		})
+	}
+}
+
+func TestHandle_TimeoutError(t *testing.T) {
+	srv, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		<-req.Context().Done()
+	}, WithRequestTimeout(20*time.Millisecond))
+	require.NoError(t, err)
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+
+	res, err := response.FromReader(conn)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+	assert.Contains(t, string(res.Body), "took too long")
+}
+
+func TestWithErrorHandler(t *testing.T) {
+	var got []string
+	custom := func(w *response.Writer, req *request.Request, status int, err error) {
+		got = append(got, fmt.Sprintf("%d %t %v", status, req.ID != "", err))
+		body := []byte("custom")
+		w.WriteStatusLine("HTTP/1.1", status, http.StatusText(status))
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	}
+	handler := func(w *response.Writer, req *request.Request) {
+		errorpage.Error(w, req, http.StatusNotFound, errors.New("no route"))
+	}
+
+	for _, reqString := range []string{
+		"GET /missing HTTP/1.1\r\nHost: example.com\r\n\r\n",
+		"this is not a valid http request",
+	} {
+		conn := &MockConn{Reader: strings.NewReader(reqString), Builder: new(strings.Builder)}
+		srv := &Server{handler: handler, errorHandler: custom}
+		srv.handle(conn)
+		assert.Contains(t, conn.Builder.String(), "\r\n\r\ncustom")
+	}
+
+	require.Len(t, got, 2)
+	assert.Equal(t, "404 true no route", got[0])
+	assert.True(t, strings.HasPrefix(got[1], "400 true "))
+}
+
+func TestHandle_ParseErrorPage(t *testing.T) {
+	conn := &MockConn{Reader: strings.NewReader("this is not a valid http request"), Builder: new(strings.Builder)}
+	(&Server{}).handle(conn)
+
+	res, err := response.FromReader(strings.NewReader(conn.Builder.String()))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	assert.Equal(t, "text/plain", res.Headers.Get("content-type"))
+	assert.True(t, request.ValidID(res.Headers.Get("x-request-id")))
+	assert.Equal(t, "400 Bad Request: the request could not be parsed", string(res.Body))
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/abdo-355/http-from-tcp/internal/errorpage"
+	"github.com/abdo-355/http-from-tcp/internal/headers"
+	"github.com/abdo-355/http-from-tcp/internal/request"
+	"github.com/abdo-355/http-from-tcp/internal/response"
+)
+
+// ContinuePolicy decides when requests sent with Expect: 100-continue get their
+// 100 Continue interim response.
+type ContinuePolicy int
+
+const (
+	// ContinueOnRead sends 100 Continue when the handler calls req.ReadBody, so a handler
+	// can reject the request (e.g. with 413 or 417) before the client sends the body.
+	ContinueOnRead ContinuePolicy = iota
+	// ContinueImmediately sends 100 Continue and reads the body before calling the handler.
+	ContinueImmediately
+)
+
+// DefaultMaxBodySize is the largest request body the server accepts unless
+// WithMaxBodySize says otherwise.
+const DefaultMaxBodySize = 32 << 20
+
+// WithMaxBodySize sets the largest request body the server accepts. Requests announcing
+// a longer one are answered with 413 before any of the body is read.
+func WithMaxBodySize(n int64) Option {
+	return func(s *Server) {
+		s.maxBodySize = n
+	}
+}
+
+// WithContinuePolicy sets how Expect: 100-continue is answered. The default is ContinueOnRead.
+func WithContinuePolicy(p ContinuePolicy) Option {
+	return func(s *Server) {
+		s.continuePolicy = p
+	}
+}
+
+// prepareBody reads the request body or defers it according to the Expect header.
+// It reports false when it already wrote an error response.
+func (s *Server) prepareBody(w *response.Writer, req *request.Request) bool {
+	maxSize := s.maxBodySize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxBodySize
+	}
+	// the request parser only reads bodies framed by Content-Length, so checking the
+	// announced length keeps every larger body off the server
+	if n, err := strconv.ParseInt(req.Headers.Get("content-length"), 10, 64); err == nil && n > maxSize {
+		errorpage.Error(w, req, http.StatusRequestEntityTooLarge, fmt.Errorf("request body exceeds %d bytes", maxSize))
+		return false
+	}
+
+	expect := req.Headers.Get("expect")
+	switch {
+	case expect == "":
+	case !req.ExpectsContinue():
+		errorpage.Error(w, req, http.StatusExpectationFailed, fmt.Errorf("unsupported expectation: %s", expect))
+		return false
+	case s.continuePolicy == ContinueOnRead:
+		req.OnBodyRead(func() error {
+			return w.WriteInformational(http.StatusContinue, headers.NewHeaders())
+		})
+		return true
+	default:
+		if err := w.WriteInformational(http.StatusContinue, headers.NewHeaders()); err != nil {
+			req.Logger().Error("error writing 100 continue", "err", err)
+			return false
+		}
+	}
+
+	if err := req.ReadBody(); err != nil {
+		req.Logger().Warn("error reading request body", "err", err, "remote_addr", req.RemoteAddr)
+		errorpage.Error(w, req, http.StatusBadRequest, errors.New("malformed request body"))
+		return false
+	}
+	return true
+}
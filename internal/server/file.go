@@ -0,0 +1,55 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/abdo-355/http-from-tcp/internal/errorpage"
+	"github.com/abdo-355/http-from-tcp/internal/headers"
+	"github.com/abdo-355/http-from-tcp/internal/request"
+	"github.com/abdo-355/http-from-tcp/internal/response"
+)
+
+// ServeFile writes the file at path as the response. It attaches ETag and
+// Last-Modified validators and answers conditional requests with 304 or 412.
+func ServeFile(w *response.Writer, req *request.Request, path, contentType string) {
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() {
+		errorpage.Error(w, req, http.StatusNotFound, errors.New("file not found"))
+		return
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		errorpage.Error(w, req, http.StatusInternalServerError, fmt.Errorf("error reading file: %w", err))
+		return
+	}
+
+	etag := response.ContentETag(data)
+	modTime := info.ModTime()
+
+	h := headers.NewHeaders()
+	h.Set("etag", etag)
+	h.Set("last-modified", modTime.UTC().Format(http.TimeFormat))
+	h.Set("connection", "close")
+
+	switch status := CheckPreconditions(req, etag, modTime); status {
+	case http.StatusNotModified:
+		w.WriteStatusLine("HTTP/1.1", status, http.StatusText(status))
+		w.WriteHeaders(h)
+		return
+	case http.StatusPreconditionFailed:
+		errorpage.Error(w, req, status, nil)
+		return
+	}
+
+	h.Set("content-type", contentType)
+	// announced up front so the length survives a middleware flushing the body early
+	h.Set("content-length", strconv.Itoa(len(data)))
+	w.WriteStatusLine("HTTP/1.1", http.StatusOK, "OK")
+	w.WriteHeaders(h)
+	w.WriteBody(data)
+}
@@ -2,25 +2,68 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"log/slog"
 	"net"
+	"net/http"
 	"strconv"
+	"strings"
 	"sync/atomic"
+	"time"
 
-	"github.com/abdo-355/http-from-tcp/internal/httpwriter"
+	"github.com/abdo-355/http-from-tcp/internal/errorpage"
+	"github.com/abdo-355/http-from-tcp/internal/headers"
 	"github.com/abdo-355/http-from-tcp/internal/request"
 	"github.com/abdo-355/http-from-tcp/internal/response"
+	"github.com/abdo-355/http-from-tcp/internal/tracing"
 )
 
 type Server struct {
 	handler  Handler
 	Listener net.Listener
 	state    atomic.Bool
+	ctx      context.Context
+	cancel   context.CancelFunc
+
+	serverName     string
+	allowedMethods []string
+	continuePolicy ContinuePolicy
+	maxBodySize    int64
+	requestTimeout time.Duration
+	halfClose      bool
+	exporter       tracing.Exporter
+	errorHandler   ErrorHandler
 }
 
+// DefaultAllowedMethods is the Allow header sent in answer to "OPTIONS *".
+var DefaultAllowedMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+
+// Handler writes the response to req. Handlers answer HEAD requests (see req.IsHead) like
+// GET ones: the server keeps the headers they write and drops the body. The body of a request sent
+// with Expect: 100-continue is only available after calling req.ReadBody. After w.Hijack
+// the connection belongs to the handler, which has to close it. req.Context is cancelled
+// when the client closes the connection, the server closes, the request times out or the handler returns.
 type Handler func(w *response.Writer, req *request.Request)
 
-func Serve(port int, handler Handler) (*Server, error) {
+// Option configures optional Server behaviour.
+type Option func(*Server)
+
+// WithServerName sets the Server header sent on responses that do not set their own.
+func WithServerName(name string) Option {
+	return func(s *Server) {
+		s.serverName = name
+	}
+}
+
+// WithAllowedMethods sets the methods listed when answering "OPTIONS *".
+func WithAllowedMethods(methods ...string) Option {
+	return func(s *Server) {
+		s.allowedMethods = methods
+	}
+}
+
+func Serve(port int, handler Handler, opts ...Option) (*Server, error) {
 	listener, err := net.Listen("tcp", ":"+strconv.Itoa(port))
 	if err != nil {
 		return nil, err
@@ -30,6 +73,10 @@ func Serve(port int, handler Handler) (*Server, error) {
 		Listener: listener,
 		handler:  handler,
 	}
+	srv.ctx, srv.cancel = context.WithCancel(context.Background())
+	for _, opt := range opts {
+		opt(&srv)
+	}
 
 	srv.state.Store(true)
 
@@ -40,6 +87,9 @@ func Serve(port int, handler Handler) (*Server, error) {
 
 func (s *Server) Close() error {
 	s.state.Store(false)
+	if s.cancel != nil {
+		s.cancel()
+	}
 	err := s.Listener.Close()
 	return err
 }
@@ -60,18 +110,111 @@ func (s *Server) listen() {
 }
 
 func (s *Server) handle(conn net.Conn) {
-	defer conn.Close()
-	req, err := request.RequestFromReader(conn)
+	hijacked := false
+	defer func() {
+		// a hijacked connection belongs to the handler now
+		if !hijacked {
+			conn.Close()
+		}
+	}()
+
+	start := time.Now()
+	// the ID is picked before parsing so that a malformed request can be logged with it too
+	id := request.NewID()
+	src := &connReader{conn: conn, halfClose: s.halfClose}
+	req, err := request.HeadFromReader(src)
 	if err != nil {
-		slog.Warn("error parsing request", "err", err, "remote_addr", conn.RemoteAddr())
-		httpwriter.SendError(conn, 400)
+		slog.Warn("error parsing request", "err", err, "remote_addr", conn.RemoteAddr(), "request_id", id)
+		s.writeParseError(conn, id)
+		return
+	}
+	if addr := conn.RemoteAddr(); addr != nil {
+		req.RemoteAddr = addr.String()
+	}
+
+	req.ID = id
+	if incoming := req.Headers.Get(request.IDHeader); request.ValidID(incoming) {
+		req.ID = incoming
+	}
+
+	span := s.startSpan(req, start)
+
+	res := response.NewWriter(conn)
+	res.ServerName = s.serverName
+	res.SetHeader(request.IDHeader, req.ID)
+	res.SetConnReader(req.Unread())
+	// the watch has to end before the connection changes hands, or its pending read
+	// would race the hijacker for the next bytes
+	res.OnHijack(src.stopWatching)
+	if req.IsHead() {
+		res.OmitBody()
+	}
+	ctx, cancel := s.requestContext()
+	defer cancel()
+	if span != nil {
+		ctx = tracing.ContextWithSpan(ctx, span)
+	}
+	req.SetContext(s.errorContext(ctx))
+
+	if span != nil {
+		// timed where the body is read, which is inside the handler when it waits for
+		// 100 Continue or streams the body
+		req.OnBodyDone(func(start, end time.Time) { span.AddPhase("read_body", start, end) })
+	}
+	switch {
+	case !s.prepareBody(res, req):
+	case req.RequestLine.Method == "OPTIONS" && req.RequestLine.RequestTarget == "*":
+		s.writeServerOptions(res)
+	default:
+		src.watch(cancel)
+		var ok bool
+		phase(span, "handler", func() { ok = s.runHandler(res, req) })
+		if !ok {
+			s.finishSpan(span, res, req)
+			return
+		}
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) && res.State == response.WriteStatusLine && !res.Hijacked() {
+			errorpage.Error(res, req, http.StatusServiceUnavailable, errRequestTimeout)
+		}
+	}
+	if res.Hijacked() {
+		hijacked = true
+		s.finishSpan(span, res, req)
 		return
 	}
+	src.stopWatching()
+
+	phase(span, "write", func() {
+		if err := res.Finish(); err != nil {
+			req.Logger().Error("error writing response", "err", err)
+		}
+	})
+	s.finishSpan(span, res, req)
+}
 
-	res := response.New()
-	s.handler(res, req)
+// writeParseError answers a request that could not be parsed, which therefore never got
+// a Writer or a context.
+func (s *Server) writeParseError(conn net.Conn, id string) {
+	req := &request.Request{ID: id, Headers: headers.NewHeaders()}
+	res := response.NewWriter(conn)
+	res.ServerName = s.serverName
+	res.SetHeader(request.IDHeader, id)
+	req.SetContext(s.errorContext(req.Context()))
+	errorpage.Error(res, req, http.StatusBadRequest, errMalformedRequest)
+	if err := res.Finish(); err != nil {
+		req.Logger().Error("error writing response", "err", err)
+	}
+}
 
-	if err := httpwriter.Write(conn, res); err != nil {
-		slog.Error("error writing response", "err", err)
+// writeServerOptions answers "OPTIONS *", which asks about the server as a whole
+// rather than a resource, so it never reaches the handler.
+func (s *Server) writeServerOptions(w *response.Writer) {
+	methods := s.allowedMethods
+	if methods == nil {
+		methods = DefaultAllowedMethods
 	}
+	h := headers.NewHeaders()
+	h.Set("allow", strings.Join(methods, ", "))
+	w.WriteStatusLine("HTTP/1.1", http.StatusNoContent, http.StatusText(http.StatusNoContent))
+	w.WriteHeaders(h)
 }
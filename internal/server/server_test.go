@@ -1,27 +1,33 @@
 package server
 
 import (
+	"bytes"
+	"io"
+	"log/slog"
 	"net"
 	"net/http"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/abdo-355/http-from-tcp/internal/headers"
 	"github.com/abdo-355/http-from-tcp/internal/request"
 	"github.com/abdo-355/http-from-tcp/internal/response"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 type MockConn struct {
 	*strings.Reader
 	*strings.Builder
+	closed bool
 }
 
 func (mc *MockConn) Write(p []byte) (n int, err error) {
 	return mc.Builder.Write(p)
 }
 
-func (mc *MockConn) Close() error                       { return nil }
+func (mc *MockConn) Close() error                       { mc.closed = true; return nil }
 func (mc *MockConn) RemoteAddr() net.Addr               { return nil }
 func (mc *MockConn) LocalAddr() net.Addr                { return nil }
 func (mc *MockConn) SetDeadline(t time.Time) error      { return nil }
@@ -77,3 +83,293 @@ func TestHandle_HandlerWritesError(t *testing.T) {
 	assert.Contains(t, conn.Builder.String(), "Handler induced error")
 }
 
+func TestDecodeRequestBody(t *testing.T) {
+	handler := func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine("HTTP/1.1", http.StatusOK, "OK")
+		w.WriteHeaders(response.GetDefaultHeaders(len(req.Body)))
+		w.WriteBody(req.Body)
+	}
+	wrapped := DecodeRequestBody(1024)(handler)
+
+	req := newTestRequest("POST", map[string]string{"content-encoding": "br"})
+	req.Body = []byte("payload")
+	w := response.New()
+	wrapped(w, req)
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.StatusCode())
+
+	req = newTestRequest("POST", nil)
+	req.Body = []byte("payload")
+	w = response.New()
+	wrapped(w, req)
+	assert.Equal(t, http.StatusOK, w.StatusCode())
+	assert.Equal(t, "payload", string(w.Body()))
+}
+
+func TestHandle_FinalizesFraming(t *testing.T) {
+	reqString := "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"
+	conn := &MockConn{Reader: strings.NewReader(reqString), Builder: new(strings.Builder)}
+
+	handler := func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine("HTTP/1.1", http.StatusOK, "OK")
+		w.WriteHeaders(headers.NewHeaders())
+		w.WriteBody([]byte("Success"))
+	}
+
+	srv := &Server{handler: handler, serverName: "test"}
+	srv.handle(conn)
+
+	res, err := response.FromReader(strings.NewReader(conn.Builder.String()))
+	require.NoError(t, err)
+	assert.Equal(t, "7", res.Headers.Get("content-length"))
+	assert.Equal(t, "test", res.Headers.Get("server"))
+	assert.NotEmpty(t, res.Headers.Get("date"))
+	assert.Equal(t, "Success", string(res.Body))
+}
+
+func TestHandle_Head(t *testing.T) {
+	reqString := "HEAD /page HTTP/1.1\r\nHost: example.com\r\n\r\n"
+	conn := &MockConn{Reader: strings.NewReader(reqString), Builder: new(strings.Builder)}
+
+	var method string
+	handler := func(w *response.Writer, req *request.Request) {
+		method = req.RequestLine.Method
+		w.WriteStatusLine("HTTP/1.1", http.StatusOK, "OK")
+		w.WriteHeaders(headers.NewHeaders())
+		w.WriteBody([]byte("page body"))
+	}
+
+	srv := &Server{handler: handler}
+	srv.handle(conn)
+
+	assert.Equal(t, "HEAD", method)
+	res, err := response.FromReaderForMethod(strings.NewReader(conn.Builder.String()), "HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, "9", res.Headers.Get("content-length"))
+	assert.NotContains(t, conn.Builder.String(), "page body")
+}
+
+func TestHandle_OptionsAsterisk(t *testing.T) {
+	reqString := "OPTIONS * HTTP/1.1\r\nHost: example.com\r\n\r\n"
+	conn := &MockConn{Reader: strings.NewReader(reqString), Builder: new(strings.Builder)}
+
+	called := false
+	srv := &Server{
+		handler:        func(w *response.Writer, req *request.Request) { called = true },
+		allowedMethods: []string{"GET", "HEAD", "OPTIONS"},
+	}
+	srv.handle(conn)
+
+	assert.False(t, called)
+	res, err := response.FromReader(strings.NewReader(conn.Builder.String()))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, res.StatusCode)
+	assert.Equal(t, "GET, HEAD, OPTIONS", res.Headers.Get("allow"))
+}
+
+// splitConn delivers the request head and body in separate reads, like a client that
+// waits for 100 Continue before sending the body.
+type splitConn struct {
+	*MockConn
+	r io.Reader
+}
+
+func (c *splitConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func TestHandle_ExpectContinue(t *testing.T) {
+	const head = "PUT /upload HTTP/1.1\r\nHost: example.com\r\nContent-Length: 5\r\nExpect: 100-continue\r\n\r\n"
+
+	echo := func(w *response.Writer, req *request.Request) {
+		if req.Headers.Get("content-length") != "5" {
+			writePlain(w, http.StatusRequestEntityTooLarge, "too large")
+			return
+		}
+		if err := req.ReadBody(); err != nil {
+			writePlain(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writePlain(w, http.StatusOK, string(req.Body))
+	}
+
+	testCases := []struct {
+		name         string
+		head         string
+		body         string
+		policy       ContinuePolicy
+		wantContinue bool
+		wantStatus   int
+		wantBody     string
+	}{
+		{
+			name:         "Continue on read",
+			head:         head,
+			body:         "hello",
+			wantContinue: true,
+			wantStatus:   http.StatusOK,
+			wantBody:     "hello",
+		},
+		{
+			name:         "Continue immediately",
+			head:         head,
+			body:         "hello",
+			policy:       ContinueImmediately,
+			wantContinue: true,
+			wantStatus:   http.StatusOK,
+			wantBody:     "hello",
+		},
+		{
+			name:       "Rejected before the body",
+			head:       strings.Replace(head, "Content-Length: 5", "Content-Length: 50000", 1),
+			wantStatus: http.StatusRequestEntityTooLarge,
+			wantBody:   "too large",
+		},
+		{
+			name:       "Unknown expectation",
+			head:       strings.Replace(head, "100-continue", "something-else", 1),
+			body:       "hello",
+			wantStatus: http.StatusExpectationFailed,
+			wantBody:   "417 Expectation Failed: unsupported expectation: something-else",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mock := &MockConn{Builder: new(strings.Builder)}
+			conn := &splitConn{MockConn: mock, r: io.MultiReader(strings.NewReader(tc.head), strings.NewReader(tc.body))}
+			srv := &Server{handler: echo, continuePolicy: tc.policy}
+			srv.handle(conn)
+
+			out := mock.Builder.String()
+			assert.Equal(t, tc.wantContinue, strings.HasPrefix(out, "HTTP/1.1 100 Continue\r\n\r\n"))
+
+			res, err := response.FromReader(strings.NewReader(out))
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantStatus, res.StatusCode)
+			assert.Equal(t, tc.wantBody, string(res.Body))
+		})
+	}
+}
+
+func TestHandle_MaxBodySize(t *testing.T) {
+	testCases := []struct {
+		name       string
+		raw        string
+		wantStatus int
+	}{
+		{name: "Within limit", raw: "POST / HTTP/1.1\r\nContent-Length: 8\r\n\r\n12345678", wantStatus: http.StatusOK},
+		{name: "Announced too large", raw: "POST / HTTP/1.1\r\nContent-Length: 9\r\n\r\n123456789", wantStatus: http.StatusRequestEntityTooLarge},
+		{
+			name:       "Rejected before 100 Continue",
+			raw:        "POST / HTTP/1.1\r\nContent-Length: 9\r\nExpect: 100-continue\r\n\r\n",
+			wantStatus: http.StatusRequestEntityTooLarge,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			called := false
+			conn := &MockConn{Reader: strings.NewReader(tc.raw), Builder: new(strings.Builder)}
+			srv := &Server{maxBodySize: 8, handler: func(w *response.Writer, req *request.Request) {
+				called = true
+				writePlain(w, http.StatusOK, "ok")
+			}}
+			srv.handle(conn)
+
+			out := conn.Builder.String()
+			assert.False(t, strings.HasPrefix(out, "HTTP/1.1 100"))
+			res, err := response.FromReader(strings.NewReader(out))
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantStatus, res.StatusCode)
+			assert.Equal(t, tc.wantStatus == http.StatusOK, called)
+		})
+	}
+}
+
+func TestHandle_Hijack(t *testing.T) {
+	// the client sends data right after the request, e.g. the first bytes of a tunnel
+	reqString := "GET /raw HTTP/1.1\r\nHost: example.com\r\n\r\nearly bytes\n"
+	conn := &MockConn{Reader: strings.NewReader(reqString), Builder: new(strings.Builder)}
+
+	var early string
+	handler := func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine("HTTP/1.1", http.StatusOK, "OK")
+		_, rw, err := w.Hijack()
+		require.NoError(t, err)
+		early, err = rw.ReadString('\n')
+		require.NoError(t, err)
+		rw.WriteString("custom protocol")
+		require.NoError(t, rw.Flush())
+	}
+
+	srv := &Server{handler: handler}
+	srv.handle(conn)
+
+	assert.Equal(t, "early bytes\n", early)
+	assert.Equal(t, "custom protocol", conn.Builder.String())
+	assert.False(t, conn.closed)
+}
+
+func TestHandle_ClosesConnection(t *testing.T) {
+	conn := &MockConn{Reader: strings.NewReader("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"), Builder: new(strings.Builder)}
+	srv := &Server{handler: func(w *response.Writer, req *request.Request) {}}
+	srv.handle(conn)
+
+	assert.True(t, conn.closed)
+}
+
+func TestHandle_RequestID(t *testing.T) {
+	testCases := []struct {
+		name     string
+		incoming string
+		want     string
+	}{
+		{name: "Incoming", incoming: "abc-123", want: "abc-123"},
+		{name: "Invalid incoming", incoming: "abc 123"},
+		{name: "Missing"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			reqString := "GET / HTTP/1.1\r\nHost: example.com\r\n"
+			if tc.incoming != "" {
+				reqString += "X-Request-ID: " + tc.incoming + "\r\n"
+			}
+			conn := &MockConn{Reader: strings.NewReader(reqString + "\r\n"), Builder: new(strings.Builder)}
+
+			var id string
+			srv := &Server{handler: func(w *response.Writer, req *request.Request) {
+				id = req.ID
+				w.WriteStatusLine("HTTP/1.1", http.StatusOK, "OK")
+				w.WriteHeaders(headers.NewHeaders())
+			}}
+			srv.handle(conn)
+
+			if tc.want != "" {
+				assert.Equal(t, tc.want, id)
+			} else {
+				assert.True(t, request.ValidID(id))
+				assert.NotEqual(t, tc.incoming, id)
+			}
+			assert.Contains(t, conn.Builder.String(), "x-request-id: "+id+"\r\n")
+		})
+	}
+}
+
+func TestHandle_RequestIDInLogs(t *testing.T) {
+	var logs bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+
+	conn := &MockConn{Reader: strings.NewReader("this is not a valid http request"), Builder: new(strings.Builder)}
+	(&Server{}).handle(conn)
+
+	assert.Contains(t, logs.String(), "error parsing request")
+	assert.Regexp(t, `request_id=[0-9a-f]{32}`, logs.String())
+}
+
+func writePlain(w *response.Writer, status int, message string) {
+	w.WriteStatusLine("HTTP/1.1", status, http.StatusText(status))
+	w.WriteHeaders(response.GetDefaultHeaders(len(message)))
+	w.WriteBody([]byte(message))
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/abdo-355/http-from-tcp/internal/request"
+	"github.com/abdo-355/http-from-tcp/internal/response"
+	"github.com/abdo-355/http-from-tcp/internal/tracing"
+)
+
+// WithTracing records a server span for every request and hands the sampled ones to exp.
+// The span is available to handlers through tracing.SpanFromContext(req.Context()).
+func WithTracing(exp tracing.Exporter) Option {
+	return func(s *Server) {
+		s.exporter = exp
+	}
+}
+
+// startSpan starts the span of req, or returns nil when tracing is off.
+func (s *Server) startSpan(req *request.Request, start time.Time) *tracing.Span {
+	if s.exporter == nil {
+		return nil
+	}
+	span := tracing.StartServerSpan(req, start)
+	span.SetAttribute("request_id", req.ID)
+	if req.RemoteAddr != "" {
+		span.SetAttribute("net.peer", req.RemoteAddr)
+	}
+	span.AddPhase("parse_headers", start, time.Now())
+	return span
+}
+
+// phase runs fn and records it as a phase of span.
+func phase(span *tracing.Span, name string, fn func()) {
+	start := time.Now()
+	fn()
+	span.AddPhase(name, start, time.Now())
+}
+
+func (s *Server) finishSpan(span *tracing.Span, w *response.Writer, req *request.Request) {
+	if span == nil {
+		return
+	}
+	span.SetAttribute("http.status_code", strconv.Itoa(w.StatusCode()))
+	span.Finish(time.Now())
+	if !span.Sampled() {
+		return
+	}
+	if err := s.exporter.Export(span); err != nil {
+		req.Logger().Error("error exporting span", "err", err)
+	}
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abdo-355/http-from-tcp/internal/headers"
+	"github.com/abdo-355/http-from-tcp/internal/request"
+	"github.com/abdo-355/http-from-tcp/internal/response"
+	"github.com/abdo-355/http-from-tcp/internal/tracing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandle_Tracing(t *testing.T) {
+	testCases := []struct {
+		name        string
+		traceparent string
+		exported    bool
+	}{
+		{name: "New trace", exported: true},
+		{name: "Sampled parent", traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", exported: true},
+		{name: "Unsampled parent", traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", exported: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			reqString := "HEAD /items HTTP/1.1\r\nHost: example.com\r\nContent-Length: 4\r\n"
+			if tc.traceparent != "" {
+				reqString += "Traceparent: " + tc.traceparent + "\r\n"
+			}
+			conn := &MockConn{Reader: strings.NewReader(reqString + "\r\nbody"), Builder: new(strings.Builder)}
+
+			var inHandler *tracing.Span
+			exp := &tracing.MemoryExporter{}
+			srv := &Server{exporter: exp, handler: func(w *response.Writer, req *request.Request) {
+				inHandler = tracing.SpanFromContext(req.Context())
+				w.WriteStatusLine("HTTP/1.1", http.StatusAccepted, "Accepted")
+				w.WriteHeaders(headers.NewHeaders())
+			}}
+			srv.handle(conn)
+
+			require.NotNil(t, inHandler)
+			if !tc.exported {
+				assert.Empty(t, exp.Spans())
+				return
+			}
+			require.Len(t, exp.Spans(), 1)
+			span := exp.Spans()[0]
+			assert.Same(t, inHandler, span)
+			assert.Equal(t, "HEAD", span.Name)
+			assert.Equal(t, "202", span.Attributes["http.status_code"])
+			assert.NotEmpty(t, span.Attributes["request_id"])
+			assert.False(t, span.End.Before(span.Start))
+
+			var names []string
+			for _, p := range span.Phases {
+				names = append(names, p.Name)
+			}
+			assert.Equal(t, []string{"parse_headers", "read_body", "handler", "write"}, names)
+			if tc.traceparent != "" {
+				assert.Equal(t, tc.traceparent[3:35], span.TraceID)
+				assert.Equal(t, tc.traceparent[36:52], span.ParentID)
+			}
+		})
+	}
+}
+
+func TestHandle_TracingDeferredBody(t *testing.T) {
+	reqString := "POST /upload HTTP/1.1\r\nHost: example.com\r\nContent-Length: 4\r\nExpect: 100-continue\r\n\r\nbody"
+	conn := &MockConn{Reader: strings.NewReader(reqString), Builder: new(strings.Builder)}
+
+	exp := &tracing.MemoryExporter{}
+	srv := &Server{exporter: exp, handler: func(w *response.Writer, req *request.Request) {
+		time.Sleep(20 * time.Millisecond)
+		require.NoError(t, req.ReadBody())
+		w.WriteStatusLine("HTTP/1.1", http.StatusAccepted, "Accepted")
+		w.WriteHeaders(headers.NewHeaders())
+	}}
+	srv.handle(conn)
+
+	require.Len(t, exp.Spans(), 1)
+	phases := map[string]tracing.Phase{}
+	for _, p := range exp.Spans()[0].Phases {
+		phases[p.Name] = p
+	}
+	require.Contains(t, phases, "read_body")
+	require.Contains(t, phases, "handler")
+	assert.GreaterOrEqual(t, phases["read_body"].Start.Sub(phases["handler"].Start), 20*time.Millisecond,
+		"the body is read when the handler asks for it")
+}
@@ -0,0 +1,53 @@
+package sse
+
+import (
+	"strconv"
+	"sync"
+)
+
+// History keeps the most recent events so that reconnecting clients can catch up on
+// what they missed.
+type History struct {
+	mu     sync.Mutex
+	size   int
+	events []Event
+	nextID uint64
+}
+
+// NewHistory creates a History holding up to size events.
+func NewHistory(size int) *History {
+	return &History{size: size}
+}
+
+// Add stores e and returns it. Events without an ID get the next sequential one.
+func (h *History) Add(e Event) Event {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.nextID++
+	if e.ID == "" {
+		e.ID = strconv.FormatUint(h.nextID, 10)
+	}
+	h.events = append(h.events, e)
+	if len(h.events) > h.size {
+		h.events = h.events[len(h.events)-h.size:]
+	}
+	return e
+}
+
+// Since returns the events stored after the one with the given ID. A first connection
+// (empty ID) gets nothing, and an ID that is no longer stored gets everything kept.
+func (h *History) Since(lastEventID string) []Event {
+	if lastEventID == "" {
+		return nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i := len(h.events) - 1; i >= 0; i-- {
+		if h.events[i].ID == lastEventID {
+			return append([]Event(nil), h.events[i+1:]...)
+		}
+	}
+	return append([]Event(nil), h.events...)
+}
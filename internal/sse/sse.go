@@ -0,0 +1,200 @@
+// Package sse streams Server-Sent Events (text/event-stream) over a chunked response.
+package sse
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/abdo-355/http-from-tcp/internal/headers"
+	"github.com/abdo-355/http-from-tcp/internal/request"
+	"github.com/abdo-355/http-from-tcp/internal/response"
+)
+
+// DefaultHeartbeat is used when Options.Heartbeat is zero.
+const DefaultHeartbeat = 15 * time.Second
+
+// ErrClosed is returned when sending on a stream that was closed or whose client went away.
+var ErrClosed = errors.New("sse: stream closed")
+
+// Event is a single server-sent event. Only Data is required.
+type Event struct {
+	// ID becomes the client's Last-Event-ID when it reconnects.
+	ID string
+	// Event is the event type, "message" when empty.
+	Event string
+	// Data is the payload. Multi-line data is sent as several data fields.
+	Data string
+	// Retry tells the client how long to wait before reconnecting.
+	Retry time.Duration
+}
+
+// Options configures a Stream.
+type Options struct {
+	// Heartbeat is the interval between keep-alive comments. They keep proxies from
+	// closing an idle stream and notice clients that went away. Negative disables them.
+	Heartbeat time.Duration
+	// History, if set, replays the events the client missed since its Last-Event-ID.
+	History *History
+}
+
+// Stream writes events to a client. Its methods can be called from several goroutines.
+type Stream struct {
+	w           *response.Writer
+	lastEventID string
+
+	mu     sync.Mutex
+	closed bool
+	done   chan struct{}
+}
+
+// NewStream sends the response headers for an event stream and starts the heartbeat.
+// Call Close once the handler is done with the stream.
+func NewStream(w *response.Writer, req *request.Request, opts Options) (*Stream, error) {
+	h := headers.NewHeaders()
+	h.Set("content-type", "text/event-stream")
+	h.Set("cache-control", "no-cache")
+	w.WriteStatusLine("HTTP/1.1", http.StatusOK, http.StatusText(http.StatusOK))
+	w.WriteHeaders(h)
+
+	s := &Stream{
+		w:           w,
+		lastEventID: req.Headers.Get("last-event-id"),
+		done:        make(chan struct{}),
+	}
+	if err := s.flush(); err != nil {
+		return nil, err
+	}
+	context.AfterFunc(req.Context(), s.Close)
+
+	if opts.History != nil {
+		for _, e := range opts.History.Since(s.lastEventID) {
+			if err := s.Send(e); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	interval := opts.Heartbeat
+	if interval == 0 {
+		interval = DefaultHeartbeat
+	}
+	if interval > 0 {
+		go s.heartbeat(interval)
+	}
+	return s, nil
+}
+
+// LastEventID is the ID of the last event the client received before reconnecting,
+// or "" on a first connection.
+func (s *Stream) LastEventID() string {
+	return s.lastEventID
+}
+
+// Done is closed when the stream is closed, the client disconnects or the request's
+// context is done.
+func (s *Stream) Done() <-chan struct{} {
+	return s.done
+}
+
+// Send writes e and flushes it to the client right away.
+func (s *Stream) Send(e Event) error {
+	var b strings.Builder
+	if e.ID != "" {
+		writeField(&b, "id", singleLine(e.ID))
+	}
+	if e.Event != "" {
+		writeField(&b, "event", singleLine(e.Event))
+	}
+	if e.Retry > 0 {
+		writeField(&b, "retry", strconv.FormatInt(e.Retry.Milliseconds(), 10))
+	}
+	for _, line := range splitLines(e.Data) {
+		writeField(&b, "data", line)
+	}
+	b.WriteString("\n")
+	return s.write(b.String())
+}
+
+// Comment writes a comment line, which clients ignore.
+func (s *Stream) Comment(text string) error {
+	var b strings.Builder
+	for _, line := range splitLines(text) {
+		b.WriteString(":" + line + "\n")
+	}
+	b.WriteString("\n")
+	return s.write(b.String())
+}
+
+// Close stops the heartbeat. The server ends the response once the handler returns.
+func (s *Stream) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.close()
+}
+
+func (s *Stream) write(data string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	s.w.WriteBody([]byte(data))
+	if err := s.w.Flush(); err != nil {
+		// the client went away
+		s.close()
+		return err
+	}
+	return nil
+}
+
+func (s *Stream) flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Flush()
+}
+
+func (s *Stream) close() {
+	if !s.closed {
+		s.closed = true
+		close(s.done)
+	}
+}
+
+func (s *Stream) heartbeat(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			if err := s.Comment(" heartbeat"); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func writeField(b *strings.Builder, name, value string) {
+	b.WriteString(name)
+	b.WriteString(": ")
+	b.WriteString(value)
+	b.WriteString("\n")
+}
+
+// splitLines splits on every line ending the format accepts: CRLF, LF and CR.
+func splitLines(s string) []string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	s = strings.ReplaceAll(s, "\r", "\n")
+	return strings.Split(s, "\n")
+}
+
+// singleLine drops line breaks, which would end the field early.
+func singleLine(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
@@ -0,0 +1,196 @@
+package sse
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/abdo-355/http-from-tcp/internal/headers"
+	"github.com/abdo-355/http-from-tcp/internal/request"
+	"github.com/abdo-355/http-from-tcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// syncBuffer is written to by the heartbeat goroutine while the test reads it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+	err error
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err != nil {
+		return 0, b.err
+	}
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func (b *syncBuffer) fail(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.err = err
+}
+
+func newRequest(lastEventID string) *request.Request {
+	h := headers.NewHeaders()
+	if lastEventID != "" {
+		h.Set("last-event-id", lastEventID)
+	}
+	return &request.Request{
+		RequestLine: request.RequestLine{Method: "GET", RequestTarget: "/events", HTTPVersion: "1.1"},
+		Headers:     h,
+	}
+}
+
+// body finishes the response and returns its decoded body.
+func body(t *testing.T, w *response.Writer, out *syncBuffer) string {
+	t.Helper()
+	require.NoError(t, w.Finish())
+	res, err := response.FromReader(strings.NewReader(out.String()))
+	require.NoError(t, err)
+	assert.Equal(t, "text/event-stream", res.Headers.Get("content-type"))
+	assert.Equal(t, "chunked", res.Headers.Get("transfer-encoding"))
+	return string(res.Body)
+}
+
+func TestSend(t *testing.T) {
+	testCases := []struct {
+		name     string
+		event    Event
+		expected string
+	}{
+		{name: "Data only", event: Event{Data: "hello"}, expected: "data: hello\n\n"},
+		{
+			name:     "All fields",
+			event:    Event{ID: "7", Event: "log", Data: "started", Retry: 3 * time.Second},
+			expected: "id: 7\nevent: log\nretry: 3000\ndata: started\n\n",
+		},
+		{name: "Multi-line data", event: Event{Data: "a\nb\r\nc\rd"}, expected: "data: a\ndata: b\ndata: c\ndata: d\n\n"},
+		{name: "Empty data", event: Event{}, expected: "data: \n\n"},
+		{name: "Line breaks in id and event", event: Event{ID: "1\n2", Event: "x\ry", Data: "z"}, expected: "id: 12\nevent: xy\ndata: z\n\n"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			out := &syncBuffer{}
+			w := response.NewWriter(out)
+			s, err := NewStream(w, newRequest(""), Options{Heartbeat: -1})
+			require.NoError(t, err)
+
+			require.NoError(t, s.Send(tc.event))
+			s.Close()
+			assert.Equal(t, tc.expected, body(t, w, out))
+		})
+	}
+}
+
+func TestSend_FlushesEachEvent(t *testing.T) {
+	out := &syncBuffer{}
+	w := response.NewWriter(out)
+	s, err := NewStream(w, newRequest(""), Options{Heartbeat: -1})
+	require.NoError(t, err)
+	defer s.Close()
+
+	assert.Contains(t, out.String(), "content-type: text/event-stream\r\n")
+	require.NoError(t, s.Send(Event{Data: "first"}))
+	assert.Contains(t, out.String(), "data: first\n\n")
+}
+
+func TestHeartbeat(t *testing.T) {
+	out := &syncBuffer{}
+	w := response.NewWriter(out)
+	s, err := NewStream(w, newRequest(""), Options{Heartbeat: 5 * time.Millisecond})
+	require.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		return strings.Contains(out.String(), ": heartbeat\n\n")
+	}, time.Second, 5*time.Millisecond)
+	s.Close()
+}
+
+func TestClientDisconnect(t *testing.T) {
+	out := &syncBuffer{}
+	w := response.NewWriter(out)
+	s, err := NewStream(w, newRequest(""), Options{Heartbeat: 5 * time.Millisecond})
+	require.NoError(t, err)
+
+	out.fail(errors.New("broken pipe"))
+	select {
+	case <-s.Done():
+	case <-time.After(time.Second):
+		t.Fatal("stream not closed after the client went away")
+	}
+	assert.ErrorIs(t, s.Send(Event{Data: "late"}), ErrClosed)
+}
+
+func TestRequestContextDone(t *testing.T) {
+	req := newRequest("")
+	ctx, cancel := context.WithCancel(context.Background())
+	req.SetContext(ctx)
+	s, err := NewStream(response.NewWriter(&syncBuffer{}), req, Options{Heartbeat: -1})
+	require.NoError(t, err)
+
+	cancel()
+	select {
+	case <-s.Done():
+	case <-time.After(time.Second):
+		t.Fatal("stream not closed after the request context was cancelled")
+	}
+}
+
+func TestLastEventID(t *testing.T) {
+	history := NewHistory(3)
+	for _, data := range []string{"one", "two", "three", "four"} {
+		history.Add(Event{Data: data})
+	}
+
+	out := &syncBuffer{}
+	w := response.NewWriter(out)
+	s, err := NewStream(w, newRequest("2"), Options{Heartbeat: -1, History: history})
+	require.NoError(t, err)
+	assert.Equal(t, "2", s.LastEventID())
+	s.Close()
+
+	assert.Equal(t, "id: 3\ndata: three\n\nid: 4\ndata: four\n\n", body(t, w, out))
+}
+
+func TestHistory_Since(t *testing.T) {
+	history := NewHistory(2)
+	history.Add(Event{Data: "a"})
+	history.Add(Event{ID: "custom", Data: "b"})
+	history.Add(Event{Data: "c"})
+
+	testCases := []struct {
+		name     string
+		id       string
+		expected []string
+	}{
+		{name: "First connection", id: "", expected: nil},
+		{name: "Known id", id: "custom", expected: []string{"c"}},
+		{name: "Latest id", id: "3", expected: nil},
+		{name: "Evicted id", id: "1", expected: []string{"b", "c"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var data []string
+			for _, e := range history.Since(tc.id) {
+				data = append(data, e.Data)
+			}
+			assert.Equal(t, tc.expected, data)
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package tracing
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+)
+
+// Exporter receives finished, sampled spans. Export may be called from several goroutines.
+type Exporter interface {
+	Export(s *Span) error
+}
+
+// MemoryExporter keeps exported spans in memory, mostly for tests and debugging.
+type MemoryExporter struct {
+	mu    sync.Mutex
+	spans []*Span
+}
+
+func (e *MemoryExporter) Export(s *Span) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, s)
+	return nil
+}
+
+// Spans returns the spans exported so far.
+func (e *MemoryExporter) Spans() []*Span {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]*Span(nil), e.spans...)
+}
+
+// JSONExporter writes every span as a line of JSON.
+type JSONExporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewJSONExporter creates a JSONExporter writing to w.
+func NewJSONExporter(w io.Writer) *JSONExporter {
+	return &JSONExporter{w: w}
+}
+
+// NewFileExporter creates a JSONExporter appending to the file at path. Close closes the file.
+func NewFileExporter(path string) (*JSONExporter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return NewJSONExporter(f), nil
+}
+
+func (e *JSONExporter) Export(s *Span) error {
+	s.mu.Lock()
+	line, err := json.Marshal(s)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err = e.w.Write(append(line, '\n'))
+	return err
+}
+
+// Close closes the underlying writer if it is an io.Closer.
+func (e *JSONExporter) Close() error {
+	if c, ok := e.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
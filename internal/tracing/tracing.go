@@ -0,0 +1,141 @@
+// Package tracing records spans for requests and propagates W3C Trace Context
+// (traceparent and tracestate) to the services they call.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/abdo-355/http-from-tcp/internal/headers"
+	"github.com/abdo-355/http-from-tcp/internal/request"
+)
+
+// KindServer is the kind of spans covering a request handled by the server.
+const KindServer = "server"
+
+// Span is a timed operation within a trace. A nil *Span ignores every call, so code can
+// record into it without checking whether tracing is enabled.
+type Span struct {
+	TraceID    string            `json:"trace_id"`
+	SpanID     string            `json:"span_id"`
+	ParentID   string            `json:"parent_id,omitempty"`
+	Name       string            `json:"name"`
+	Kind       string            `json:"kind"`
+	Start      time.Time         `json:"start"`
+	End        time.Time         `json:"end"`
+	Phases     []Phase           `json:"phases,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+
+	mu     sync.Mutex
+	parent request.TraceParent
+	state  request.TraceState
+}
+
+// Phase is a named part of a span, like reading the request body.
+type Phase struct {
+	Name     string        `json:"name"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration"`
+}
+
+// StartServerSpan starts the span of a server handling req. It continues the trace from
+// req's traceparent when it is valid, and starts a new, sampled trace otherwise.
+func StartServerSpan(req *request.Request, start time.Time) *Span {
+	tp, ok := req.TraceParent()
+	s := &Span{
+		Name:  req.RequestLine.Method,
+		Kind:  KindServer,
+		Start: start,
+		Attributes: map[string]string{
+			"http.method": req.RequestLine.Method,
+			"http.target": req.RequestLine.RequestTarget,
+		},
+	}
+	if ok {
+		s.ParentID = hex.EncodeToString(tp.ParentID[:])
+		s.state = req.TraceState()
+	} else {
+		rand.Read(tp.TraceID[:])
+		tp.Flags = request.FlagSampled
+	}
+	rand.Read(tp.ParentID[:])
+	tp.Version = 0
+	s.parent = tp
+	s.TraceID = hex.EncodeToString(tp.TraceID[:])
+	s.SpanID = hex.EncodeToString(tp.ParentID[:])
+	return s
+}
+
+// AddPhase records a part of the span that ran from start to end.
+func (s *Span) AddPhase(name string, start, end time.Time) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Phases = append(s.Phases, Phase{Name: name, Start: start, Duration: end.Sub(start)})
+}
+
+// SetAttribute attaches a key/value pair to the span.
+func (s *Span) SetAttribute(key, value string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Attributes == nil {
+		s.Attributes = map[string]string{}
+	}
+	s.Attributes[key] = value
+}
+
+// Finish ends the span at end.
+func (s *Span) Finish(end time.Time) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.End = end
+}
+
+// Sampled reports whether the span should be exported, following the caller's decision.
+func (s *Span) Sampled() bool {
+	return s != nil && s.parent.Sampled()
+}
+
+// TraceParent is the traceparent to send to services called within the span.
+func (s *Span) TraceParent() request.TraceParent {
+	return s.parent
+}
+
+type spanKey struct{}
+
+// ContextWithSpan returns a copy of ctx carrying s.
+func ContextWithSpan(ctx context.Context, s *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, s)
+}
+
+// SpanFromContext returns the span carried by ctx, or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// Inject sets the traceparent and tracestate headers of an outgoing request to continue
+// the trace of the span in ctx. Without a span, h is left alone, so headers copied from
+// the incoming request pass through unchanged.
+func Inject(ctx context.Context, h headers.Headers) {
+	s := SpanFromContext(ctx)
+	if s == nil {
+		return
+	}
+	h.Set("traceparent", s.parent.String())
+	h.Del("tracestate")
+	if len(s.state) > 0 {
+		h.Set("tracestate", s.state.String())
+	}
+}
@@ -0,0 +1,125 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abdo-355/http-from-tcp/internal/headers"
+	"github.com/abdo-355/http-from-tcp/internal/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRequest(h map[string]string) *request.Request {
+	req := &request.Request{
+		RequestLine: request.RequestLine{Method: "GET", RequestTarget: "/items", HTTPVersion: "1.1"},
+		Headers:     headers.NewHeaders(),
+	}
+	for k, v := range h {
+		req.Headers.Set(k, v)
+	}
+	return req
+}
+
+func TestStartServerSpan(t *testing.T) {
+	t.Run("Continues the incoming trace", func(t *testing.T) {
+		span := StartServerSpan(newRequest(map[string]string{
+			"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00",
+			"tracestate":  "rojo=00f067aa0ba902b7",
+		}), time.Now())
+
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.TraceID)
+		assert.Equal(t, "00f067aa0ba902b7", span.ParentID)
+		assert.Len(t, span.SpanID, 16)
+		assert.NotEqual(t, span.ParentID, span.SpanID)
+		assert.False(t, span.Sampled())
+		assert.Equal(t, "GET", span.Name)
+		assert.Equal(t, "/items", span.Attributes["http.target"])
+	})
+
+	t.Run("Starts a new trace", func(t *testing.T) {
+		span := StartServerSpan(newRequest(map[string]string{"traceparent": "garbage"}), time.Now())
+
+		assert.Len(t, span.TraceID, 32)
+		assert.Empty(t, span.ParentID)
+		assert.True(t, span.Sampled())
+	})
+}
+
+func TestInject(t *testing.T) {
+	h := headers.NewHeaders()
+	h.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	h.Set("tracestate", "invalid")
+
+	Inject(context.Background(), h)
+	assert.Equal(t, "invalid", h.Get("tracestate"), "headers are left alone without a span")
+
+	span := StartServerSpan(newRequest(map[string]string{
+		"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"tracestate":  "rojo=1,congo=2",
+	}), time.Now())
+	Inject(ContextWithSpan(context.Background(), span), h)
+
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-"+span.SpanID+"-01", h.Get("traceparent"))
+	assert.Equal(t, "rojo=1,congo=2", h.Get("tracestate"))
+}
+
+func TestNilSpan(t *testing.T) {
+	var span *Span
+	assert.NotPanics(t, func() {
+		span.AddPhase("handler", time.Now(), time.Now())
+		span.SetAttribute("k", "v")
+		span.Finish(time.Now())
+	})
+	assert.False(t, span.Sampled())
+	assert.Nil(t, SpanFromContext(context.Background()))
+}
+
+func TestExporters(t *testing.T) {
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	span := StartServerSpan(newRequest(nil), start)
+	span.AddPhase("handler", start, start.Add(3*time.Millisecond))
+	span.Finish(start.Add(5 * time.Millisecond))
+
+	mem := &MemoryExporter{}
+	require.NoError(t, mem.Export(span))
+	assert.Equal(t, []*Span{span}, mem.Spans())
+
+	var out bytes.Buffer
+	exp := NewJSONExporter(&out)
+	require.NoError(t, exp.Export(span))
+	require.NoError(t, exp.Export(span))
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	require.Len(t, lines, 2)
+	var got map[string]any
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &got))
+	assert.Equal(t, span.TraceID, got["trace_id"])
+	assert.Equal(t, "server", got["kind"])
+	assert.Equal(t, "2026-01-02T03:04:05.005Z", got["end"])
+	phases := got["phases"].([]any)
+	assert.Equal(t, "handler", phases[0].(map[string]any)["name"])
+	assert.Equal(t, float64(3*time.Millisecond), phases[0].(map[string]any)["duration"])
+}
+
+func TestFileExporter(t *testing.T) {
+	path := t.TempDir() + "/spans.jsonl"
+	exp, err := NewFileExporter(path)
+	require.NoError(t, err)
+	require.NoError(t, exp.Export(StartServerSpan(newRequest(nil), time.Now())))
+	require.NoError(t, exp.Close())
+
+	exp, err = NewFileExporter(path)
+	require.NoError(t, err)
+	require.NoError(t, exp.Export(StartServerSpan(newRequest(nil), time.Now())))
+	require.NoError(t, exp.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(data), "\n"), "the file is appended to")
+}
@@ -0,0 +1,214 @@
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/abdo-355/http-from-tcp/internal/errorpage"
+	"github.com/abdo-355/http-from-tcp/internal/headers"
+	"github.com/abdo-355/http-from-tcp/internal/request"
+	"github.com/abdo-355/http-from-tcp/internal/response"
+)
+
+// acceptGUID is appended to the client key to compute Sec-WebSocket-Accept (RFC 6455 section 1.3).
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const handshakeTimeout = 10 * time.Second
+
+var errOriginNotAllowed = errors.New("origin is not allowed")
+
+// AcceptKey computes the Sec-WebSocket-Accept value for a Sec-WebSocket-Key.
+func AcceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// IsUpgrade reports whether req asks to switch to the WebSocket protocol.
+func IsUpgrade(req *request.Request) bool {
+	return hasToken(req.Headers.Get("connection"), "upgrade") &&
+		hasToken(req.Headers.Get("upgrade"), "websocket")
+}
+
+// Upgrader holds the options of the opening handshake.
+type Upgrader struct {
+	// CheckOrigin reports whether a handshake from the request's Origin is accepted.
+	// Browsers let any page open a WebSocket, so without a check another site could act
+	// with the user's cookies. Nil accepts requests without an Origin header and those
+	// whose Origin has the same host as the Host header.
+	CheckOrigin func(req *request.Request) bool
+}
+
+// Upgrade completes the opening handshake for req with the default Upgrader.
+func Upgrade(w *response.Writer, req *request.Request) (*Conn, error) {
+	return (&Upgrader{}).Upgrade(w, req)
+}
+
+// Upgrade completes the opening handshake for req and takes the connection over.
+// When the request is not a valid handshake it answers with 400 (or 426 for an
+// unsupported version), when its origin is refused with 403, and returns the reason
+// as error.
+func (u *Upgrader) Upgrade(w *response.Writer, req *request.Request) (*Conn, error) {
+	if err := checkHandshake(req); err != nil {
+		status := http.StatusBadRequest
+		if v := req.Headers.Get("sec-websocket-version"); v != "" && v != "13" {
+			status = http.StatusUpgradeRequired
+			w.SetHeader("sec-websocket-version", "13")
+		}
+		errorpage.Error(w, req, status, err)
+		return nil, err
+	}
+	checkOrigin := u.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = sameOrigin
+	}
+	if !checkOrigin(req) {
+		// the client only learns that its origin was refused, not which one was seen
+		errorpage.Error(w, req, http.StatusForbidden, errOriginNotAllowed)
+		return nil, fmt.Errorf("%w: %q", errOriginNotAllowed, req.Headers.Get("origin"))
+	}
+
+	conn, rw, err := w.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	head := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"upgrade: websocket\r\n" +
+		"connection: Upgrade\r\n" +
+		"sec-websocket-accept: " + AcceptKey(req.Headers.Get("sec-websocket-key")) + "\r\n\r\n"
+	rw.WriteString(head)
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return newConn(conn, rw.Reader, false), nil
+}
+
+// sameOrigin accepts requests without an Origin, which browsers always send, and those
+// whose Origin names the host they were sent to.
+func sameOrigin(req *request.Request) bool {
+	origin := req.Headers.Get("origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, req.Headers.Get("host"))
+}
+
+func checkHandshake(req *request.Request) error {
+	switch {
+	case req.RequestLine.Method != "GET":
+		return fmt.Errorf("websocket handshake must use GET, got %s", req.RequestLine.Method)
+	case !IsUpgrade(req):
+		return fmt.Errorf("missing Connection: Upgrade and Upgrade: websocket headers")
+	case req.Headers.Get("sec-websocket-version") != "13":
+		return fmt.Errorf("unsupported websocket version %q", req.Headers.Get("sec-websocket-version"))
+	}
+
+	key, err := base64.StdEncoding.DecodeString(req.Headers.Get("sec-websocket-key"))
+	if err != nil || len(key) != 16 {
+		return fmt.Errorf("invalid Sec-WebSocket-Key")
+	}
+	return nil
+}
+
+// Dial opens a client connection to a ws:// or wss:// URL. h holds extra handshake
+// headers, like Origin.
+func Dial(rawURL string, h headers.Headers) (*Conn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var conn net.Conn
+	dialer := &net.Dialer{Timeout: handshakeTimeout}
+	switch u.Scheme {
+	case "ws":
+		conn, err = dialer.Dial("tcp", hostPort(u, "80"))
+	case "wss":
+		conn, err = tls.DialWithDialer(dialer, "tcp", hostPort(u, "443"), &tls.Config{ServerName: u.Hostname()})
+	default:
+		return nil, fmt.Errorf("unsupported websocket scheme: %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := clientHandshake(conn, u, h)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func clientHandshake(conn net.Conn, u *url.URL, extra headers.Headers) (*Conn, error) {
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	h := headers.NewHeaders()
+	for k, v := range extra.M {
+		h.Set(k, v)
+	}
+	h.Set("host", u.Host)
+	h.Set("connection", "Upgrade")
+	h.Set("upgrade", "websocket")
+	h.Set("sec-websocket-version", "13")
+	h.Set("sec-websocket-key", key)
+
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	w := request.NewWriter(conn)
+	if err := w.WriteRequestLine("GET", u.RequestURI()); err != nil {
+		return nil, err
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	res, err := response.FromReader(br)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		return nil, fmt.Errorf("websocket handshake failed: %d %s", res.StatusCode, res.Reason)
+	}
+	if res.Headers.Get("sec-websocket-accept") != AcceptKey(key) {
+		return nil, fmt.Errorf("websocket handshake failed: bad Sec-WebSocket-Accept")
+	}
+
+	return newConn(conn, br, true), nil
+}
+
+func hostPort(u *url.URL, defaultPort string) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	return net.JoinHostPort(u.Hostname(), defaultPort)
+}
+
+func hasToken(value, token string) bool {
+	for part := range strings.SplitSeq(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(part), token) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,392 @@
+// Package websocket implements the WebSocket protocol (RFC 6455) on top of connections
+// hijacked from the server, along with a client to dial WebSocket servers.
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+type MessageType int
+
+const (
+	TextMessage   MessageType = 1
+	BinaryMessage MessageType = 2
+)
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+// Close codes from RFC 6455 section 7.4.1.
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseUnsupportedData = 1003
+	CloseNoStatus        = 1005
+	CloseInvalidPayload  = 1007
+	ClosePolicyViolation = 1008
+	CloseMessageTooBig   = 1009
+	CloseInternalError   = 1011
+)
+
+const (
+	// DefaultMaxMessageSize is used when Conn.MaxMessageSize is zero.
+	DefaultMaxMessageSize = 1 << 20
+
+	maxControlPayload = 125
+	closeTimeout      = 5 * time.Second
+)
+
+// ErrClosed is returned when writing after the close frame was sent.
+var ErrClosed = errors.New("websocket: close frame already sent")
+
+// CloseError is returned by ReadMessage once the connection is closed, either by the
+// peer or because the peer broke the protocol.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket: closed with %d %s", e.Code, e.Reason)
+}
+
+// Conn is a WebSocket connection. ReadMessage must be called from a single goroutine,
+// the write methods can be called concurrently.
+type Conn struct {
+	// MaxMessageSize limits the size of a reassembled message. A bigger message closes
+	// the connection with CloseMessageTooBig.
+	MaxMessageSize int64
+	// FragmentSize splits outgoing messages into frames of at most this many bytes.
+	// Zero sends every message as a single frame.
+	FragmentSize int
+	// OnPong is called with the payload of every pong received.
+	OnPong func(payload []byte)
+
+	conn   net.Conn
+	br     *bufio.Reader
+	client bool
+
+	writeMu   sync.Mutex
+	closeSent bool
+}
+
+func newConn(conn net.Conn, br *bufio.Reader, client bool) *Conn {
+	return &Conn{conn: conn, br: br, client: client}
+}
+
+type frame struct {
+	fin     bool
+	opcode  byte
+	payload []byte
+}
+
+// ReadMessage returns the next data message, reassembling fragmented ones. Pings are
+// answered automatically. Once the peer sends a close frame it is echoed back, the
+// connection is closed and a *CloseError is returned.
+func (c *Conn) ReadMessage() (MessageType, []byte, error) {
+	var msgType MessageType
+	var msg []byte
+	inMessage := false
+
+	for {
+		f, err := c.readFrame(c.maxMessageSize() - int64(len(msg)))
+		if err != nil {
+			var ce *CloseError
+			if errors.As(err, &ce) {
+				return 0, nil, c.fail(ce.Code, ce.Reason)
+			}
+			return 0, nil, err
+		}
+
+		switch f.opcode {
+		case opPing:
+			if err := c.writeFrame(opPong, f.payload); err != nil && !errors.Is(err, ErrClosed) {
+				return 0, nil, err
+			}
+			continue
+		case opPong:
+			if c.OnPong != nil {
+				c.OnPong(f.payload)
+			}
+			continue
+		case opClose:
+			return 0, nil, c.handleClose(f.payload)
+		case opText, opBinary:
+			if inMessage {
+				return 0, nil, c.fail(CloseProtocolError, "expected a continuation frame")
+			}
+			msgType = MessageType(f.opcode)
+			inMessage = true
+		case opContinuation:
+			if !inMessage {
+				return 0, nil, c.fail(CloseProtocolError, "unexpected continuation frame")
+			}
+		default:
+			return 0, nil, c.fail(CloseProtocolError, fmt.Sprintf("unknown opcode %d", f.opcode))
+		}
+
+		msg = append(msg, f.payload...)
+		if !f.fin {
+			continue
+		}
+		if msgType == TextMessage && !utf8.Valid(msg) {
+			return 0, nil, c.fail(CloseInvalidPayload, "text message is not valid UTF-8")
+		}
+		return msgType, msg, nil
+	}
+}
+
+// readFrame reads a single frame whose payload may not exceed limit bytes unless it is
+// a control frame. Protocol violations are reported as *CloseError.
+func (c *Conn) readFrame(limit int64) (frame, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.br, head[:]); err != nil {
+		return frame{}, err
+	}
+
+	f := frame{fin: head[0]&0x80 != 0, opcode: head[0] & 0x0f}
+	if head[0]&0x70 != 0 {
+		return f, &CloseError{Code: CloseProtocolError, Reason: "reserved bits set"}
+	}
+	// clients mask every frame they send and servers never do (RFC 6455 section 5.1)
+	masked := head[1]&0x80 != 0
+	if masked == c.client {
+		return f, &CloseError{Code: CloseProtocolError, Reason: "invalid frame masking"}
+	}
+
+	length := int64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return f, err
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return f, err
+		}
+		n := binary.BigEndian.Uint64(ext[:])
+		if n > math.MaxInt64 {
+			return f, &CloseError{Code: CloseProtocolError, Reason: "invalid payload length"}
+		}
+		length = int64(n)
+	}
+
+	if f.opcode >= opClose {
+		if !f.fin || length > maxControlPayload {
+			return f, &CloseError{Code: CloseProtocolError, Reason: "invalid control frame"}
+		}
+	} else if length > limit {
+		return f, &CloseError{Code: CloseMessageTooBig, Reason: "message too big"}
+	}
+
+	var key [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.br, key[:]); err != nil {
+			return f, err
+		}
+	}
+	f.payload = make([]byte, length)
+	if _, err := io.ReadFull(c.br, f.payload); err != nil {
+		return f, err
+	}
+	if masked {
+		maskBytes(key, f.payload)
+	}
+	return f, nil
+}
+
+// WriteMessage sends data as a single message, split into frames of FragmentSize bytes.
+func (c *Conn) WriteMessage(t MessageType, data []byte) error {
+	if t != TextMessage && t != BinaryMessage {
+		return fmt.Errorf("websocket: invalid message type %d", t)
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent {
+		return ErrClosed
+	}
+
+	opcode := byte(t)
+	for {
+		n := len(data)
+		if c.FragmentSize > 0 && n > c.FragmentSize {
+			n = c.FragmentSize
+		}
+		fin := n == len(data)
+		if err := c.writeFrameLocked(opcode, fin, data[:n]); err != nil {
+			return err
+		}
+		if fin {
+			return nil
+		}
+		data = data[n:]
+		opcode = opContinuation
+	}
+}
+
+// Ping sends a ping control frame. The peer answers with a pong carrying the same payload.
+func (c *Conn) Ping(payload []byte) error {
+	if len(payload) > maxControlPayload {
+		return fmt.Errorf("websocket: ping payload longer than %d bytes", maxControlPayload)
+	}
+	return c.writeFrame(opPing, payload)
+}
+
+// Close runs the closing handshake: it sends a close frame, waits for the peer's close
+// frame, dropping any messages still in flight, and closes the connection.
+func (c *Conn) Close(code int, reason string) error {
+	if err := c.sendClose(code, reason); err == nil {
+		c.conn.SetReadDeadline(time.Now().Add(closeTimeout))
+		for {
+			f, err := c.readFrame(c.maxMessageSize())
+			if err != nil || f.opcode == opClose {
+				break
+			}
+		}
+	}
+	return c.conn.Close()
+}
+
+func (c *Conn) handleClose(payload []byte) error {
+	ce := &CloseError{Code: CloseNoStatus}
+	switch {
+	case len(payload) == 1:
+		return c.fail(CloseProtocolError, "invalid close frame")
+	case len(payload) >= 2:
+		ce.Code = int(binary.BigEndian.Uint16(payload))
+		ce.Reason = string(payload[2:])
+		if !validCloseCode(ce.Code) {
+			return c.fail(CloseProtocolError, "invalid close code")
+		}
+		if !utf8.ValidString(ce.Reason) {
+			return c.fail(CloseInvalidPayload, "close reason is not valid UTF-8")
+		}
+	}
+
+	echo := ce.Code
+	if echo == CloseNoStatus {
+		echo = CloseNormal
+	}
+	c.sendClose(echo, "")
+	c.conn.Close()
+	return ce
+}
+
+// fail closes the connection after the peer broke the protocol.
+func (c *Conn) fail(code int, reason string) error {
+	c.sendClose(code, reason)
+	c.conn.Close()
+	return &CloseError{Code: code, Reason: reason}
+}
+
+func (c *Conn) sendClose(code int, reason string) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent {
+		return ErrClosed
+	}
+	c.closeSent = true
+
+	var payload []byte
+	if code != CloseNoStatus {
+		if len(reason) > maxControlPayload-2 {
+			reason = reason[:maxControlPayload-2]
+		}
+		payload = binary.BigEndian.AppendUint16(nil, uint16(code))
+		payload = append(payload, reason...)
+	}
+	return c.writeFrameLocked(opClose, true, payload)
+}
+
+func (c *Conn) writeFrame(opcode byte, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent {
+		return ErrClosed
+	}
+	return c.writeFrameLocked(opcode, true, payload)
+}
+
+func (c *Conn) writeFrameLocked(opcode byte, fin bool, payload []byte) error {
+	buf := make([]byte, 0, 14+len(payload))
+
+	b0 := opcode
+	if fin {
+		b0 |= 0x80
+	}
+	buf = append(buf, b0)
+
+	var maskBit byte
+	if c.client {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n <= maxControlPayload:
+		buf = append(buf, maskBit|byte(n))
+	case n <= math.MaxUint16:
+		buf = append(buf, maskBit|126)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(n))
+	default:
+		buf = append(buf, maskBit|127)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(n))
+	}
+
+	if !c.client {
+		buf = append(buf, payload...)
+	} else {
+		var key [4]byte
+		rand.Read(key[:])
+		buf = append(buf, key[:]...)
+		start := len(buf)
+		buf = append(buf, payload...)
+		maskBytes(key, buf[start:])
+	}
+
+	_, err := c.conn.Write(buf)
+	return err
+}
+
+func (c *Conn) maxMessageSize() int64 {
+	if c.MaxMessageSize > 0 {
+		return c.MaxMessageSize
+	}
+	return DefaultMaxMessageSize
+}
+
+func maskBytes(key [4]byte, b []byte) {
+	for i := range b {
+		b[i] ^= key[i%4]
+	}
+}
+
+// validCloseCode reports whether code may appear in a close frame (RFC 6455 section 7.4).
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1011:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,297 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/abdo-355/http-from-tcp/internal/headers"
+	"github.com/abdo-355/http-from-tcp/internal/request"
+	"github.com/abdo-355/http-from-tcp/internal/response"
+	"github.com/abdo-355/http-from-tcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startEcho serves a WebSocket echo endpoint and returns its ws:// URL. Errors returned
+// by ReadMessage on the server side are sent to errs.
+func startEcho(t *testing.T, configure func(*Conn), errs chan<- error) string {
+	t.Helper()
+	srv, err := server.Serve(0, func(w *response.Writer, req *request.Request) {
+		conn, err := Upgrade(w, req)
+		if err != nil {
+			return
+		}
+		defer conn.Close(CloseNormal, "")
+		if configure != nil {
+			configure(conn)
+		}
+		for {
+			t, msg, err := conn.ReadMessage()
+			if err != nil {
+				if errs != nil {
+					errs <- err
+				}
+				return
+			}
+			if err := conn.WriteMessage(t, msg); err != nil {
+				return
+			}
+		}
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { srv.Close() })
+
+	port := srv.Listener.Addr().(*net.TCPAddr).Port
+	return "ws://127.0.0.1:" + strconv.Itoa(port) + "/echo"
+}
+
+func TestAcceptKey(t *testing.T) {
+	// example from RFC 6455 section 1.3
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", AcceptKey("dGhlIHNhbXBsZSBub25jZQ=="))
+}
+
+func TestEcho(t *testing.T) {
+	url := startEcho(t, nil, nil)
+	c, err := Dial(url, headers.NewHeaders())
+	require.NoError(t, err)
+	defer c.Close(CloseNormal, "")
+
+	testCases := []struct {
+		name    string
+		msgType MessageType
+		data    []byte
+	}{
+		{name: "Text", msgType: TextMessage, data: []byte("hello")},
+		{name: "Empty", msgType: TextMessage, data: []byte{}},
+		{name: "Binary 16-bit length", msgType: BinaryMessage, data: bytes.Repeat([]byte{0xff}, 1000)},
+		{name: "Binary 64-bit length", msgType: BinaryMessage, data: bytes.Repeat([]byte{0x01}, 70000)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.NoError(t, c.WriteMessage(tc.msgType, tc.data))
+			msgType, data, err := c.ReadMessage()
+			require.NoError(t, err)
+			assert.Equal(t, tc.msgType, msgType)
+			assert.Equal(t, len(tc.data), len(data))
+			assert.True(t, bytes.Equal(tc.data, data))
+		})
+	}
+}
+
+func TestFragmentation(t *testing.T) {
+	url := startEcho(t, func(c *Conn) { c.FragmentSize = 3 }, nil)
+	c, err := Dial(url, headers.NewHeaders())
+	require.NoError(t, err)
+	defer c.Close(CloseNormal, "")
+	c.FragmentSize = 4
+
+	msg := "fragmented message"
+	require.NoError(t, c.WriteMessage(TextMessage, []byte(msg)))
+	msgType, data, err := c.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, TextMessage, msgType)
+	assert.Equal(t, msg, string(data))
+}
+
+func TestPingPong(t *testing.T) {
+	url := startEcho(t, nil, nil)
+	c, err := Dial(url, headers.NewHeaders())
+	require.NoError(t, err)
+	defer c.Close(CloseNormal, "")
+
+	pongs := make(chan string, 1)
+	c.OnPong = func(payload []byte) { pongs <- string(payload) }
+
+	require.NoError(t, c.Ping([]byte("are you there")))
+	// the pong arrives while waiting for the next message
+	require.NoError(t, c.WriteMessage(TextMessage, []byte("after ping")))
+	_, data, err := c.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, "after ping", string(data))
+	assert.Equal(t, "are you there", <-pongs)
+
+	assert.Error(t, c.Ping(bytes.Repeat([]byte("x"), 126)))
+}
+
+func TestCloseHandshake(t *testing.T) {
+	errs := make(chan error, 1)
+	url := startEcho(t, nil, errs)
+	c, err := Dial(url, headers.NewHeaders())
+	require.NoError(t, err)
+
+	require.NoError(t, c.Close(CloseGoingAway, "bye"))
+
+	var ce *CloseError
+	require.ErrorAs(t, <-errs, &ce)
+	assert.Equal(t, CloseGoingAway, ce.Code)
+	assert.Equal(t, "bye", ce.Reason)
+	assert.ErrorIs(t, c.WriteMessage(TextMessage, []byte("late")), ErrClosed)
+}
+
+func TestMaxMessageSize(t *testing.T) {
+	errs := make(chan error, 1)
+	url := startEcho(t, func(c *Conn) { c.MaxMessageSize = 10 }, errs)
+	c, err := Dial(url, headers.NewHeaders())
+	require.NoError(t, err)
+	c.FragmentSize = 4
+
+	require.NoError(t, c.WriteMessage(TextMessage, []byte("this message is too long")))
+
+	var ce *CloseError
+	_, _, err = c.ReadMessage()
+	require.ErrorAs(t, err, &ce)
+	assert.Equal(t, CloseMessageTooBig, ce.Code)
+	require.ErrorAs(t, <-errs, &ce)
+	assert.Equal(t, CloseMessageTooBig, ce.Code)
+}
+
+func TestReadMessage_ProtocolErrors(t *testing.T) {
+	testCases := []struct {
+		name  string
+		frame []byte
+		code  int
+	}{
+		{name: "Unmasked client frame", frame: []byte{0x81, 0x02, 'h', 'i'}, code: CloseProtocolError},
+		{name: "Reserved bits", frame: []byte{0xc1, 0x80, 0, 0, 0, 0}, code: CloseProtocolError},
+		{name: "Fragmented control frame", frame: []byte{0x09, 0x80, 0, 0, 0, 0}, code: CloseProtocolError},
+		{name: "Continuation without start", frame: []byte{0x80, 0x80, 0, 0, 0, 0}, code: CloseProtocolError},
+		{name: "Invalid UTF-8", frame: []byte{0x81, 0x81, 0, 0, 0, 0, 0xff}, code: CloseInvalidPayload},
+		{name: "Unknown opcode", frame: []byte{0x83, 0x80, 0, 0, 0, 0}, code: CloseProtocolError},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			serverSide, clientSide := net.Pipe()
+			defer clientSide.Close()
+			c := newConn(serverSide, bufio.NewReader(serverSide), false)
+
+			go clientSide.Write(tc.frame)
+			// drain the close frame sent back by the server
+			go func() {
+				buf := make([]byte, 256)
+				clientSide.Read(buf)
+			}()
+
+			_, _, err := c.ReadMessage()
+			var ce *CloseError
+			require.ErrorAs(t, err, &ce)
+			assert.Equal(t, tc.code, ce.Code)
+		})
+	}
+}
+
+func TestUpgrade_BadHandshake(t *testing.T) {
+	valid := map[string]string{
+		"connection":            "keep-alive, Upgrade",
+		"upgrade":               "websocket",
+		"sec-websocket-version": "13",
+		"sec-websocket-key":     "dGhlIHNhbXBsZSBub25jZQ==",
+		"host":                  "example.com",
+	}
+
+	testCases := []struct {
+		name     string
+		method   string
+		override map[string]string
+		status   int
+	}{
+		{name: "Wrong method", method: "POST", status: http.StatusBadRequest},
+		{name: "HEAD", method: "HEAD", status: http.StatusBadRequest},
+		{name: "Cross origin", method: "GET", override: map[string]string{"origin": "http://evil.example"}, status: http.StatusForbidden},
+		{name: "Missing upgrade", method: "GET", override: map[string]string{"upgrade": ""}, status: http.StatusBadRequest},
+		{name: "Bad key", method: "GET", override: map[string]string{"sec-websocket-key": "short"}, status: http.StatusBadRequest},
+		{name: "Old version", method: "GET", override: map[string]string{"sec-websocket-version": "8"}, status: http.StatusUpgradeRequired},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := headers.NewHeaders()
+			for k, v := range valid {
+				h.Set(k, v)
+			}
+			for k, v := range tc.override {
+				h.Set(k, v)
+			}
+			req := &request.Request{
+				RequestLine: request.RequestLine{Method: tc.method, RequestTarget: "/ws", HTTPVersion: "1.1"},
+				Headers:     h,
+			}
+
+			w := response.New()
+			c, err := Upgrade(w, req)
+			require.Error(t, err)
+			assert.Nil(t, c)
+			assert.Equal(t, tc.status, w.StatusCode())
+			assert.False(t, w.Hijacked())
+			assert.NotContains(t, string(w.Body()), "evil.example", "the refused origin is not echoed")
+			if tc.status == http.StatusUpgradeRequired {
+				rh := w.Header()
+				assert.Equal(t, "13", rh.Get("sec-websocket-version"))
+			}
+		})
+	}
+}
+
+func TestUpgrader_CheckOrigin(t *testing.T) {
+	testCases := []struct {
+		name   string
+		origin string
+		want   bool
+	}{
+		{name: "No origin", want: true},
+		{name: "Same origin", origin: "http://Example.com", want: true},
+		{name: "Same host, other port", origin: "http://example.com:8080", want: false},
+		{name: "Other host", origin: "http://other.example", want: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := &request.Request{Headers: headers.NewHeaders()}
+			req.Headers.Set("host", "example.com")
+			if tc.origin != "" {
+				req.Headers.Set("origin", tc.origin)
+			}
+			assert.Equal(t, tc.want, sameOrigin(req))
+		})
+	}
+
+	t.Run("Custom check", func(t *testing.T) {
+		req := &request.Request{
+			RequestLine: request.RequestLine{Method: "GET", RequestTarget: "/ws", HTTPVersion: "1.1"},
+			Headers:     headers.NewHeaders(),
+		}
+		req.Headers.Set("connection", "Upgrade")
+		req.Headers.Set("upgrade", "websocket")
+		req.Headers.Set("sec-websocket-version", "13")
+		req.Headers.Set("sec-websocket-key", "dGhlIHNhbXBsZSBub25jZQ==")
+
+		w := response.New()
+		u := &Upgrader{CheckOrigin: func(*request.Request) bool { return false }}
+		_, err := u.Upgrade(w, req)
+		require.Error(t, err)
+		assert.Equal(t, http.StatusForbidden, w.StatusCode())
+	})
+}
+
+func TestDial_RejectedHandshake(t *testing.T) {
+	srv, err := server.Serve(0, func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine("HTTP/1.1", http.StatusForbidden, "Forbidden")
+		w.WriteHeaders(headers.NewHeaders())
+	})
+	require.NoError(t, err)
+	defer srv.Close()
+
+	port := srv.Listener.Addr().(*net.TCPAddr).Port
+	_, err = Dial("ws://127.0.0.1:"+strconv.Itoa(port)+"/", headers.NewHeaders())
+	require.Error(t, err)
+	assert.True(t, strings.Contains(err.Error(), "403"))
+
+	_, err = Dial("http://127.0.0.1/", headers.NewHeaders())
+	assert.Error(t, err)
+}